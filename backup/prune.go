@@ -0,0 +1,99 @@
+package backup
+
+import (
+	"context"
+	"strings"
+
+	"datasyncer/types"
+)
+
+// PrunePlan is what pruning a backup root under a retention policy
+// removes.
+type PrunePlan struct {
+	Decisions []Decision
+	// Manifests are the manifest keys of the snapshots to remove. They go
+	// first, so an interrupted prune never leaves a listed snapshot with
+	// missing data.
+	Manifests []string
+	// Stale are the IDs of the prefixes whose data is cleaned up: removed
+	// snapshots and unfinished backups older than the newest snapshot.
+	Stale []string
+
+	root       string
+	referenced map[string]bool
+}
+
+// PlanPrune applies policy to the snapshots under root.
+func PlanPrune(ctx context.Context, storage types.CloudStorage, root string, policy types.RetentionPolicy) (*PrunePlan, error) {
+	ids, err := IDs(ctx, storage, root)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := List(ctx, storage, root)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &PrunePlan{
+		Decisions:  Apply(policy, snapshots),
+		root:       root,
+		referenced: make(map[string]bool),
+	}
+	complete := make(map[string]bool, len(snapshots))
+	removed := make(map[string]bool)
+	for _, decision := range plan.Decisions {
+		id := decision.Snapshot.ID
+		complete[id] = true
+		if !decision.Keep {
+			removed[id] = true
+			plan.Manifests = append(plan.Manifests, ManifestKey(root, id))
+			continue
+		}
+		for _, file := range decision.Snapshot.Files {
+			plan.referenced[DataPrefix(root, file.Snapshot)+file.Path] = true
+		}
+	}
+
+	newest := ""
+	if len(snapshots) > 0 {
+		newest = snapshots[len(snapshots)-1].ID
+	}
+	for _, id := range ids {
+		// A prefix newer than the newest snapshot may be a backup that is
+		// still running.
+		if removed[id] || (!complete[id] && id < newest) {
+			plan.Stale = append(plan.Stale, id)
+		}
+	}
+	return plan, nil
+}
+
+// Removed returns the snapshots the plan removes.
+func (p *PrunePlan) Removed() []*Snapshot {
+	var removed []*Snapshot
+	for _, decision := range p.Decisions {
+		if !decision.Keep {
+			removed = append(removed, decision.Snapshot)
+		}
+	}
+	return removed
+}
+
+// EachGarbage calls fn with every key under the stale prefixes that no
+// kept snapshot references, other than the manifests.
+func (p *PrunePlan) EachGarbage(ctx context.Context, storage types.CloudStorage, fn func(key string) error) error {
+	for _, id := range p.Stale {
+		prefix := Prefix(p.root, id)
+		manifest := ManifestKey(p.root, id)
+		err := storage.ListIter(ctx, prefix, func(file types.FileInfo) error {
+			if !strings.HasPrefix(file.Path, prefix) || file.Path == manifest || p.referenced[file.Path] {
+				return nil
+			}
+			return fn(file.Path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"datasyncer/types"
+)
+
+// Decision is whether a retention policy keeps a snapshot, and why.
+type Decision struct {
+	Snapshot *Snapshot
+	Keep     bool
+	// Reasons names the rules that keep the snapshot.
+	Reasons []string
+}
+
+// Apply decides which of snapshots policy keeps. Periods are calendar
+// days, ISO weeks and months in local time. Decisions are returned newest
+// first. A zero policy keeps everything.
+func Apply(policy types.RetentionPolicy, snapshots []*Snapshot) []Decision {
+	sorted := make([]*Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	rules := []struct {
+		name   string
+		count  int
+		period func(s *Snapshot) string
+		last   string
+	}{
+		{name: "last", count: policy.KeepLast, period: func(s *Snapshot) string { return s.ID }},
+		{name: "daily", count: policy.KeepDaily, period: func(s *Snapshot) string {
+			return s.Time.Local().Format("2006-01-02")
+		}},
+		{name: "weekly", count: policy.KeepWeekly, period: func(s *Snapshot) string {
+			year, week := s.Time.Local().ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{name: "monthly", count: policy.KeepMonthly, period: func(s *Snapshot) string {
+			return s.Time.Local().Format("2006-01")
+		}},
+	}
+
+	decisions := make([]Decision, 0, len(sorted))
+	for _, snapshot := range sorted {
+		decision := Decision{Snapshot: snapshot, Keep: policy.IsZero()}
+		for i := range rules {
+			rule := &rules[i]
+			if rule.count == 0 {
+				continue
+			}
+			// Snapshots are newest first, so the first one seen in a
+			// period is the one that represents it.
+			if period := rule.period(snapshot); period != rule.last {
+				rule.last = period
+				rule.count--
+				decision.Keep = true
+				decision.Reasons = append(decision.Reasons, rule.name)
+			}
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions
+}
+
+// String describes the decision, such as "keep (daily, weekly)".
+func (d Decision) String() string {
+	if !d.Keep {
+		return "remove"
+	}
+	if len(d.Reasons) == 0 {
+		return "keep"
+	}
+	return "keep (" + strings.Join(d.Reasons, ", ") + ")"
+}
@@ -0,0 +1,230 @@
+// Package backup stores point-in-time snapshots of a source under a
+// backup root. Each snapshot has its own prefix named by its ID:
+//
+//	<root>/<id>/manifest.json
+//	<root>/<id>/data/<path>
+//
+// A snapshot's data prefix only holds the files that changed since the
+// previous snapshot; its manifest lists every file and the snapshot whose
+// data prefix holds it. The manifest is written last, so a prefix without
+// one is a backup that did not finish.
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"datasyncer/types"
+)
+
+// IDFormat names snapshots by their start time in UTC, so IDs sort in
+// time order.
+const IDFormat = "20060102T150405Z"
+
+const manifestName = "manifest.json"
+
+// File is one file of a snapshot.
+type File struct {
+	// Path is relative to the backed-up source path.
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	ETag    string    `json:"etag,omitempty"`
+	// Snapshot is the ID of the snapshot whose data prefix holds the file.
+	Snapshot string `json:"snapshot"`
+}
+
+// Unchanged reports whether f has the same content as an earlier backup
+// of the file, by ETag or else by size and modification time.
+func (f File) Unchanged(earlier File) bool {
+	if f.Size != earlier.Size {
+		return false
+	}
+	if f.ETag != "" && earlier.ETag != "" {
+		return f.ETag == earlier.ETag
+	}
+	return !f.ModTime.IsZero() && f.ModTime.Equal(earlier.ModTime)
+}
+
+// Snapshot is a snapshot's manifest.
+type Snapshot struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	// TotalFiles and TotalBytes count every file; NewFiles and NewBytes
+	// only those stored in this snapshot's own data prefix.
+	TotalFiles int    `json:"total_files"`
+	TotalBytes int64  `json:"total_bytes"`
+	NewFiles   int    `json:"new_files"`
+	NewBytes   int64  `json:"new_bytes"`
+	Files      []File `json:"files"`
+}
+
+// New starts an empty snapshot taken at now.
+func New(now time.Time, source string) *Snapshot {
+	now = now.UTC().Truncate(time.Second)
+	return &Snapshot{ID: now.Format(IDFormat), Time: now, Source: source}
+}
+
+// Add adds a file to the snapshot.
+func (s *Snapshot) Add(file File) {
+	s.Files = append(s.Files, file)
+	s.TotalFiles++
+	s.TotalBytes += file.Size
+	if file.Snapshot == s.ID {
+		s.NewFiles++
+		s.NewBytes += file.Size
+	}
+}
+
+// Remove drops the files whose paths are in paths, such as files that
+// failed to copy.
+func (s *Snapshot) Remove(paths map[string]bool) {
+	if len(paths) == 0 {
+		return
+	}
+	files := s.Files
+	*s = Snapshot{ID: s.ID, Time: s.Time, Source: s.Source}
+	for _, file := range files {
+		if !paths[file.Path] {
+			s.Add(file)
+		}
+	}
+}
+
+// FileMap indexes the snapshot's files by path.
+func (s *Snapshot) FileMap() map[string]File {
+	files := make(map[string]File, len(s.Files))
+	for _, file := range s.Files {
+		files[file.Path] = file
+	}
+	return files
+}
+
+// Prefix returns the prefix of snapshot id under root.
+func Prefix(root, id string) string {
+	if root = strings.TrimSuffix(root, "/"); root == "" {
+		return id + "/"
+	}
+	return root + "/" + id + "/"
+}
+
+// DataPrefix returns the prefix that holds snapshot id's new files.
+func DataPrefix(root, id string) string {
+	return Prefix(root, id) + "data/"
+}
+
+// ManifestKey returns the key of snapshot id's manifest.
+func ManifestKey(root, id string) string {
+	return Prefix(root, id) + manifestName
+}
+
+// IDs returns the IDs of the snapshot prefixes under root, oldest first,
+// whether or not they have a manifest.
+func IDs(ctx context.Context, storage types.CloudStorage, root string) ([]string, error) {
+	dir := strings.TrimSuffix(root, "/")
+	if dir != "" {
+		dir += "/"
+	}
+	var ids []string
+	err := storage.ListDir(ctx, dir, "/", func(entry types.DirEntry) error {
+		if !entry.IsPrefix {
+			return nil
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(entry.Path, dir), "/")
+		if _, err := time.Parse(IDFormat, id); err == nil {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// List loads the manifests of the complete snapshots under root, oldest
+// first.
+func List(ctx context.Context, storage types.CloudStorage, root string) ([]*Snapshot, error) {
+	ids, err := IDs(ctx, storage, root)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []*Snapshot
+	for _, id := range ids {
+		snapshot, err := Load(ctx, storage, root, id)
+		if err != nil {
+			if _, statErr := storage.GetFileInfo(ctx, ManifestKey(root, id)); statErr != nil {
+				// No manifest: the backup did not finish.
+				continue
+			}
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// Latest returns the newest complete snapshot under root, or nil when
+// there is none.
+func Latest(ctx context.Context, storage types.CloudStorage, root string) (*Snapshot, error) {
+	ids, err := IDs(ctx, storage, root)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		if _, err := storage.GetFileInfo(ctx, ManifestKey(root, ids[i])); err != nil {
+			continue
+		}
+		return Load(ctx, storage, root, ids[i])
+	}
+	return nil, nil
+}
+
+// Load reads the manifest of snapshot id.
+func Load(ctx context.Context, storage types.CloudStorage, root, id string) (*Snapshot, error) {
+	reader, err := storage.OpenFile(ctx, ManifestKey(root, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest of snapshot %s: %v", id, err)
+	}
+	defer reader.Close()
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(reader).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to read manifest of snapshot %s: %v", id, err)
+	}
+	return &snapshot, nil
+}
+
+// Save writes the snapshot's manifest, which marks it complete.
+func Save(ctx context.Context, storage types.CloudStorage, root string, snapshot *Snapshot) error {
+	sort.Slice(snapshot.Files, func(i, j int) bool {
+		return snapshot.Files[i].Path < snapshot.Files[j].Path
+	})
+
+	temp, err := os.CreateTemp("", "datasyncer-manifest-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	err = json.NewEncoder(temp).Encode(snapshot)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	info := types.FileInfo{ContentType: "application/json", ModTime: snapshot.Time}
+	if err := storage.UploadFile(ctx, temp.Name(), ManifestKey(root, snapshot.ID), info); err != nil {
+		return fmt.Errorf("failed to upload manifest of snapshot %s: %v", snapshot.ID, err)
+	}
+	return nil
+}
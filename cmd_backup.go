@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/types"
+)
+
+func backupCmd() *cobra.Command {
+	var prune bool
+
+	cmd := &cobra.Command{
+		Use:   "backup [source] [remote:path]",
+		Short: "Take a snapshot of a source under a backup path",
+		Long: `Copy the source into a new snapshot under the backup path, named by the
+time it was taken, for example backups/exports/20261018T020000Z/. Files
+unchanged since the previous snapshot are not copied again; the snapshot's
+manifest refers to the earlier copy. The manifest is written last, so an
+interrupted backup does not show up in "snapshots list".
+
+Unlike sync --conflict archive, which keeps every overwritten file next to
+the current one with a timestamp suffix, each snapshot is a complete view of
+the source at one time, and old snapshots are removed with "snapshots prune"
+or --prune.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			source, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			destination, err := config.ParseRemotePath(args[1])
+			if err != nil {
+				return err
+			}
+
+			cfg := getConfig(cmd)
+			opts := cfg.SyncOptions(source, destination)
+			applySyncFlags(cmd, &opts)
+			if err := validateTransferFlags(opts); err != nil {
+				return err
+			}
+			policy := cfg.Backup.Retention
+			if prune && policy.IsZero() {
+				return fmt.Errorf("--prune needs backup.retention in the config")
+			}
+
+			if _, err := connectRemote(cmd, source.Remote); err != nil {
+				return err
+			}
+			if _, err := connectRemote(cmd, destination.Remote); err != nil {
+				return err
+			}
+
+			sm := getSyncManager(cmd)
+			snapshot, report, err := sm.Backup(cmd.Context(), opts)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Snapshot %s: %d files (%s), %d new (%s)\n", snapshot.ID,
+				snapshot.TotalFiles, types.FormatBytes(snapshot.TotalBytes),
+				snapshot.NewFiles, types.FormatBytes(snapshot.NewBytes))
+			fmt.Fprintln(out, report.Summary())
+			if report.Failed > 0 {
+				return fmt.Errorf("%d files failed to back up", report.Failed)
+			}
+
+			if prune {
+				return pruneSnapshots(cmd, destination, policy, opts.Parallel)
+			}
+			return nil
+		},
+	}
+
+	addTransferFlags(cmd)
+	cmd.Flags().BoolVar(&prune, "prune", false, "Prune snapshots by backup.retention after a backup without failures")
+	return cmd
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+)
+
+func catCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cat [remote:path]...",
+		Short: "Write files to standard output",
+		Long: `Stream the named files to standard output in order, without staging them on
+disk, for example:
+
+  datasyncer cat prod-s3:exports/day.csv.gz | gunzip | head`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, arg := range args {
+				location, err := config.ParseRemotePath(arg)
+				if err != nil {
+					return err
+				}
+				provider, err := connectRemote(cmd, location.Remote)
+				if err != nil {
+					return err
+				}
+
+				reader, err := provider.OpenFile(cmd.Context(), location.Path)
+				if err != nil {
+					return fmt.Errorf("cannot read %s: %v", location, err)
+				}
+				_, err = io.Copy(cmd.OutOrStdout(), reader)
+				reader.Close()
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %v", location, err)
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"datasyncer/config"
+)
+
+const sampleConfig = `# datasyncer configuration
+#
+# remotes:
+#   prod-s3:
+#     type: aws
+#     bucket: my-bucket
+#     region: eu-west-1
+#   dr-gcs:
+#     type: gcp
+#     bucket: my-dr-bucket
+#     quota_project: my-project   # optional: billed for requests, e.g. to requester-pays buckets
+#     credentials_file: /etc/datasyncer/gcp-key.json
+#   archive:
+#     type: azure
+#     credentials: azure   # profile saved by "datasyncer auth azure"
+#     container_name: archive
+#     storage_class: cool   # hot, cool, cold, archive or a provider class
+#     storage_rules:        # the first matching rule wins
+#       - include: ["*.parquet"]
+#         older_than: 2160h   # 90 days
+#         storage_class: cold
+#   vault:
+#     type: aws
+#     bucket: my-vault
+#     encryption:           # encrypt files before they leave this machine
+#       keyfile: /etc/datasyncer/vault.key  # 32 bytes, e.g. from openssl rand -hex 32
+#       # passphrase_env: VAULT_PASSPHRASE  # or derive the key with scrypt
+#       encrypt_names: true
+#
+# storage_classes:          # class a tier maps to, per provider type
+#   archive:
+#     aws: GLACIER
+
+remotes: {}
+
+defaults:
+  parallel: 4
+  conflict_resolution: skip
+  # restore:                # used by sync --restore for archived files
+  #   days: 7               # how long S3 keeps restored copies
+  #   priority: standard    # bulk, standard or expedited
+  #   poll_interval: 15m
+  #   timeout: 48h
+
+# backup:
+#   retention:              # used by snapshots prune and backup --prune
+#     keep_last: 3
+#     keep_daily: 7
+#     keep_weekly: 4
+#     keep_monthly: 12
+
+logger:
+  file: sync.log
+  level: info
+`
+
+func configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Validate, show and edit the configuration file",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration for unknown keys and missing fields",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if configLoadErr != nil {
+				return configLoadErr
+			}
+			cfg := getConfig(cmd)
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if cfg.File == "" {
+				fmt.Fprintln(cmd.OutOrStdout(), "No config file found; using defaults")
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s is valid (%d remotes)\n", cfg.File, len(cfg.Remotes))
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "show",
+		Short: "Print the effective configuration with secrets masked",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := yaml.Marshal(config.Settings())
+			if err != nil {
+				return fmt.Errorf("failed to render config: %v", err)
+			}
+			if file := getConfig(cmd).File; file != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "# %s\n", file)
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "edit",
+		Short: "Open the configuration file in $EDITOR and validate it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := getConfig(cmd).File
+			if path == "" {
+				var err error
+				if path, err = config.DefaultPath(); err != nil {
+					return err
+				}
+			}
+
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+					return fmt.Errorf("failed to create config directory: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(sampleConfig), 0600); err != nil {
+					return fmt.Errorf("failed to create config file: %v", err)
+				}
+			}
+
+			editor := os.Getenv("VISUAL")
+			if editor == "" {
+				editor = os.Getenv("EDITOR")
+			}
+			if editor == "" {
+				editor = "vi"
+			}
+
+			edit := exec.Command(editor, path)
+			edit.Stdin = os.Stdin
+			edit.Stdout = os.Stdout
+			edit.Stderr = os.Stderr
+			if err := edit.Run(); err != nil {
+				return fmt.Errorf("editor exited with error: %v", err)
+			}
+
+			cfg, err := config.LoadFile(path)
+			if err != nil {
+				return fmt.Errorf("saved, but the config is invalid: %v", err)
+			}
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("saved, but the config is invalid: %v", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s is valid\n", path)
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func isConfigCommand(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Name() == "config" && c.Parent() == rootCmd {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/providers"
+	"datasyncer/types"
+)
+
+func cpCmd() *cobra.Command {
+	var contentType string
+	var metadata []string
+
+	cmd := &cobra.Command{
+		Use:   "cp [source] [destination]",
+		Short: "Copy a single file between remotes or local paths",
+		Long: `Copy one file, replacing the destination if it exists. Either side may be a
+configured remote written as remote:path or a local path. A destination
+ending in "/" or naming a local directory receives the source's file name.
+With "-" as the source the file is read from standard input:
+
+  datasyncer cp prod-s3:exports/day.csv ./
+  pg_dump mydb | datasyncer cp - prod-s3:backups/mydb.sql
+
+Headers and user metadata are copied with the file. Use sync to copy
+everything under a prefix.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Files copied from a remote keep their own headers and metadata;
+			// these flags describe standard input.
+			stdin := types.FileInfo{ContentType: contentType}
+			for _, pair := range metadata {
+				key, value, ok := strings.Cut(pair, "=")
+				if !ok || key == "" {
+					return fmt.Errorf("--metadata must be key=value, got %q", pair)
+				}
+				if stdin.Metadata == nil {
+					stdin.Metadata = make(map[string]string)
+				}
+				stdin.Metadata[key] = value
+			}
+			return copyObject(cmd, args[0], args[1], false, stdin)
+		},
+	}
+
+	cmd.Flags().StringVar(&contentType, "content-type", "", "With - as the source, content type to store (default from the destination's extension)")
+	cmd.Flags().StringArrayVar(&metadata, "metadata", nil, "With - as the source, user metadata to store as key=value (repeatable)")
+	return cmd
+}
+
+func mvCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "mv [source] [destination]",
+		Short: "Move a single file between remotes or local paths",
+		Long: `Copy one file like cp, then delete the source once the copy has succeeded.
+On the same remote this is still a copy and a delete; no provider offers an
+atomic rename of objects.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return copyObject(cmd, args[0], args[1], true, types.FileInfo{})
+		},
+	}
+}
+
+// copyObject implements cp and mv. With move set the source is deleted
+// after a successful copy. stdin holds the headers and metadata stored
+// when the source is standard input.
+func copyObject(cmd *cobra.Command, sourceArg, destArg string, move bool, stdin types.FileInfo) error {
+	destination, err := config.ParseRemotePath(destArg)
+	if err != nil {
+		return err
+	}
+	if _, err := connectRemote(cmd, destination.Remote); err != nil {
+		return err
+	}
+
+	sm := getSyncManager(cmd)
+	ctx := cmd.Context()
+	out := cmd.OutOrStdout()
+
+	if sourceArg == "-" {
+		if move {
+			return fmt.Errorf("cannot move from standard input")
+		}
+		if destination.Path == "" || strings.HasSuffix(destination.Path, "/") {
+			return fmt.Errorf("a file name is required when copying from standard input")
+		}
+		if stdin.ContentType == "" {
+			stdin.ContentType = mime.TypeByExtension(path.Ext(destination.Path))
+		}
+		size, err := sm.CopyFrom(ctx, cmd.InOrStdin(), types.CloudProvider(destination.Remote), destination.Path, stdin)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Copied %s from standard input to %s\n", types.FormatBytes(size), destination)
+		return nil
+	}
+
+	source, err := config.ParseRemotePath(sourceArg)
+	if err != nil {
+		return err
+	}
+	if source.Path == "" || strings.HasSuffix(source.Path, "/") {
+		return fmt.Errorf("%s is a prefix; use sync to copy everything below it", source)
+	}
+	provider, err := connectRemote(cmd, source.Remote)
+	if err != nil {
+		return err
+	}
+
+	if destination.Path, err = copyTarget(cmd, source.Path, destination); err != nil {
+		return err
+	}
+	if source == destination {
+		return fmt.Errorf("%s and %s are the same file", source, destination)
+	}
+
+	info, err := sm.Copy(ctx, types.CloudProvider(source.Remote), source.Path, types.CloudProvider(destination.Remote), destination.Path)
+	if err != nil {
+		return err
+	}
+
+	if move {
+		if err := provider.DeleteFile(ctx, source.Path); err != nil {
+			return fmt.Errorf("copied to %s but failed to delete %s: %v", destination, source, err)
+		}
+		fmt.Fprintf(out, "Moved %s to %s (%s)\n", source, destination, types.FormatBytes(info.Size))
+		return nil
+	}
+	fmt.Fprintf(out, "Copied %s to %s (%s)\n", source, destination, types.FormatBytes(info.Size))
+	return nil
+}
+
+// copyTarget returns the destination key for copying sourceKey: the
+// source's base name is appended when the destination is a prefix, the
+// root of a remote, or an existing local directory.
+func copyTarget(cmd *cobra.Command, sourceKey string, destination config.RemotePath) (string, error) {
+	name := path.Base(sourceKey)
+	if destination.Path == "" || strings.HasSuffix(destination.Path, "/") {
+		return destination.Path + name, nil
+	}
+
+	providerConfig, err := getConfig(cmd).ProviderConfig(destination.Remote)
+	if err != nil {
+		return "", err
+	}
+	if providerConfig.Type == types.LOCAL {
+		if info, err := os.Stat(providers.LocalPath(providerConfig.Path, destination.Path)); err == nil && info.IsDir() {
+			return destination.Path + "/" + name, nil
+		}
+	}
+	return destination.Path, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/jobs"
+)
+
+func daemonCmd() *cobra.Command {
+	var statePath string
+
+	cmd := &cobra.Command{
+		Use:   "daemon [jobs file]",
+		Short: "Run the jobs in a jobs file on their schedules",
+		Long: `Run every job in a jobs file that has a schedule (a cron expression such as
+"0 2 * * *" or "@hourly") or an every interval (such as "15m"). A run that
+is due while the previous run of the same job is still going is skipped.
+Last-run outcomes are kept in the state file; an interrupted run picks up
+where it left off on the job's next run. Stop with SIGINT or SIGTERM.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifest, err := jobs.LoadManifest(args[0])
+			if err != nil {
+				return err
+			}
+			cfg := getConfig(cmd)
+			if err := manifest.Validate(cfg); err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			runner := &jobs.Runner{
+				Config:      cfg,
+				Manager:     getSyncManager(cmd),
+				Concurrency: manifest.Concurrency,
+				RetryFailed: true,
+				Connect: func(ctx context.Context, remote string) error {
+					_, err := connectRemote(cmd, remote)
+					return err
+				},
+			}
+			return jobs.NewScheduler(runner, statePath).Run(ctx, manifest.Jobs)
+		},
+	}
+
+	cmd.Flags().StringVar(&statePath, "state", "daemon_state.json", "File that keeps the last-run outcome of each job")
+	return cmd
+}
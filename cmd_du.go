@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/inventory"
+	"datasyncer/types"
+)
+
+// duReport is the JSON form of du's output.
+type duReport struct {
+	Location       string            `json:"location"`
+	Depth          int               `json:"depth"`
+	Files          int64             `json:"files"`
+	Bytes          int64             `json:"bytes"`
+	ByPrefix       []inventory.Group `json:"by_prefix"`
+	ByExtension    []inventory.Group `json:"by_extension"`
+	ByStorageClass []inventory.Group `json:"by_storage_class"`
+	ByAge          []inventory.Group `json:"by_age"`
+}
+
+func duCmd() *cobra.Command {
+	var depth, top int
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "du [remote:prefix]",
+		Short: "Summarize file counts and sizes below a prefix",
+		Long: `List every file below a prefix and total the file counts and bytes by
+prefix (--depth levels below the listed one), by extension, by storage
+class and by age since the files were last modified. Useful to size a sync before
+paying egress for it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			provider, err := connectRemote(cmd, location.Remote)
+			if err != nil {
+				return err
+			}
+
+			usage := inventory.NewUsage(location.Path, depth, time.Now())
+			err = provider.ListIter(cmd.Context(), location.Path, func(file types.FileInfo) error {
+				usage.Add(file)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				encoder.SetEscapeHTML(false)
+				return encoder.Encode(duReport{
+					Location:       location.String(),
+					Depth:          usage.Depth,
+					Files:          usage.Total.Files,
+					Bytes:          usage.Total.Bytes,
+					ByPrefix:       usage.ByPrefix(),
+					ByExtension:    usage.ByExtension(),
+					ByStorageClass: usage.ByStorageClass(),
+					ByAge:          usage.ByAge(),
+				})
+			}
+
+			fmt.Fprintf(out, "%d files, %s in %s\n", usage.Total.Files, types.FormatBytes(usage.Total.Bytes), location)
+			if usage.Total.Files == 0 {
+				return nil
+			}
+			printGroups(out, fmt.Sprintf("By prefix (depth %d)", usage.Depth), usage.ByPrefix(), usage.Total, top)
+			printGroups(out, "By extension", usage.ByExtension(), usage.Total, top)
+			printGroups(out, "By storage class", usage.ByStorageClass(), usage.Total, top)
+			printGroups(out, "By age", usage.ByAge(), usage.Total, 0)
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&depth, "depth", 1, "Group files by this many directory levels below the prefix")
+	cmd.Flags().IntVar(&top, "top", 20, "Show only the largest groups of each breakdown (0 for all)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print JSON with every group")
+	return cmd
+}
+
+// printGroups prints one breakdown as aligned columns of file count, size
+// and share of the total bytes. With top > 0 the remaining groups are
+// folded into one line.
+func printGroups(out io.Writer, title string, groups []inventory.Group, total inventory.Group, top int) {
+	if top > 0 && len(groups) > top {
+		rest := inventory.Group{Key: fmt.Sprintf("(%d more)", len(groups)-top)}
+		for _, g := range groups[top:] {
+			rest.Files += g.Files
+			rest.Bytes += g.Bytes
+		}
+		groups = append(groups[:top:top], rest)
+	}
+
+	fmt.Fprintf(out, "\n%s:\n", title)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, g := range groups {
+		share := 0.0
+		if total.Bytes > 0 {
+			share = 100 * float64(g.Bytes) / float64(total.Bytes)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.1f%%\t  %s\n", g.Files, types.FormatBytes(g.Bytes), share, g.Key)
+	}
+	w.Flush()
+}
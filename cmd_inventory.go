@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/inventory"
+	"datasyncer/types"
+)
+
+func inventoryCmd() *cobra.Command {
+	var output, format string
+
+	cmd := &cobra.Command{
+		Use:   "inventory [remote:prefix]",
+		Short: "Write a listing of every file below a prefix",
+		Long: `Write one record per file below a prefix with its remote, path, size, upload
+and modification times, ETag and storage class, as CSV, JSON Lines or Parquet.
+The format defaults to the --output file's extension, or CSV on standard
+output.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			if format == "" {
+				format = inventory.FormatForPath(output)
+			}
+			if format == "" {
+				format = inventory.FormatCSV
+			}
+
+			provider, err := connectRemote(cmd, location.Remote)
+			if err != nil {
+				return err
+			}
+
+			var out io.Writer = cmd.OutOrStdout()
+			var file *os.File
+			if output != "" && output != "-" {
+				if file, err = os.Create(output); err != nil {
+					return fmt.Errorf("failed to create inventory file: %v", err)
+				}
+				defer file.Close()
+				out = file
+			}
+
+			writer, err := inventory.NewWriter(out, format)
+			if err != nil {
+				return err
+			}
+			var count int64
+			err = provider.ListIter(cmd.Context(), location.Path, func(info types.FileInfo) error {
+				count++
+				return writer.Write(inventory.NewRecord(location.Remote, info))
+			})
+			if closeErr := writer.Close(); err == nil {
+				err = closeErr
+			}
+			if file != nil {
+				if closeErr := file.Close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					os.Remove(output)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write inventory: %v", err)
+			}
+
+			if file != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Wrote %d files to %s\n", count, output)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write to this file instead of standard output")
+	cmd.Flags().StringVar(&format, "format", "", "Output format: csv, jsonl or parquet")
+	return cmd
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/types"
+)
+
+// lsEntry is the JSON form of a listing entry.
+type lsEntry struct {
+	Path         string    `json:"path"`
+	IsPrefix     bool      `json:"is_prefix,omitempty"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+}
+
+func lsCmd() *cobra.Command {
+	var recursive, long, asJSON, reverse bool
+	var sortBy, delimiter string
+
+	cmd := &cobra.Command{
+		Use:   "ls [remote:path]",
+		Short: "List files and prefixes on a remote",
+		Long: `List one level of a remote like a directory, showing common prefixes as
+entries ending in the delimiter, or every file below the path with -R.
+A path naming a prefix without the trailing delimiter lists its contents.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			switch sortBy {
+			case "name", "size", "time":
+			default:
+				return fmt.Errorf("--sort must be name, size or time, got %q", sortBy)
+			}
+
+			provider, err := connectRemote(cmd, location.Remote)
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			prefix := location.Path
+			var entries []types.DirEntry
+			collect := func(entry types.DirEntry) error {
+				entries = append(entries, entry)
+				return nil
+			}
+
+			if recursive {
+				err = provider.ListIter(ctx, prefix, func(file types.FileInfo) error {
+					return collect(types.DirEntry{FileInfo: file})
+				})
+			} else {
+				err = provider.ListDir(ctx, prefix, delimiter, collect)
+				// "ls remote:logs" means the logs "directory", not every key
+				// starting with "logs".
+				if err == nil && prefix != "" && !strings.HasSuffix(prefix, delimiter) &&
+					len(entries) == 1 && entries[0].IsPrefix && entries[0].Path == prefix+delimiter {
+					prefix += delimiter
+					entries = nil
+					err = provider.ListDir(ctx, prefix, delimiter, collect)
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			sortEntries(entries, sortBy, reverse)
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				items := make([]lsEntry, 0, len(entries))
+				for _, entry := range entries {
+					items = append(items, lsEntry{
+						Path:         entry.Path,
+						IsPrefix:     entry.IsPrefix,
+						Size:         entry.Size,
+						LastModified: entry.LastModified,
+						ETag:         entry.ETag,
+					})
+				}
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(items)
+			}
+
+			// Names are shown relative to the listed directory.
+			base := prefix
+			if i := strings.LastIndex(prefix, delimiter); i >= 0 {
+				base = prefix[:i+len(delimiter)]
+			} else {
+				base = ""
+			}
+
+			if !long {
+				for _, entry := range entries {
+					fmt.Fprintln(out, strings.TrimPrefix(entry.Path, base))
+				}
+				return nil
+			}
+
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+			var files, prefixes int
+			var total int64
+			for _, entry := range entries {
+				name := strings.TrimPrefix(entry.Path, base)
+				if entry.IsPrefix {
+					prefixes++
+					fmt.Fprintf(w, "PRE\t\t  %s\n", name)
+					continue
+				}
+				files++
+				total += entry.Size
+				fmt.Fprintf(w, "%s\t%s\t  %s\n", types.FormatBytes(entry.Size), entry.LastModified.Local().Format("2006-01-02 15:04"), name)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%d files, %d prefixes, %s\n", files, prefixes, types.FormatBytes(total))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&recursive, "recursive", "R", false, "List every file below the path")
+	cmd.Flags().BoolVarP(&long, "long", "l", false, "Show size and modification time")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print entries as JSON")
+	cmd.Flags().StringVar(&sortBy, "sort", "name", "Sort by name, size or time")
+	cmd.Flags().BoolVarP(&reverse, "reverse", "r", false, "Reverse the sort order")
+	cmd.Flags().StringVar(&delimiter, "delimiter", "/", "Delimiter that separates path levels")
+	return cmd
+}
+
+// sortEntries orders entries by the given key. Prefixes have no size or
+// time, so they sort by name ahead of files.
+func sortEntries(entries []types.DirEntry, by string, reverse bool) {
+	less := func(a, b types.DirEntry) bool {
+		if a.IsPrefix != b.IsPrefix {
+			return a.IsPrefix
+		}
+		switch {
+		case by == "size" && a.Size != b.Size && !a.IsPrefix:
+			return a.Size < b.Size
+		case by == "time" && !a.LastModified.Equal(b.LastModified) && !a.IsPrefix:
+			return a.LastModified.Before(b.LastModified)
+		}
+		return a.Path < b.Path
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if reverse {
+			return less(entries[j], entries[i])
+		}
+		return less(entries[i], entries[j])
+	})
+}
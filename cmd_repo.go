@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/repo"
+	"datasyncer/types"
+)
+
+func repoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repo",
+		Short: "Back up into a deduplicating repository",
+		Long: `Manage a deduplicating backup repository on any remote. Files are split into
+content-defined chunks that are stored once under their SHA-256, so
+snapshots of mostly unchanged data only store the chunks that changed, even
+within large files. Each snapshot is an index of the chunks of every file.
+
+  datasyncer repo init dr-gcs:repos/exports
+  datasyncer repo backup prod-s3:exports/ dr-gcs:repos/exports
+  datasyncer repo restore dr-gcs:repos/exports latest ./restored/`,
+	}
+	cmd.AddCommand(repoInitCmd())
+	cmd.AddCommand(repoBackupCmd())
+	cmd.AddCommand(repoSnapshotsCmd())
+	cmd.AddCommand(repoRestoreCmd())
+	cmd.AddCommand(repoCheckCmd())
+	cmd.AddCommand(repoGCCmd())
+	return cmd
+}
+
+// openRepo connects to the remote of location and opens the repository
+// there.
+func openRepo(cmd *cobra.Command, location config.RemotePath) (*repo.Repository, error) {
+	provider, err := connectRemote(cmd, location.Remote)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Open(cmd.Context(), provider, location.Path)
+}
+
+// repoParallel returns the --parallel flag, or the configured default.
+func repoParallel(cmd *cobra.Command) int {
+	if cmd.Flags().Changed("parallel") {
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		return parallel
+	}
+	return getConfig(cmd).Defaults.Parallel
+}
+
+func repoInitCmd() *cobra.Command {
+	var chunkSize int
+
+	cmd := &cobra.Command{
+		Use:   "init [remote:path]",
+		Short: "Create an empty repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			provider, err := connectRemote(cmd, location.Remote)
+			if err != nil {
+				return err
+			}
+			params := repo.DefaultChunkerParams
+			if cmd.Flags().Changed("chunk-size") {
+				params = repo.ChunkerParams{MinSize: chunkSize / 4, AvgSize: chunkSize, MaxSize: chunkSize * 8}
+			}
+			r, err := repo.Init(cmd.Context(), provider, location.Path, params)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Created repository %s at %s\n", r.Config.ID, location)
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&chunkSize, "chunk-size", repo.DefaultChunkerParams.AvgSize, "Average chunk size in bytes, a power of two; chunks range from a quarter to eight times this")
+	return cmd
+}
+
+func repoBackupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup [source] [remote:path]",
+		Short: "Store a new snapshot of a source in a repository",
+		Long: `Store a new snapshot of every file below the source. Files whose size and
+ETag or modification time match the previous snapshot of the same source
+are not read again; the others are read and only their new chunks are
+uploaded. Files that fail are left out of the snapshot.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			source, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			location, err := config.ParseRemotePath(args[1])
+			if err != nil {
+				return err
+			}
+			storage, err := connectRemote(cmd, source.Remote)
+			if err != nil {
+				return err
+			}
+			r, err := openRepo(cmd, location)
+			if err != nil {
+				return err
+			}
+
+			snapshot, stats, err := r.Backup(cmd.Context(), storage, source.Path, source.String(), repoParallel(cmd))
+			if stats != nil {
+				for _, failed := range stats.Failed {
+					fmt.Fprintf(cmd.ErrOrStderr(), "failed to back up %s: %s\n", failed.Path, failed.Error)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Snapshot %s: %d files (%s), %d unchanged, %s read, %d new chunks (%s)\n",
+				snapshot.ID, snapshot.TotalFiles, types.FormatBytes(snapshot.TotalBytes), stats.Unchanged,
+				types.FormatBytes(stats.ReadBytes), stats.NewChunks, types.FormatBytes(stats.NewBytes))
+			if len(stats.Failed) > 0 {
+				return fmt.Errorf("%d files failed to back up", len(stats.Failed))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Int("parallel", 0, "Number of files read at once (default from config)")
+	return cmd
+}
+
+func repoSnapshotsCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "snapshots [remote:path]",
+		Short: "List the snapshots in a repository, oldest first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			r, err := openRepo(cmd, location)
+			if err != nil {
+				return err
+			}
+			snapshots, err := r.Snapshots(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				// Indexes list every file; the listing only summarizes.
+				for _, snapshot := range snapshots {
+					snapshot.Files = nil
+				}
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(snapshots)
+			}
+			if len(snapshots) == 0 {
+				fmt.Fprintf(out, "No snapshots in %s\n", location)
+				return nil
+			}
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tTIME\tSOURCE\tFILES\tSIZE")
+			for _, snapshot := range snapshots {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", snapshot.ID,
+					snapshot.Time.Local().Format("2006-01-02 15:04:05"), snapshot.Source,
+					snapshot.TotalFiles, types.FormatBytes(snapshot.TotalBytes))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print snapshots as JSON")
+	return cmd
+}
+
+func repoRestoreCmd() *cobra.Command {
+	var path string
+
+	cmd := &cobra.Command{
+		Use:   "restore [remote:path] [snapshot] [destination]",
+		Short: "Restore the files of a snapshot",
+		Long: `Write the files of a snapshot, or "latest", below the destination, keeping
+their paths relative to the backed-up source. --path restores one file or
+directory of the snapshot. Every chunk and file is checked against its
+hash.`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			destination, err := config.ParseRemotePath(args[2])
+			if err != nil {
+				return err
+			}
+			r, err := openRepo(cmd, location)
+			if err != nil {
+				return err
+			}
+			storage, err := connectRemote(cmd, destination.Remote)
+			if err != nil {
+				return err
+			}
+			snapshot, err := r.LoadSnapshot(cmd.Context(), args[1])
+			if err != nil {
+				return err
+			}
+
+			stats, err := r.Restore(cmd.Context(), snapshot, path, storage, destination.Path, repoParallel(cmd))
+			if err != nil {
+				return err
+			}
+			for _, failed := range stats.Failed {
+				fmt.Fprintf(cmd.ErrOrStderr(), "failed to restore %s: %s\n", failed.Path, failed.Error)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Restored %d files (%s) from snapshot %s to %s\n",
+				stats.Files, types.FormatBytes(stats.Bytes), snapshot.ID, destination)
+			if len(stats.Failed) > 0 {
+				return fmt.Errorf("%d files failed to restore", len(stats.Failed))
+			}
+			if stats.Files == 0 && path != "" {
+				return fmt.Errorf("snapshot %s has no files under %s", snapshot.ID, path)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&path, "path", "", "Restore only this file or directory of the snapshot")
+	cmd.Flags().Int("parallel", 0, "Number of files restored at once (default from config)")
+	return cmd
+}
+
+func repoCheckCmd() *cobra.Command {
+	var readData bool
+
+	cmd := &cobra.Command{
+		Use:   "check [remote:path]",
+		Short: "Check that every snapshot's chunks are present and intact",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			r, err := openRepo(cmd, location)
+			if err != nil {
+				return err
+			}
+			result, err := r.Check(cmd.Context(), readData, repoParallel(cmd))
+			if err != nil {
+				return err
+			}
+
+			for _, problem := range result.Problems {
+				fmt.Fprintf(cmd.ErrOrStderr(), "chunk %s of %s in snapshot %s: %s\n", problem.Chunk, problem.Path, problem.Snapshot, problem.Error)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%d snapshots, %d chunks (%s), %d unreferenced (%s)\n",
+				result.Snapshots, result.Chunks, types.FormatBytes(result.Bytes),
+				result.Unreferenced, types.FormatBytes(result.UnreferencedBytes))
+			if len(result.Problems) > 0 {
+				return fmt.Errorf("%d chunks are missing or corrupt", len(result.Problems))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&readData, "read-data", false, "Read every chunk back and check its hash")
+	cmd.Flags().Int("parallel", 0, "Number of chunks read at once with --read-data (default from config)")
+	return cmd
+}
+
+func repoGCCmd() *cobra.Command {
+	var policy types.RetentionPolicy
+	var dryRun, force bool
+
+	cmd := &cobra.Command{
+		Use:   "gc [remote:path]",
+		Short: "Remove old snapshots and delete unreferenced chunks",
+		Long: `Delete the chunks no snapshot refers to, such as those of interrupted
+backups. With --keep flags, snapshots are first removed by the same
+retention rules as "snapshots prune". gc does not run while a backup holds
+the repository's lock, and backups do not start while gc holds its own.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			if err := policy.Validate(); err != nil {
+				return err
+			}
+			r, err := openRepo(cmd, location)
+			if err != nil {
+				return err
+			}
+
+			result, err := r.GC(cmd.Context(), policy, dryRun, force, repoParallel(cmd))
+			out := cmd.OutOrStdout()
+			if result != nil && len(result.Decisions) > 0 {
+				w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+				for _, decision := range result.Decisions {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", decision.Snapshot.ID,
+						decision.Snapshot.Time.Local().Format("2006-01-02 15:04:05"), decision)
+				}
+				w.Flush()
+			}
+			if err != nil {
+				return err
+			}
+			for _, failed := range result.Failed {
+				fmt.Fprintf(cmd.ErrOrStderr(), "failed to delete %s: %s\n", failed.Path, failed.Error)
+			}
+
+			verb := "Removed %d snapshots and deleted %d chunks (%s)\n"
+			if dryRun {
+				verb = "Would remove %d snapshots and delete %d chunks (%s)\n"
+			}
+			fmt.Fprintf(out, verb, len(result.Removed), result.Chunks, types.FormatBytes(result.Bytes))
+			if len(result.Failed) > 0 {
+				return fmt.Errorf("%d chunks failed to delete", len(result.Failed))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&policy.KeepLast, "keep-last", 0, "Keep the N newest snapshots")
+	cmd.Flags().IntVar(&policy.KeepDaily, "keep-daily", 0, "Keep the newest snapshot of each of the last N days")
+	cmd.Flags().IntVar(&policy.KeepWeekly, "keep-weekly", 0, "Keep the newest snapshot of each of the last N weeks")
+	cmd.Flags().IntVar(&policy.KeepMonthly, "keep-monthly", 0, "Keep the newest snapshot of each of the last N months")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would be removed without removing it")
+	cmd.Flags().BoolVar(&force, "force", false, "Run even though a backup or gc holds a lock, e.g. after it crashed")
+	cmd.Flags().Int("parallel", 0, "Number of parallel deletes (default from config)")
+	return cmd
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/sync"
+	"datasyncer/types"
+)
+
+func restoreCmd() *cobra.Command {
+	var versionID, at string
+	var dryRun bool
+	var parallel int
+
+	cmd := &cobra.Command{
+		Use:   "restore [remote:path]",
+		Short: "Roll files on a versioned bucket back to an earlier version",
+		Long: `Make an earlier version of a file current again with --version, or roll a
+file or everything below a path back to how it was at a point in time with
+--at. Files that did not exist then are deleted. Restored versions are
+copied over the current ones, so the previous state stays in the history:
+
+  datasyncer restore prod-s3:config/app.yaml --version 3HL4kqtJlcpXroDTDmJ
+  datasyncer restore prod-s3:exports --at 2026-10-01T00:00:00Z --dry-run
+
+--at takes an RFC 3339 time or a local date and time such as
+"2026-10-01 14:30".`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			if (versionID == "") == (at == "") {
+				return fmt.Errorf("set either --version or --at")
+			}
+			versioner, err := connectVersioner(cmd, location.Remote)
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			out := cmd.OutOrStdout()
+			if versionID != "" {
+				if location.Path == "" || strings.HasSuffix(location.Path, "/") {
+					return fmt.Errorf("%s is a prefix; --version restores a single file", location)
+				}
+				if dryRun {
+					info, err := versioner.GetVersionInfo(ctx, location.Path, versionID)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(out, "Would restore %s to version %s (%s)\n", location, versionID, types.FormatBytes(info.Size))
+					return nil
+				}
+				info, err := getSyncManager(cmd).RestoreVersion(ctx, types.CloudProvider(location.Remote), location.Path, versionID)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "Restored %s to version %s (%s)\n", location, versionID, types.FormatBytes(info.Size))
+				return nil
+			}
+
+			when, err := parseTime(at)
+			if err != nil {
+				return err
+			}
+			if location.Path == "" && !dryRun {
+				return fmt.Errorf("refusing to roll back everything on %s; name a path or use --dry-run first", location.Remote)
+			}
+			feed := func(send func(sync.RollbackChange) error) error {
+				return sync.PlanRollback(ctx, versioner, location.Path, when, func(change sync.RollbackChange) error {
+					if !underPath(change.Path, location.Path) {
+						return nil
+					}
+					return send(change)
+				})
+			}
+
+			if dryRun {
+				return feed(func(change sync.RollbackChange) error {
+					if change.Delete {
+						fmt.Fprintf(out, "Would delete %s:%s\n", location.Remote, change.Path)
+					} else {
+						fmt.Fprintf(out, "Would restore %s:%s to version %s from %s\n", location.Remote, change.Path,
+							change.Version.VersionID, change.Version.LastModified.Local().Format(time.RFC3339))
+					}
+					return nil
+				})
+			}
+
+			if !cmd.Flags().Changed("parallel") {
+				parallel = getConfig(cmd).Defaults.Parallel
+			}
+			report, err := getSyncManager(cmd).Rollback(ctx, types.CloudProvider(location.Remote), parallel, feed)
+			if err != nil {
+				return err
+			}
+			for _, key := range report.FailedKeys {
+				fmt.Fprintf(cmd.ErrOrStderr(), "failed to roll back %s:%s: %s\n", location.Remote, key.Path, key.Error)
+			}
+			fmt.Fprintf(out, "Restored %d files and deleted %d under %s as of %s\n", report.Copied, report.Deleted, location, when.Format(time.RFC3339))
+			if report.Failed > 0 {
+				return fmt.Errorf("%d files failed to roll back", report.Failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&versionID, "version", "", "Version ID to make current (see the versions command)")
+	cmd.Flags().StringVar(&at, "at", "", "Roll back to the state at this time")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would change without changing it")
+	cmd.Flags().IntVar(&parallel, "parallel", 0, "Number of parallel restores with --at (default from config)")
+	return cmd
+}
+
+// parseTime accepts an RFC 3339 time or a local date with an optional
+// time of day.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339 such as 2026-10-01T00:00:00Z or 2026-10-01 14:30", value)
+}
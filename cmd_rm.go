@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/types"
+)
+
+func rmCmd() *cobra.Command {
+	var recursive, dryRun bool
+	var parallel int
+
+	cmd := &cobra.Command{
+		Use:   "rm [remote:path]...",
+		Short: "Delete files from remotes or local paths",
+		Long: `Delete the named files. With -r every file below each path is deleted,
+treating the path as a directory: "rm -r prod-s3:logs" deletes logs and
+logs/..., but not logs-old. Use --dry-run to list what would be deleted.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("parallel") {
+				parallel = getConfig(cmd).Defaults.Parallel
+			}
+
+			var failed int
+			for _, arg := range args {
+				location, err := config.ParseRemotePath(arg)
+				if err != nil {
+					return err
+				}
+				if recursive {
+					n, err := removeTree(cmd, location, parallel, dryRun)
+					if err != nil {
+						return err
+					}
+					failed += n
+					continue
+				}
+				if err := removeFile(cmd, location, dryRun); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d files failed to delete", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Delete every file below the path")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would be deleted without deleting it")
+	cmd.Flags().IntVar(&parallel, "parallel", 0, "Number of parallel deletes with -r (default from config)")
+	return cmd
+}
+
+func removeFile(cmd *cobra.Command, location config.RemotePath, dryRun bool) error {
+	if location.Path == "" || strings.HasSuffix(location.Path, "/") {
+		return fmt.Errorf("%s is a prefix; use -r to delete everything below it", location)
+	}
+	provider, err := connectRemote(cmd, location.Remote)
+	if err != nil {
+		return err
+	}
+
+	// Deletes are idempotent on most providers, so check first to report
+	// a mistyped key instead of silently succeeding.
+	if _, err := provider.GetFileInfo(cmd.Context(), location.Path); err != nil {
+		return fmt.Errorf("cannot delete %s: %v", location, err)
+	}
+	if dryRun {
+		fmt.Fprintf(cmd.OutOrStdout(), "Would delete %s\n", location)
+		return nil
+	}
+	if err := provider.DeleteFile(cmd.Context(), location.Path); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", location, err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Deleted %s\n", location)
+	return nil
+}
+
+// removeTree deletes location and every file below it, returning the
+// number of files that failed to delete.
+func removeTree(cmd *cobra.Command, location config.RemotePath, parallel int, dryRun bool) (int, error) {
+	if location.Path == "" {
+		return 0, fmt.Errorf("refusing to delete everything on %s; name a prefix", location.Remote)
+	}
+	provider, err := connectRemote(cmd, location.Remote)
+	if err != nil {
+		return 0, err
+	}
+
+	feed := func(send func(key string) error) error {
+		return provider.ListIter(cmd.Context(), location.Path, func(file types.FileInfo) error {
+			if !underPath(file.Path, location.Path) {
+				return nil
+			}
+			return send(file.Path)
+		})
+	}
+
+	out := cmd.OutOrStdout()
+	if dryRun {
+		return 0, feed(func(key string) error {
+			fmt.Fprintf(out, "Would delete %s:%s\n", location.Remote, key)
+			return nil
+		})
+	}
+
+	report, err := getSyncManager(cmd).Remove(cmd.Context(), types.CloudProvider(location.Remote), parallel, feed)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range report.FailedKeys {
+		fmt.Fprintf(cmd.ErrOrStderr(), "failed to delete %s:%s: %s\n", location.Remote, key.Path, key.Error)
+	}
+	fmt.Fprintf(out, "Deleted %d files under %s\n", report.Deleted, location)
+	return report.Failed, nil
+}
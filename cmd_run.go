@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/jobs"
+)
+
+func runCmd() *cobra.Command {
+	var jobNames []string
+	var concurrency int
+	var reportPath string
+
+	cmd := &cobra.Command{
+		Use:   "run [jobs file]",
+		Short: "Run the syncs declared in a jobs file",
+		Long: `Run every job in a YAML, TOML or JSON jobs file, or only those named with
+--job. Jobs run one at a time unless the file or --concurrency allows more.
+The command fails if any job fails.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifest, err := jobs.LoadManifest(args[0])
+			if err != nil {
+				return err
+			}
+			cfg := getConfig(cmd)
+			if err := manifest.Validate(cfg); err != nil {
+				return err
+			}
+			selected, err := manifest.Select(jobNames)
+			if err != nil {
+				return err
+			}
+
+			runner := &jobs.Runner{
+				Config:      cfg,
+				Manager:     getSyncManager(cmd),
+				Concurrency: manifest.Concurrency,
+				Connect: func(ctx context.Context, remote string) error {
+					_, err := connectRemote(cmd, remote)
+					return err
+				},
+			}
+			if cmd.Flags().Changed("concurrency") {
+				runner.Concurrency = concurrency
+			}
+
+			report := runner.Run(cmd.Context(), selected)
+
+			out := cmd.OutOrStdout()
+			for _, result := range report.Jobs {
+				detail := result.Error
+				if detail == "" && result.Report != nil {
+					detail = result.Report.Summary()
+				}
+				fmt.Fprintf(out, "%-9s %s: %s\n", result.Status, result.Name, detail)
+			}
+
+			if reportPath != "" {
+				if err := report.WriteFiles(reportPath); err != nil {
+					return err
+				}
+			}
+
+			if report.Failed > 0 {
+				return fmt.Errorf("%d of %d jobs failed", report.Failed, len(report.Jobs))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&jobNames, "job", nil, "Run only the named job (repeatable)")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 1, "Number of jobs to run at once (default from the jobs file)")
+	cmd.Flags().StringVar(&reportPath, "report", "", "Write a combined JSON report to this path, with .md next to it")
+	return cmd
+}
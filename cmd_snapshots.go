@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/backup"
+	"datasyncer/config"
+	"datasyncer/types"
+)
+
+func snapshotsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshots",
+		Short: "List and prune the snapshots taken by backup",
+	}
+	cmd.AddCommand(snapshotsListCmd())
+	cmd.AddCommand(snapshotsPruneCmd())
+	return cmd
+}
+
+func snapshotsListCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "list [remote:path]",
+		Short: "List the snapshots under a backup path, oldest first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			provider, err := connectRemote(cmd, location.Remote)
+			if err != nil {
+				return err
+			}
+			snapshots, err := backup.List(cmd.Context(), provider, location.Path)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				// Manifests list every file; the listing only summarizes.
+				for _, snapshot := range snapshots {
+					snapshot.Files = nil
+				}
+				if snapshots == nil {
+					snapshots = []*backup.Snapshot{}
+				}
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(snapshots)
+			}
+
+			if len(snapshots) == 0 {
+				fmt.Fprintf(out, "No snapshots under %s\n", location)
+				return nil
+			}
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tTIME\tFILES\tSIZE\tNEW FILES\tNEW SIZE")
+			for _, snapshot := range snapshots {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%s\n", snapshot.ID,
+					snapshot.Time.Local().Format("2006-01-02 15:04:05"),
+					snapshot.TotalFiles, types.FormatBytes(snapshot.TotalBytes),
+					snapshot.NewFiles, types.FormatBytes(snapshot.NewBytes))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print snapshots as JSON")
+	return cmd
+}
+
+func snapshotsPruneCmd() *cobra.Command {
+	var policy types.RetentionPolicy
+	var dryRun bool
+	var parallel int
+
+	cmd := &cobra.Command{
+		Use:   "prune [remote:path]",
+		Short: "Remove the snapshots a retention policy does not keep",
+		Long: `Remove snapshots under a backup path by retention rules, then delete the
+files no remaining snapshot refers to, including those of backups that
+never finished. A snapshot is kept when any rule keeps it:
+
+  --keep-last N      the N newest snapshots
+  --keep-daily N     the newest snapshot of each of the last N days
+  --keep-weekly N    the newest snapshot of each of the last N weeks
+  --keep-monthly N   the newest snapshot of each of the last N months
+
+Days, weeks and months only count when they have a snapshot. Without
+--keep flags the backup.retention policy from the config is used.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			cfg := getConfig(cmd)
+			if !cmd.Flags().Changed("parallel") {
+				parallel = cfg.Defaults.Parallel
+			}
+			if policy.IsZero() {
+				policy = cfg.Backup.Retention
+			}
+			if err := policy.Validate(); err != nil {
+				return err
+			}
+			if policy.IsZero() {
+				return fmt.Errorf("no retention policy; pass --keep flags or set backup.retention in the config")
+			}
+
+			if !dryRun {
+				return pruneSnapshots(cmd, location, policy, parallel)
+			}
+
+			provider, err := connectRemote(cmd, location.Remote)
+			if err != nil {
+				return err
+			}
+			plan, err := backup.PlanPrune(cmd.Context(), provider, location.Path, policy)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			printDecisions(cmd, plan)
+			var files int
+			var size int64
+			err = plan.EachGarbage(cmd.Context(), provider, func(key string) error {
+				info, err := provider.GetFileInfo(cmd.Context(), key)
+				if err == nil {
+					size += info.Size
+				}
+				files++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Would remove %d snapshots and delete %d files (%s)\n", len(plan.Removed()), files, types.FormatBytes(size))
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&policy.KeepLast, "keep-last", 0, "Keep the N newest snapshots")
+	cmd.Flags().IntVar(&policy.KeepDaily, "keep-daily", 0, "Keep the newest snapshot of each of the last N days")
+	cmd.Flags().IntVar(&policy.KeepWeekly, "keep-weekly", 0, "Keep the newest snapshot of each of the last N weeks")
+	cmd.Flags().IntVar(&policy.KeepMonthly, "keep-monthly", 0, "Keep the newest snapshot of each of the last N months")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would be removed without removing it")
+	cmd.Flags().IntVar(&parallel, "parallel", 0, "Number of parallel deletes (default from config)")
+	return cmd
+}
+
+// pruneSnapshots applies policy to the snapshots under location and prints
+// what it kept and removed.
+func pruneSnapshots(cmd *cobra.Command, location config.RemotePath, policy types.RetentionPolicy, parallel int) error {
+	if _, err := connectRemote(cmd, location.Remote); err != nil {
+		return err
+	}
+	plan, report, err := getSyncManager(cmd).Prune(cmd.Context(), types.CloudProvider(location.Remote), location.Path, policy, parallel)
+	if plan != nil {
+		printDecisions(cmd, plan)
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range report.FailedKeys {
+		fmt.Fprintf(cmd.ErrOrStderr(), "failed to delete %s:%s: %s\n", location.Remote, key.Path, key.Error)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Removed %d snapshots and deleted %d files\n", len(plan.Removed()), report.Deleted)
+	if report.Failed > 0 {
+		return fmt.Errorf("%d files failed to delete", report.Failed)
+	}
+	return nil
+}
+
+func printDecisions(cmd *cobra.Command, plan *backup.PrunePlan) {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	for _, decision := range plan.Decisions {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", decision.Snapshot.ID,
+			decision.Snapshot.Time.Local().Format("2006-01-02 15:04:05"), decision)
+	}
+	w.Flush()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/types"
+)
+
+// statEntry is the JSON form of stat's output.
+type statEntry struct {
+	Path               string            `json:"path"`
+	Size               int64             `json:"size"`
+	LastModified       time.Time         `json:"last_modified"`
+	ModTime            time.Time         `json:"mod_time"`
+	ETag               string            `json:"etag,omitempty"`
+	StorageClass       string            `json:"storage_class,omitempty"`
+	Archived           bool              `json:"archived,omitempty"`
+	Restoring          bool              `json:"restoring,omitempty"`
+	ContentType        string            `json:"content_type,omitempty"`
+	ContentEncoding    string            `json:"content_encoding,omitempty"`
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+}
+
+func statCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "stat [remote:path]",
+		Short: "Show a file's size, timestamps and metadata",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			provider, err := connectRemote(cmd, location.Remote)
+			if err != nil {
+				return err
+			}
+
+			info, err := provider.GetFileInfo(cmd.Context(), location.Path)
+			if err != nil {
+				return fmt.Errorf("cannot stat %s: %v", location, err)
+			}
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(statEntry{
+					Path:               info.Path,
+					Size:               info.Size,
+					LastModified:       info.LastModified,
+					ModTime:            info.ModTime,
+					ETag:               info.ETag,
+					StorageClass:       info.StorageClass,
+					Archived:           info.Archived,
+					Restoring:          info.Restoring,
+					ContentType:        info.ContentType,
+					ContentEncoding:    info.ContentEncoding,
+					CacheControl:       info.CacheControl,
+					ContentDisposition: info.ContentDisposition,
+					Metadata:           info.Metadata,
+				})
+			}
+
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			field := func(name, value string) {
+				if value != "" {
+					fmt.Fprintf(w, "%s:\t%s\n", name, value)
+				}
+			}
+			field("Path", location.String())
+			field("Size", fmt.Sprintf("%s (%d bytes)", types.FormatBytes(info.Size), info.Size))
+			field("Modified", info.ModTime.Format(time.RFC3339Nano))
+			field("Stored", info.LastModified.Format(time.RFC3339))
+			field("ETag", info.ETag)
+			field("Storage class", info.StorageClass)
+			switch {
+			case info.Restoring:
+				field("Archived", "yes, restore in progress")
+			case info.Archived:
+				field("Archived", "yes, restore before reading")
+			}
+			field("Content type", info.ContentType)
+			field("Content encoding", info.ContentEncoding)
+			field("Cache control", info.CacheControl)
+			field("Content disposition", info.ContentDisposition)
+
+			keys := make([]string, 0, len(info.Metadata))
+			for key := range info.Metadata {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				field("Metadata "+key, info.Metadata[key])
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print JSON")
+	return cmd
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"datasyncer/config"
+	"datasyncer/types"
+)
+
+// versionEntry is the JSON form of one listed version.
+type versionEntry struct {
+	Path         string    `json:"path"`
+	VersionID    string    `json:"version_id"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	IsLatest     bool      `json:"is_latest,omitempty"`
+	DeleteMarker bool      `json:"delete_marker,omitempty"`
+	Deleted      time.Time `json:"deleted,omitempty"`
+}
+
+func versionsCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "versions [remote:path]",
+		Short: "List every version of a file or of the files below a path",
+		Long: `List the versions a versioned bucket keeps: S3 object versions and delete
+markers, GCS generations and Azure blob versions. The path names a file or,
+like rm -r, a directory. Version IDs can be passed to restore --version.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			versioner, err := connectVersioner(cmd, location.Remote)
+			if err != nil {
+				return err
+			}
+
+			var entries []versionEntry
+			err = versioner.ListVersions(cmd.Context(), location.Path, func(v types.FileVersion) error {
+				if !underPath(v.Path, location.Path) {
+					return nil
+				}
+				entries = append(entries, versionEntry{
+					Path:         v.Path,
+					VersionID:    v.VersionID,
+					Size:         v.Size,
+					LastModified: v.LastModified,
+					IsLatest:     v.IsLatest,
+					DeleteMarker: v.DeleteMarker,
+					Deleted:      v.Deleted,
+				})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(entries)
+			}
+
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "MODIFIED\tSIZE\tVERSION\tPATH")
+			for _, entry := range entries {
+				size := types.FormatBytes(entry.Size)
+				var notes []string
+				if entry.DeleteMarker {
+					size = "-"
+					notes = append(notes, "delete marker")
+				}
+				if entry.IsLatest {
+					notes = append(notes, "latest")
+				}
+				name := entry.Path
+				if len(notes) > 0 {
+					name += " (" + strings.Join(notes, ", ") + ")"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.LastModified.Local().Format("2006-01-02 15:04:05"), size, entry.VersionID, name)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print versions as JSON")
+	return cmd
+}
+
+// connectVersioner connects to a remote that keeps file versions.
+func connectVersioner(cmd *cobra.Command, remote string) (types.Versioner, error) {
+	provider, err := connectRemote(cmd, remote)
+	if err != nil {
+		return nil, err
+	}
+	providerConfig, err := getConfig(cmd).ProviderConfig(remote)
+	if err != nil {
+		return nil, err
+	}
+	versioner, ok := provider.(types.Versioner)
+	if !ok || providerConfig.Type == types.LOCAL {
+		return nil, fmt.Errorf("%s is a %s remote, which does not keep file versions", remote, providerConfig.Type)
+	}
+	return versioner, nil
+}
+
+// underPath reports whether key is path itself or below it as a
+// directory, so "logs" matches logs and logs/... but not logs-old.
+func underPath(key, path string) bool {
+	if path == "" || strings.HasSuffix(path, "/") {
+		return strings.HasPrefix(key, path)
+	}
+	return key == path || strings.HasPrefix(key, path+"/")
+}
@@ -0,0 +1,533 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"datasyncer/crypt"
+	"datasyncer/providers"
+	"datasyncer/types"
+)
+
+// Remote is a named storage location. It either spells out its provider
+// settings inline or points at a credential profile saved by `auth`; inline
+// settings take precedence over the profile.
+type Remote struct {
+	types.ProviderConfig `mapstructure:",squash"`
+	Credentials          string `mapstructure:"credentials"`
+	// StorageClass is the tier or class files synced to this remote are
+	// stored in, and StorageRules pick one for matching files instead.
+	StorageClass string              `mapstructure:"storage_class"`
+	StorageRules []types.StorageRule `mapstructure:"storage_rules"`
+	// Encryption, when set, encrypts files on the client before they are
+	// uploaded to this remote.
+	Encryption *EncryptionConfig `mapstructure:"encryption"`
+}
+
+// EncryptionConfig sets a remote's master key: read from KeyFile, or
+// derived from a passphrase given inline or in the environment variable
+// PassphraseEnv names. Salt makes a passphrase's key specific to this
+// setup; changing it, or the passphrase, makes existing files unreadable.
+type EncryptionConfig struct {
+	KeyFile       string `mapstructure:"keyfile"`
+	Passphrase    string `mapstructure:"passphrase"`
+	PassphraseEnv string `mapstructure:"passphrase_env"`
+	Salt          string `mapstructure:"salt"`
+	// EncryptNames encrypts file names as well as their content.
+	EncryptNames bool `mapstructure:"encrypt_names"`
+}
+
+// Validate checks that exactly one key source is set.
+func (e *EncryptionConfig) Validate() error {
+	sources := 0
+	for _, source := range []string{e.KeyFile, e.Passphrase, e.PassphraseEnv} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("set exactly one of keyfile, passphrase or passphrase_env")
+	}
+	return nil
+}
+
+// Keys loads or derives the master key.
+func (e *EncryptionConfig) Keys() (*crypt.Keys, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	var master []byte
+	var err error
+	switch {
+	case e.KeyFile != "":
+		master, err = crypt.KeyFromFile(e.KeyFile)
+	case e.Passphrase != "":
+		master, err = crypt.KeyFromPassphrase(e.Passphrase, e.Salt)
+	default:
+		passphrase := os.Getenv(e.PassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", e.PassphraseEnv)
+		}
+		master, err = crypt.KeyFromPassphrase(passphrase, e.Salt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return crypt.NewKeys(master)
+}
+
+type SyncDefaults struct {
+	Parallel           int    `mapstructure:"parallel"`
+	ConflictResolution string `mapstructure:"conflict_resolution"`
+	IncrementalSync    bool   `mapstructure:"incremental_sync"`
+	ReportPath         string `mapstructure:"report_path"`
+	// Restore sets how archived files are restored when a sync is run
+	// with --restore.
+	Restore types.RestoreOptions `mapstructure:"restore"`
+}
+
+type NotifierConfig struct {
+	Email       types.EmailConfig         `mapstructure:"email"`
+	Webhooks    []types.WebhookConfig     `mapstructure:"webhooks"`
+	Commands    []types.CommandHookConfig `mapstructure:"commands"`
+	Rules       []types.NotificationRule  `mapstructure:"rules"`
+	DedupWindow time.Duration             `mapstructure:"dedup_window"`
+	MaxRetries  int                       `mapstructure:"max_retries"`
+}
+
+type LoggerConfig struct {
+	File  string `mapstructure:"file"`
+	Level string `mapstructure:"level"`
+}
+
+type RecoveryConfig struct {
+	StateFile   string `mapstructure:"state_file"`
+	MaxAttempts int    `mapstructure:"max_attempts"`
+}
+
+// BackupConfig holds the settings of snapshot backups.
+type BackupConfig struct {
+	// Retention is the policy `snapshots prune` applies when no --keep
+	// flags are given.
+	Retention types.RetentionPolicy `mapstructure:"retention"`
+}
+
+type Config struct {
+	Remotes  map[string]Remote `mapstructure:"remotes"`
+	Defaults SyncDefaults      `mapstructure:"defaults"`
+	Notifier NotifierConfig    `mapstructure:"notifier"`
+	Logger   LoggerConfig      `mapstructure:"logger"`
+	Recovery RecoveryConfig    `mapstructure:"recovery"`
+	Backup   BackupConfig      `mapstructure:"backup"`
+	// StorageClasses overrides the class a tier maps to per provider type,
+	// e.g. {"archive": {"aws": "GLACIER"}}.
+	StorageClasses map[string]map[string]string `mapstructure:"storage_classes"`
+
+	// File is the config file that was read, empty when none was found.
+	File string `mapstructure:"-"`
+}
+
+const configName = "config"
+
+// Setup points viper at $HOME/.datasyncer/config.{yaml,toml,json}, or at the
+// file named by DATASYNCER_CONFIG when set. Environment variables such as
+// DATASYNCER_LOGGER_LEVEL override file values.
+func Setup() {
+	setDefaults(viper.GetViper())
+	viper.SetConfigName(configName)
+	viper.AddConfigPath("$HOME/.datasyncer")
+	viper.SetEnvPrefix("datasyncer")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	if file := os.Getenv("DATASYNCER_CONFIG"); file != "" {
+		viper.SetConfigFile(file)
+	}
+}
+
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("defaults.parallel", 4)
+	v.SetDefault("defaults.restore.days", 7)
+	v.SetDefault("defaults.restore.priority", "standard")
+	v.SetDefault("defaults.restore.poll_interval", 15*time.Minute)
+	v.SetDefault("defaults.restore.timeout", 48*time.Hour)
+	v.SetDefault("logger.file", "sync.log")
+	v.SetDefault("logger.level", "info")
+	v.SetDefault("recovery.state_file", "sync_state.json")
+	v.SetDefault("recovery.max_attempts", 3)
+	v.SetDefault("notifier.email.port", 587)
+}
+
+// Load reads the config file found by Setup, if there is one. Keys are
+// decoded strictly so misspellings are reported instead of ignored; when
+// that fails the returned config still holds whatever could be decoded, so
+// callers can keep running with defaults while surfacing the error.
+func Load() (*Config, error) {
+	return load(viper.GetViper(), true)
+}
+
+// LoadFile reads and decodes a specific config file.
+func LoadFile(path string) (*Config, error) {
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(path)
+	return load(v, false)
+}
+
+func load(v *viper.Viper, optional bool) (*Config, error) {
+	var file string
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !optional || !errors.As(err, &notFound) {
+			cfg := &Config{}
+			v.Unmarshal(cfg)
+			return cfg, fmt.Errorf("failed to read config: %v", err)
+		}
+	} else {
+		file = v.ConfigFileUsed()
+	}
+
+	var cfg Config
+	if err := v.UnmarshalExact(&cfg); err != nil {
+		lenient := &Config{File: file}
+		v.Unmarshal(lenient)
+		return lenient, fmt.Errorf("invalid config %s: %v", file, err)
+	}
+	cfg.File = file
+	return &cfg, nil
+}
+
+// Settings returns the effective settings as a nested map, with secrets
+// replaced by "****".
+func Settings() map[string]any {
+	return maskSecrets(viper.AllSettings())
+}
+
+var secretKeys = map[string]bool{
+	"account_key": true,
+	"sas_token":   true,
+	"password":    true,
+	"passphrase":  true,
+	"secret":      true,
+}
+
+func maskSecrets(settings map[string]any) map[string]any {
+	masked := make(map[string]any, len(settings))
+	for k, v := range settings {
+		switch value := v.(type) {
+		case map[string]any:
+			masked[k] = maskSecrets(value)
+		case []any:
+			items := make([]any, len(value))
+			for i, item := range value {
+				if m, ok := item.(map[string]any); ok {
+					items[i] = maskSecrets(m)
+				} else {
+					items[i] = item
+				}
+			}
+			masked[k] = items
+		default:
+			if secretKeys[k] && v != "" {
+				masked[k] = "****"
+			} else {
+				masked[k] = v
+			}
+		}
+	}
+	return masked
+}
+
+// DefaultPath is where `config edit` creates a config file when none exists.
+func DefaultPath() (string, error) {
+	dir, err := types.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, configName+".yaml"), nil
+}
+
+// Validate checks every remote for the fields its provider requires and the
+// remaining sections for values the rest of the tool cannot handle.
+func (c *Config) Validate() error {
+	var errs []error
+
+	for _, name := range c.RemoteNames() {
+		if _, err := c.ProviderConfig(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.Defaults.Parallel < 1 {
+		errs = append(errs, fmt.Errorf("defaults.parallel must be at least 1"))
+	}
+	switch c.Defaults.ConflictResolution {
+	case "", "overwrite", "skip", "archive":
+	default:
+		errs = append(errs, fmt.Errorf("defaults.conflict_resolution must be overwrite, skip or archive, got %q", c.Defaults.ConflictResolution))
+	}
+	if err := c.Defaults.Restore.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("defaults.restore: %v", err))
+	}
+	if err := c.Backup.Retention.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("backup.retention: %v", err))
+	}
+
+	for tier, classes := range c.StorageClasses {
+		if !slices.Contains(types.Tiers, tier) {
+			errs = append(errs, fmt.Errorf("storage_classes: unknown tier %q, use hot, cool, cold or archive", tier))
+		}
+		for provider := range classes {
+			switch types.CloudProvider(provider) {
+			case types.AWS, types.GCP, types.AZURE:
+			default:
+				errs = append(errs, fmt.Errorf("storage_classes.%s: unknown provider %q", tier, provider))
+			}
+		}
+	}
+	for _, name := range c.RemoteNames() {
+		remote := c.Remotes[name]
+		if remote.StorageClass != "" {
+			if err := types.ValidateStorageClass(remote.StorageClass); err != nil {
+				errs = append(errs, fmt.Errorf("remote %s: %v", name, err))
+			}
+		}
+		for i, rule := range remote.StorageRules {
+			if err := types.ValidateStorageClass(rule.StorageClass); err != nil {
+				errs = append(errs, fmt.Errorf("remote %s: storage_rules[%d]: %v", name, i, err))
+			}
+		}
+		if remote.Encryption != nil {
+			if err := remote.Encryption.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("remote %s: encryption: %v", name, err))
+			}
+		}
+	}
+
+	if _, err := types.ParseLogLevel(c.Logger.Level); err != nil {
+		errs = append(errs, fmt.Errorf("logger.level: %v", err))
+	}
+
+	email := c.Notifier.Email
+	if email.SMTPServer != "" && email.FromEmail == "" {
+		errs = append(errs, fmt.Errorf("notifier.email.from_email is required when smtp_server is set"))
+	}
+	if err := email.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("notifier.email: %v", err))
+	}
+	for i, hook := range c.Notifier.Webhooks {
+		if hook.URL == "" {
+			errs = append(errs, fmt.Errorf("notifier.webhooks[%d]: url is required", i))
+		}
+	}
+	for i, hook := range c.Notifier.Commands {
+		if len(hook.Command) == 0 {
+			errs = append(errs, fmt.Errorf("notifier.commands[%d]: command is required", i))
+		}
+	}
+	channels := c.NewNotifier().Channels()
+	names := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		if names[channel.Name()] {
+			errs = append(errs, fmt.Errorf("notifier: more than one channel is named %q", channel.Name()))
+		}
+		names[channel.Name()] = true
+	}
+	for i, rule := range c.Notifier.Rules {
+		if err := rule.Validate(channels); err != nil {
+			errs = append(errs, fmt.Errorf("notifier.rules[%d]: %v", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// HasRemote reports whether name is a configured remote or the implicit
+// local remote.
+func (c *Config) HasRemote(name string) bool {
+	_, ok := c.Remotes[strings.ToLower(name)]
+	return ok || strings.ToLower(name) == LocalRemote
+}
+
+func (c *Config) RemoteNames() []string {
+	names := make([]string, 0, len(c.Remotes))
+	for name := range c.Remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProviderConfig resolves a remote into a complete provider configuration,
+// merging in its credential profile and checking required fields.
+func (c *Config) ProviderConfig(name string) (types.ProviderConfig, error) {
+	remote, ok := c.Remotes[strings.ToLower(name)]
+	if !ok {
+		if strings.ToLower(name) == LocalRemote {
+			return types.ProviderConfig{Type: types.LOCAL}, nil
+		}
+		return types.ProviderConfig{}, fmt.Errorf("unknown remote: %s", name)
+	}
+
+	resolved := remote.ProviderConfig
+	if remote.Credentials != "" {
+		store, err := types.DefaultCredentialStore()
+		if err != nil {
+			return types.ProviderConfig{}, err
+		}
+		profile, err := store.Get(remote.Credentials)
+		if err != nil {
+			return types.ProviderConfig{}, fmt.Errorf("remote %s: %v", name, err)
+		}
+		resolved = mergeProviderConfig(profile, resolved)
+	}
+
+	if resolved.Type == "" {
+		return types.ProviderConfig{}, fmt.Errorf("remote %s: type is required (aws, gcp, azure or local)", name)
+	}
+	resolved.StorageClasses = c.storageClassOverrides(resolved.Type)
+	if err := providers.ValidateConfig(resolved); err != nil {
+		return types.ProviderConfig{}, fmt.Errorf("remote %s: %v", name, err)
+	}
+	return resolved, nil
+}
+
+// storageClassOverrides returns the tier to class overrides for a provider
+// type from the storage_classes table.
+func (c *Config) storageClassOverrides(provider types.CloudProvider) map[string]string {
+	var overrides map[string]string
+	for tier, classes := range c.StorageClasses {
+		if class := classes[string(provider)]; class != "" {
+			if overrides == nil {
+				overrides = make(map[string]string)
+			}
+			overrides[tier] = class
+		}
+	}
+	return overrides
+}
+
+// NewProvider creates the storage client for a remote. It does not
+// authenticate.
+func (c *Config) NewProvider(name string) (types.CloudStorage, error) {
+	providerConfig, err := c.ProviderConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := providers.CreateProvider(providerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption := c.Remotes[strings.ToLower(name)].Encryption
+	if encryption == nil {
+		return provider, nil
+	}
+	keys, err := encryption.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("remote %s: encryption: %v", name, err)
+	}
+	return crypt.New(provider, keys, encryption.EncryptNames), nil
+}
+
+// NewNotifier builds a notifier from the notifier section.
+func (c *Config) NewNotifier() *types.Notifier {
+	return &types.Notifier{
+		EmailConfig: c.Notifier.Email,
+		Webhooks:    c.Notifier.Webhooks,
+		Commands:    c.Notifier.Commands,
+		Rules:       c.Notifier.Rules,
+		DedupWindow: c.Notifier.DedupWindow,
+		MaxRetries:  c.Notifier.MaxRetries,
+	}
+}
+
+// SyncOptions returns the configured defaults for a sync between two
+// remote paths.
+func (c *Config) SyncOptions(source, destination RemotePath) types.SyncOptions {
+	remote := c.Remotes[strings.ToLower(destination.Remote)]
+	return types.SyncOptions{
+		SourceProvider:      types.CloudProvider(source.Remote),
+		DestinationProvider: types.CloudProvider(destination.Remote),
+		SourcePath:          source.Path,
+		DestinationPath:     destination.Path,
+		Parallel:            c.Defaults.Parallel,
+		ConflictResolution:  c.Defaults.ConflictResolution,
+		IncrementalSync:     c.Defaults.IncrementalSync,
+		ReportPath:          c.Defaults.ReportPath,
+		StorageClass:        remote.StorageClass,
+		StorageRules:        remote.StorageRules,
+		RestoreOptions:      c.Defaults.Restore,
+	}
+}
+
+// RemotePath is a location written as "remote:path/prefix".
+type RemotePath struct {
+	Remote string
+	Path   string
+}
+
+func (r RemotePath) String() string {
+	return r.Remote + ":" + r.Path
+}
+
+// LocalRemote is the implicit remote for plain filesystem paths. Its keys
+// are absolute paths unless a remote of that name is configured.
+const LocalRemote = "local"
+
+// ParseRemotePath parses "remote:path". An argument without a remote, such
+// as "./data" or "/srv/exports", is a path on the local filesystem.
+func ParseRemotePath(arg string) (RemotePath, error) {
+	remote, path, ok := strings.Cut(arg, ":")
+	if !ok || filepath.VolumeName(arg) != "" {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return RemotePath{}, fmt.Errorf("invalid local path %q: %v", arg, err)
+		}
+		if strings.HasSuffix(arg, "/") || strings.HasSuffix(arg, string(filepath.Separator)) {
+			abs += "/"
+		}
+		return RemotePath{Remote: LocalRemote, Path: filepath.ToSlash(abs)}, nil
+	}
+	if remote == "" {
+		return RemotePath{}, fmt.Errorf("expected remote:path, got %q", arg)
+	}
+	return RemotePath{Remote: strings.ToLower(remote), Path: path}, nil
+}
+
+// mergeProviderConfig overlays the non-zero fields of override on base.
+func mergeProviderConfig(base, override types.ProviderConfig) types.ProviderConfig {
+	merged := base
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	if override.Type != "" {
+		merged.Type = override.Type
+	}
+	set(&merged.Bucket, override.Bucket)
+	set(&merged.ProjectID, override.ProjectID)
+	set(&merged.QuotaProject, override.QuotaProject)
+	set(&merged.AccountName, override.AccountName)
+	set(&merged.AccountKey, override.AccountKey)
+	set(&merged.ContainerName, override.ContainerName)
+	set(&merged.Profile, override.Profile)
+	set(&merged.CredentialsFile, override.CredentialsFile)
+	set(&merged.SASToken, override.SASToken)
+	set(&merged.Region, override.Region)
+	set(&merged.Endpoint, override.Endpoint)
+	set(&merged.ManagedIdentityClientID, override.ManagedIdentityClientID)
+	set(&merged.Path, override.Path)
+	merged.PathStyle = merged.PathStyle || override.PathStyle
+	merged.ManagedIdentity = merged.ManagedIdentity || override.ManagedIdentity
+	return merged
+}
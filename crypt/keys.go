@@ -0,0 +1,100 @@
+// Package crypt encrypts files on the client before they reach a
+// provider. Every object gets its own random data key, which encrypts its
+// content with AES-256-GCM in 64 KiB segments and is stored in the
+// object's header wrapped by a key derived from the master key. File
+// names can be encrypted too, segment by segment, so prefixes still work
+// as directories.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeySize is the size of master and data keys: AES-256.
+const KeySize = 32
+
+// defaultSalt is used for passphrases when no salt is configured. A
+// configured salt makes the derived key specific to one setup.
+const defaultSalt = "datasyncer-crypt-v1"
+
+// Keys holds the keys derived from a master key.
+type Keys struct {
+	wrap  cipher.AEAD
+	names *nameCipher
+}
+
+// NewKeys derives the key-wrapping and file name keys from a master key.
+func NewKeys(master []byte) (*Keys, error) {
+	if len(master) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(master))
+	}
+	wrap, err := newGCM(derive(master, "datasyncer key wrapping"))
+	if err != nil {
+		return nil, err
+	}
+	names, err := newNameCipher(derive(master, "datasyncer file names"), derive(master, "datasyncer file name nonces"))
+	if err != nil {
+		return nil, err
+	}
+	return &Keys{wrap: wrap, names: names}, nil
+}
+
+// KeyFromFile reads a master key from a file holding 32 bytes, either raw
+// or encoded as hex or base64, as written by "openssl rand -hex 32".
+func KeyFromFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	if len(data) == KeySize {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key file %s must hold %d bytes, raw or as hex or base64", path, KeySize)
+}
+
+// KeyFromPassphrase derives a master key from a passphrase with scrypt.
+// The same passphrase and salt always give the same key.
+func KeyFromPassphrase(passphrase, salt string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase is empty")
+	}
+	if salt == "" {
+		salt = defaultSalt
+	}
+	key, err := scrypt.Key([]byte(passphrase), []byte(salt), 1<<15, 8, 1, KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from passphrase: %v", err)
+	}
+	return key, nil
+}
+
+func derive(master []byte, label string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
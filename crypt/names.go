@@ -0,0 +1,77 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"strings"
+)
+
+// nameEncoding only uses lowercase letters and digits, which every
+// provider and case-insensitive filesystems accept.
+var nameEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+// nameCipher encrypts names deterministically, so the same name always
+// encrypts the same way and keys can be looked up: the nonce is a MAC of
+// the name.
+type nameCipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+func newNameCipher(key, macKey []byte) (*nameCipher, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &nameCipher{aead: aead, macKey: macKey}, nil
+}
+
+func (c *nameCipher) encrypt(name string) string {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:nonceSize]
+	return nameEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(name), nil))
+}
+
+func (c *nameCipher) decrypt(name string) (string, error) {
+	data, err := nameEncoding.DecodeString(name)
+	if err != nil || len(data) < nonceSize+tagSize {
+		return "", fmt.Errorf("%q is not an encrypted name", name)
+	}
+	plain, err := c.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("%q is not an encrypted name", name)
+	}
+	return string(plain), nil
+}
+
+// EncryptPath encrypts each segment of a slash-separated path, keeping
+// the slashes, so a directory's files share its encrypted prefix.
+func (k *Keys) EncryptPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment != "" {
+			segments[i] = k.names.encrypt(segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// DecryptPath reverses EncryptPath.
+func (k *Keys) DecryptPath(path string) (string, error) {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		plain, err := k.names.decrypt(segment)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = plain
+	}
+	return strings.Join(segments, "/"), nil
+}
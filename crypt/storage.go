@@ -0,0 +1,277 @@
+package crypt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"datasyncer/types"
+)
+
+// Storage wraps a CloudStorage, encrypting files as they are uploaded and
+// decrypting them as they are read. Listings and file info report
+// decrypted names and content sizes. Content headers are not stored on
+// the provider, since they would describe the plaintext; user metadata,
+// including modification times, is stored as is.
+type Storage struct {
+	next  types.CloudStorage
+	keys  *Keys
+	names bool
+}
+
+// New wraps next. With encryptNames set, file names are encrypted too.
+func New(next types.CloudStorage, keys *Keys, encryptNames bool) *Storage {
+	return &Storage{next: next, keys: keys, names: encryptNames}
+}
+
+func (s *Storage) encryptPath(path string) string {
+	if !s.names {
+		return path
+	}
+	return s.keys.EncryptPath(path)
+}
+
+// listPrefix returns the encrypted prefix to list for a plaintext prefix.
+// Names are encrypted whole, so a partial last segment such as "logs/20"
+// lists its directory and is matched after decryption.
+func (s *Storage) listPrefix(prefix string) string {
+	if !s.names {
+		return prefix
+	}
+	return s.keys.EncryptPath(prefix[:strings.LastIndex(prefix, "/")+1])
+}
+
+// plainInfo turns the provider's view of an encrypted file into the
+// caller's, returning false for files under prefix that are not
+// encrypted names.
+func (s *Storage) plainInfo(info types.FileInfo, prefix string) (types.FileInfo, bool) {
+	if s.names {
+		path, err := s.keys.DecryptPath(info.Path)
+		if err != nil || !strings.HasPrefix(path, prefix) {
+			return info, false
+		}
+		info.Path = path
+	}
+	info.Size = PlainSize(info.Size)
+	return info, true
+}
+
+func (s *Storage) Authenticate(ctx context.Context) error {
+	return s.next.Authenticate(ctx)
+}
+
+func (s *Storage) Probe(ctx context.Context) error {
+	if prober, ok := s.next.(types.Prober); ok {
+		return prober.Probe(ctx)
+	}
+	return nil
+}
+
+func (s *Storage) ListFiles(ctx context.Context, path string) ([]types.FileInfo, error) {
+	var files []types.FileInfo
+	err := s.ListIter(ctx, path, func(file types.FileInfo) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (s *Storage) ListIter(ctx context.Context, path string, fn func(types.FileInfo) error) error {
+	return s.next.ListIter(ctx, s.listPrefix(path), func(file types.FileInfo) error {
+		if file, ok := s.plainInfo(file, path); ok {
+			return fn(file)
+		}
+		return nil
+	})
+}
+
+func (s *Storage) ListDir(ctx context.Context, prefix, delimiter string, fn func(types.DirEntry) error) error {
+	if s.names && delimiter != "/" {
+		return fmt.Errorf("listings of remotes with encrypted names only support the / delimiter")
+	}
+	return s.next.ListDir(ctx, s.listPrefix(prefix), delimiter, func(entry types.DirEntry) error {
+		if entry.IsPrefix {
+			if s.names {
+				path, err := s.keys.DecryptPath(entry.Path)
+				if err != nil || !strings.HasPrefix(path, prefix) {
+					return nil
+				}
+				entry.Path = path
+			}
+			return fn(entry)
+		}
+		file, ok := s.plainInfo(entry.FileInfo, prefix)
+		if !ok {
+			return nil
+		}
+		entry.FileInfo = file
+		return fn(entry)
+	})
+}
+
+func (s *Storage) UploadFile(ctx context.Context, localPath, remotePath string, info types.FileInfo) error {
+	source, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", localPath, err)
+	}
+	defer source.Close()
+
+	temp, err := os.CreateTemp("", "datasyncer-crypt-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	err = s.keys.Encrypt(temp, source)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %v", localPath, err)
+	}
+
+	info.Path = s.encryptPath(remotePath)
+	info.Size = CipherSize(info.Size)
+	info.ContentType = "application/octet-stream"
+	info.ContentEncoding = ""
+	info.ContentDisposition = ""
+	return s.next.UploadFile(ctx, temp.Name(), info.Path, info)
+}
+
+func (s *Storage) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	return s.download(localPath, func(temp string) error {
+		return s.next.DownloadFile(ctx, s.encryptPath(remotePath), temp)
+	})
+}
+
+// download fetches an encrypted file to a temporary file with fetch and
+// decrypts it to localPath.
+func (s *Storage) download(localPath string, fetch func(temp string) error) error {
+	temp, err := os.CreateTemp("", "datasyncer-crypt-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+	if err := fetch(temp.Name()); err != nil {
+		return err
+	}
+
+	source, err := os.Open(temp.Name())
+	if err != nil {
+		return fmt.Errorf("failed to open downloaded file: %v", err)
+	}
+	defer source.Close()
+	reader, err := s.keys.NewReader(source)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	local, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", localPath, err)
+	}
+	_, err = io.Copy(local, reader)
+	if closeErr := local.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(localPath)
+		return fmt.Errorf("failed to decrypt %s: %v", localPath, err)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteFile(ctx context.Context, path string) error {
+	return s.next.DeleteFile(ctx, s.encryptPath(path))
+}
+
+func (s *Storage) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	body, err := s.next.OpenFile(ctx, s.encryptPath(path))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := s.keys.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, body}, nil
+}
+
+func (s *Storage) GetFileInfo(ctx context.Context, path string) (types.FileInfo, error) {
+	info, err := s.next.GetFileInfo(ctx, s.encryptPath(path))
+	if err != nil {
+		return types.FileInfo{}, err
+	}
+	info.Path = path
+	info.Size = PlainSize(info.Size)
+	return info, nil
+}
+
+func (s *Storage) RestoreFile(ctx context.Context, path string, opts types.RestoreOptions) error {
+	restorer, ok := s.next.(types.Restorer)
+	if !ok {
+		return fmt.Errorf("provider cannot restore archived files")
+	}
+	return restorer.RestoreFile(ctx, s.encryptPath(path), opts)
+}
+
+func (s *Storage) versioner() (types.Versioner, error) {
+	versioner, ok := s.next.(types.Versioner)
+	if !ok {
+		return nil, fmt.Errorf("provider does not keep file versions")
+	}
+	return versioner, nil
+}
+
+func (s *Storage) ListVersions(ctx context.Context, prefix string, fn func(types.FileVersion) error) error {
+	versioner, err := s.versioner()
+	if err != nil {
+		return err
+	}
+	return versioner.ListVersions(ctx, s.listPrefix(prefix), func(version types.FileVersion) error {
+		file, ok := s.plainInfo(version.FileInfo, prefix)
+		if !ok {
+			return nil
+		}
+		version.FileInfo = file
+		return fn(version)
+	})
+}
+
+func (s *Storage) GetVersionInfo(ctx context.Context, path, versionID string) (types.FileInfo, error) {
+	versioner, err := s.versioner()
+	if err != nil {
+		return types.FileInfo{}, err
+	}
+	info, err := versioner.GetVersionInfo(ctx, s.encryptPath(path), versionID)
+	if err != nil {
+		return types.FileInfo{}, err
+	}
+	info.Path = path
+	info.Size = PlainSize(info.Size)
+	return info, nil
+}
+
+func (s *Storage) DownloadVersion(ctx context.Context, remotePath, versionID, localPath string) error {
+	versioner, err := s.versioner()
+	if err != nil {
+		return err
+	}
+	return s.download(localPath, func(temp string) error {
+		return versioner.DownloadVersion(ctx, s.encryptPath(remotePath), versionID, temp)
+	})
+}
@@ -0,0 +1,186 @@
+package crypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An encrypted object is a header followed by the content in segments:
+//
+//	magic (8 bytes)
+//	wrapped data key: nonce (12) + sealed key (32) + tag (16)
+//	segment 0: sealed plaintext (up to 64 KiB) + tag (16)
+//	...
+//
+// Segment i is sealed with the data key under a nonce holding i and, for
+// the last segment only, a final flag, so reordered, dropped or truncated
+// segments fail to decrypt.
+const (
+	magic       = "DSCRYPT1"
+	segmentSize = 64 << 10
+	tagSize     = 16
+	nonceSize   = 12
+	headerSize  = len(magic) + nonceSize + KeySize + tagSize
+)
+
+// ErrNotEncrypted is returned for objects that lack the encryption header.
+var ErrNotEncrypted = errors.New("object is not encrypted with datasyncer")
+
+func segmentNonce(i uint64, final bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce, i)
+	if final {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+// CipherSize returns the size of an encrypted object holding plain bytes.
+func CipherSize(plain int64) int64 {
+	segments := (plain + segmentSize - 1) / segmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	return int64(headerSize) + plain + segments*tagSize
+}
+
+// PlainSize returns the size of the content of an encrypted object, or 0
+// when size is too small to be one.
+func PlainSize(size int64) int64 {
+	body := size - int64(headerSize)
+	if body < tagSize {
+		return 0
+	}
+	segments := (body + segmentSize + tagSize - 1) / (segmentSize + tagSize)
+	return body - segments*tagSize
+}
+
+// Encrypt writes src to dst encrypted under a new data key.
+func (k *Keys) Encrypt(dst io.Writer, src io.Reader) error {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("failed to generate data key: %v", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	header := append([]byte(magic), nonce...)
+	header = k.wrap.Seal(header, nonce, dataKey, []byte(magic))
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(src, segmentSize)
+	plain := make([]byte, segmentSize)
+	sealed := make([]byte, 0, segmentSize+tagSize)
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(reader, plain)
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		if !final {
+			if _, err := reader.Peek(1); err == io.EOF {
+				final = true
+			} else if err != nil {
+				return err
+			}
+		}
+		sealed = aead.Seal(sealed[:0], segmentNonce(i, final), plain[:n], nil)
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// NewReader returns a reader that decrypts src. It reads the header
+// immediately, returning ErrNotEncrypted when src does not start with one.
+func (k *Keys) NewReader(src io.Reader) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, ErrNotEncrypted
+	}
+	nonce := header[len(magic) : len(magic)+nonceSize]
+	dataKey, err := k.wrap.Open(nil, nonce, header[len(magic)+nonceSize:], []byte(magic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key; the object was encrypted with a different key")
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		src:    bufio.NewReaderSize(src, segmentSize+tagSize),
+		aead:   aead,
+		sealed: make([]byte, segmentSize+tagSize),
+	}, nil
+}
+
+type decryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	sealed  []byte
+	plain   []byte
+	segment uint64
+	done    bool
+	err     error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next segment.
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.src, d.sealed)
+	final := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !final {
+		return err
+	}
+	if !final {
+		if _, err := d.src.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+	plain, err := d.aead.Open(d.sealed[:0], segmentNonce(d.segment, final), d.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("encrypted object is corrupt or truncated at segment %d", d.segment)
+	}
+	d.plain = plain
+	d.segment++
+	d.done = final
+	return nil
+}
@@ -0,0 +1,111 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"datasyncer/sync"
+	"datasyncer/types"
+)
+
+// Consumer applies events to a sync route in batches. Events for other
+// buckets are ignored, as are keys outside Options.SourcePath.
+type Consumer struct {
+	Manager *sync.SyncManager
+	Options types.SyncOptions
+	// Bucket is the source bucket or container; empty accepts any.
+	Bucket string
+	sync.WatchOptions
+}
+
+// Run consumes events until the channel is closed or ctx is cancelled,
+// syncing the pending batch before it returns. Repeated events for a key
+// within a batch collapse into the last one.
+func (c *Consumer) Run(ctx context.Context, events <-chan Event) error {
+	debounce := c.Debounce
+	if debounce <= 0 {
+		debounce = time.Second
+	}
+	maxDelay := c.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 10 * time.Second
+	}
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	pending := make(map[string]sync.Change)
+	var order []string
+	var firstPending time.Time
+
+	flush := func(ctx context.Context) {
+		if len(pending) == 0 {
+			return
+		}
+		changes := make([]sync.Change, 0, len(order))
+		for _, key := range order {
+			changes = append(changes, pending[key])
+		}
+		pending = make(map[string]sync.Change)
+		order = nil
+
+		report, err := c.Manager.SyncChanges(ctx, c.Options, changes)
+		if err != nil {
+			c.Manager.Logger.LogError(fmt.Sprintf("Failed to sync events: %v", err))
+			return
+		}
+		if report.TotalFiles > 0 {
+			c.Manager.Logger.LogInfo(fmt.Sprintf("Synced %d events: %s", len(changes), report.Summary()))
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The receiver has acknowledged these events, so they will
+			// not be delivered again.
+			flushCtx, cancel := sync.FlushContext(ctx)
+			flush(flushCtx)
+			cancel()
+			return nil
+
+		case event, ok := <-events:
+			if !ok {
+				timer.Stop()
+				flush(ctx)
+				return nil
+			}
+			if c.Bucket != "" && event.Bucket != c.Bucket {
+				continue
+			}
+			c.Manager.Logger.Log(types.DEBUG, types.LogEntry{
+				Message:   fmt.Sprintf("Received event for %s/%s (deleted=%t)", event.Bucket, event.Key, event.Deleted),
+				Operation: "event",
+				Provider:  string(c.Options.SourceProvider),
+				Source:    event.Key,
+			})
+
+			if _, seen := pending[event.Key]; !seen {
+				order = append(order, event.Key)
+			}
+			// Objects are looked up on the source when the batch is
+			// synced, deleted ones included, so the destination gets the
+			// current version even if events arrive out of order.
+			pending[event.Key] = sync.Change{Path: event.Key, Deleted: event.Deleted}
+
+			now := time.Now()
+			if len(pending) == 1 {
+				firstPending = now
+			}
+			if now.Sub(firstPending) >= maxDelay {
+				timer.Stop()
+				flush(ctx)
+			} else {
+				timer.Reset(debounce)
+			}
+
+		case <-timer.C:
+			flush(ctx)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Package events turns bucket change notifications from S3, GCS and Azure
+// into sync changes.
+package events
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a single object change reported by a storage service.
+type Event struct {
+	Bucket  string
+	Key     string
+	Deleted bool
+	Size    int64
+	ETag    string
+	Time    time.Time
+}
+
+// Parse decodes a notification message into events. It accepts S3 event
+// records (directly, delivered through SNS or SQS, or as EventBridge
+// events), GCS Pub/Sub object notifications (push envelopes or bare
+// messages) and Azure Event Grid blob events (Event Grid or CloudEvents
+// schema, single or batched). Events it does not recognise, such as
+// metadata updates, are ignored, as are SNS subscription confirmations,
+// which the Receiver handles before parsing.
+func Parse(body []byte) ([]Event, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, fmt.Errorf("invalid event batch: %v", err)
+		}
+		var events []Event
+		for _, item := range batch {
+			parsed, err := Parse(item)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, parsed...)
+		}
+		return events, nil
+	}
+
+	var probe struct {
+		Records    json.RawMessage `json:"Records"`
+		Type       string          `json:"Type"`
+		Message    json.RawMessage `json:"message"`
+		SNSMessage string          `json:"Message"`
+		Attributes json.RawMessage `json:"attributes"`
+		DetailType string          `json:"detail-type"`
+		EventType  string          `json:"eventType"`
+		CloudType  string          `json:"type"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+
+	switch {
+	case probe.Records != nil:
+		return parseS3(body)
+	case probe.Type == "Notification" && probe.SNSMessage != "":
+		return Parse([]byte(probe.SNSMessage))
+	case probe.DetailType != "":
+		return parseEventBridge(body)
+	case probe.Message != nil:
+		return parsePubSub(probe.Message)
+	case probe.Attributes != nil:
+		return parsePubSub(body)
+	case strings.HasPrefix(probe.EventType, "Microsoft.Storage."),
+		strings.HasPrefix(probe.CloudType, "Microsoft.Storage."):
+		return parseEventGrid(body)
+	}
+	return nil, nil
+}
+
+func parseS3(body []byte) ([]Event, error) {
+	var message struct {
+		Records []struct {
+			// Body holds the S3 event when records come from an SQS queue.
+			Body      string    `json:"body"`
+			EventName string    `json:"eventName"`
+			EventTime time.Time `json:"eventTime"`
+			S3        struct {
+				Bucket struct {
+					Name string `json:"name"`
+				} `json:"bucket"`
+				Object struct {
+					Key  string `json:"key"`
+					Size int64  `json:"size"`
+					ETag string `json:"eTag"`
+				} `json:"object"`
+			} `json:"s3"`
+		} `json:"Records"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, fmt.Errorf("invalid S3 event: %v", err)
+	}
+
+	var events []Event
+	for _, record := range message.Records {
+		if record.Body != "" {
+			parsed, err := Parse([]byte(record.Body))
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, parsed...)
+			continue
+		}
+
+		var deleted bool
+		switch {
+		case strings.HasPrefix(record.EventName, "ObjectCreated:"):
+		case strings.HasPrefix(record.EventName, "ObjectRemoved:"):
+			deleted = true
+		default:
+			continue
+		}
+		// S3 form-encodes keys in notifications, spaces included.
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 object key %q: %v", record.S3.Object.Key, err)
+		}
+		events = append(events, Event{
+			Bucket:  record.S3.Bucket.Name,
+			Key:     key,
+			Deleted: deleted,
+			Size:    record.S3.Object.Size,
+			ETag:    record.S3.Object.ETag,
+			Time:    record.EventTime,
+		})
+	}
+	return events, nil
+}
+
+func parseEventBridge(body []byte) ([]Event, error) {
+	var message struct {
+		DetailType string    `json:"detail-type"`
+		Source     string    `json:"source"`
+		Time       time.Time `json:"time"`
+		Detail     struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				Size int64  `json:"size"`
+				ETag string `json:"etag"`
+			} `json:"object"`
+		} `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, fmt.Errorf("invalid EventBridge event: %v", err)
+	}
+	if message.Source != "aws.s3" {
+		return nil, nil
+	}
+
+	var deleted bool
+	switch message.DetailType {
+	case "Object Created":
+	case "Object Deleted":
+		deleted = true
+	default:
+		return nil, nil
+	}
+	return []Event{{
+		Bucket:  message.Detail.Bucket.Name,
+		Key:     message.Detail.Object.Key,
+		Deleted: deleted,
+		Size:    message.Detail.Object.Size,
+		ETag:    message.Detail.Object.ETag,
+		Time:    message.Time,
+	}}, nil
+}
+
+func parsePubSub(body []byte) ([]Event, error) {
+	var message struct {
+		Attributes struct {
+			EventType string `json:"eventType"`
+			BucketID  string `json:"bucketId"`
+			ObjectID  string `json:"objectId"`
+			EventTime string `json:"eventTime"`
+			// OverwrittenBy is set on deletes that replaced the object
+			// with a new generation.
+			OverwrittenBy string `json:"overwrittenByGeneration"`
+		} `json:"attributes"`
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, fmt.Errorf("invalid Pub/Sub message: %v", err)
+	}
+
+	var deleted bool
+	switch message.Attributes.EventType {
+	case "OBJECT_FINALIZE":
+	case "OBJECT_DELETE":
+		// Overwrites delete the old generation; the new one has its own
+		// finalize event.
+		if message.Attributes.OverwrittenBy != "" {
+			return nil, nil
+		}
+		deleted = true
+	default:
+		// OBJECT_ARCHIVE is sent for the old generation whenever an object
+		// in a versioned bucket is overwritten or deleted, so it is not a
+		// delete of the live object.
+		return nil, nil
+	}
+
+	event := Event{
+		Bucket:  message.Attributes.BucketID,
+		Key:     message.Attributes.ObjectID,
+		Deleted: deleted,
+	}
+	event.Time, _ = time.Parse(time.RFC3339Nano, message.Attributes.EventTime)
+
+	// The payload, when present, is the object resource.
+	if data, err := base64.StdEncoding.DecodeString(message.Data); err == nil && len(data) > 0 {
+		var object struct {
+			Size string `json:"size"`
+			ETag string `json:"etag"`
+		}
+		if json.Unmarshal(data, &object) == nil {
+			event.Size, _ = strconv.ParseInt(object.Size, 10, 64)
+			event.ETag = object.ETag
+		}
+	}
+	return []Event{event}, nil
+}
+
+// eventGridEvent covers both the Event Grid and CloudEvents schemas.
+type eventGridEvent struct {
+	EventType string    `json:"eventType"`
+	Type      string    `json:"type"`
+	Subject   string    `json:"subject"`
+	EventTime time.Time `json:"eventTime"`
+	Time      time.Time `json:"time"`
+	Data      struct {
+		ContentLength  int64  `json:"contentLength"`
+		ETag           string `json:"eTag"`
+		ValidationCode string `json:"validationCode"`
+	} `json:"data"`
+}
+
+func parseEventGrid(body []byte) ([]Event, error) {
+	var message eventGridEvent
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, fmt.Errorf("invalid Event Grid event: %v", err)
+	}
+
+	eventType := message.EventType
+	if eventType == "" {
+		eventType = message.Type
+	}
+	var deleted bool
+	switch eventType {
+	case "Microsoft.Storage.BlobCreated":
+	case "Microsoft.Storage.BlobDeleted":
+		deleted = true
+	default:
+		return nil, nil
+	}
+
+	// Subjects look like /blobServices/default/containers/{container}/blobs/{name}.
+	rest, ok := strings.CutPrefix(message.Subject, "/blobServices/default/containers/")
+	if !ok {
+		return nil, fmt.Errorf("unexpected Event Grid subject %q", message.Subject)
+	}
+	container, name, ok := strings.Cut(rest, "/blobs/")
+	if !ok {
+		return nil, fmt.Errorf("unexpected Event Grid subject %q", message.Subject)
+	}
+
+	eventTime := message.EventTime
+	if eventTime.IsZero() {
+		eventTime = message.Time
+	}
+	return []Event{{
+		Bucket:  container,
+		Key:     name,
+		Deleted: deleted,
+		Size:    message.Data.ContentLength,
+		ETag:    message.Data.ETag,
+		Time:    eventTime,
+	}}, nil
+}
+
+// validationCode returns the code of an Event Grid subscription validation
+// event, which the receiving endpoint must echo back.
+func validationCode(body []byte) (string, bool) {
+	body = bytes.TrimSpace(body)
+	var batch []eventGridEvent
+	if len(body) > 0 && body[0] == '[' {
+		if json.Unmarshal(body, &batch) != nil {
+			return "", false
+		}
+	} else {
+		var single eventGridEvent
+		if json.Unmarshal(body, &single) != nil {
+			return "", false
+		}
+		batch = append(batch, single)
+	}
+	for _, event := range batch {
+		if event.EventType == "Microsoft.EventGrid.SubscriptionValidationEvent" && event.Data.ValidationCode != "" {
+			return event.Data.ValidationCode, true
+		}
+	}
+	return "", false
+}
+
+// snsHost matches the hosts SNS sends subscription confirmation links for.
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// subscribeURL returns the link that confirms an SNS HTTP(S) subscription,
+// when body is a subscription confirmation. Links to hosts other than SNS
+// are rejected, so a forged message cannot make the receiver fetch them.
+func subscribeURL(body []byte) (string, bool, error) {
+	var message struct {
+		Type         string `json:"Type"`
+		TopicArn     string `json:"TopicArn"`
+		SubscribeURL string `json:"SubscribeURL"`
+	}
+	if json.Unmarshal(bytes.TrimSpace(body), &message) != nil || message.Type != "SubscriptionConfirmation" {
+		return "", false, nil
+	}
+	link, err := url.Parse(message.SubscribeURL)
+	if err != nil || link.Scheme != "https" || !snsHost.MatchString(link.Hostname()) {
+		return "", true, fmt.Errorf("SNS subscription confirmation for %s has an unexpected SubscribeURL %q", message.TopicArn, message.SubscribeURL)
+	}
+	return link.String(), true, nil
+}
@@ -0,0 +1,173 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"datasyncer/types"
+)
+
+// maxEventBody bounds the size of a single notification request or line.
+const maxEventBody = 8 << 20
+
+// Receiver is an HTTP endpoint that accepts notification messages posted by
+// SNS, Pub/Sub push subscriptions, Event Grid or anything that can post
+// the same JSON. SNS subscription confirmations are answered by fetching
+// their SubscribeURL; SNS cannot send headers, so its endpoint URL carries
+// the token as a query parameter.
+type Receiver struct {
+	Events chan<- Event
+	Logger *types.Logger
+	// Token must be sent in the X-Datasyncer-Token header or the token
+	// query parameter. A receiver without one rejects every request.
+	Token string
+}
+
+func (rcv *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Event Grid's CloudEvents webhook handshake.
+	if r.Method == http.MethodOptions && r.Header.Get("WebHook-Request-Origin") != "" {
+		w.Header().Set("WebHook-Allowed-Origin", r.Header.Get("WebHook-Request-Origin"))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.Header.Get("X-Datasyncer-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if rcv.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(rcv.Token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxEventBody))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if code, ok := validationCode(body); ok {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"validationResponse": code})
+		return
+	}
+
+	if link, ok, err := subscribeURL(body); ok {
+		if err != nil {
+			rcv.Logger.LogWarn(fmt.Sprintf("Rejected event from %s: %v", r.RemoteAddr, err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := rcv.confirm(r.Context(), link); err != nil {
+			rcv.Logger.LogError(err.Error())
+			http.Error(w, "failed to confirm subscription", http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	events, err := Parse(body)
+	if err != nil {
+		rcv.Logger.LogWarn(fmt.Sprintf("Rejected event from %s: %v", r.RemoteAddr, err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, event := range events {
+		select {
+		case rcv.Events <- event:
+		case <-r.Context().Done():
+			http.Error(w, "request cancelled", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// confirm fetches the link that confirms an SNS subscription, without
+// which SNS delivers nothing. On failure the link is logged so it can be
+// opened by hand.
+func (rcv *Receiver) confirm(ctx context.Context, link string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return fmt.Errorf("failed to confirm SNS subscription: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to confirm SNS subscription, open %s to confirm it: %v", link, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to confirm SNS subscription, open %s to confirm it: status %s", link, resp.Status)
+	}
+	rcv.Logger.LogInfo("Confirmed SNS subscription")
+	return nil
+}
+
+// Serve runs the receiver on addr until ctx is cancelled.
+func (rcv *Receiver) Serve(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	server := &http.Server{Handler: rcv, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	rcv.Logger.LogInfo(fmt.Sprintf("Receiving events on %s", listener.Addr()))
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("event receiver stopped: %v", err)
+	}
+	return nil
+}
+
+// ReadLines reads newline-delimited notification messages from r, such as
+// a file of saved events or a named pipe, until EOF or ctx is cancelled.
+// Lines that cannot be parsed are logged and skipped.
+func ReadLines(ctx context.Context, r io.Reader, out chan<- Event, logger *types.Logger) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxEventBody)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		events, err := Parse(scanner.Bytes())
+		if err != nil {
+			logger.LogWarn(fmt.Sprintf("Skipping event on line %d: %v", line, err))
+			continue
+		}
+		for _, event := range events {
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read events: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package inventory
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"datasyncer/types"
+)
+
+// Group is the number of files and bytes under one key of a breakdown.
+type Group struct {
+	Key   string `json:"key"`
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+func (g *Group) add(size int64) {
+	g.Files++
+	g.Bytes += size
+}
+
+// AgeBucket groups files last modified less than MaxAge ago.
+type AgeBucket struct {
+	Label  string
+	MaxAge time.Duration
+}
+
+// AgeBuckets are the age bands used by Usage, youngest first. Files older
+// than the last band fall in "older".
+var AgeBuckets = []AgeBucket{
+	{"< 1 day", 24 * time.Hour},
+	{"1-7 days", 7 * 24 * time.Hour},
+	{"7-30 days", 30 * 24 * time.Hour},
+	{"30-90 days", 90 * 24 * time.Hour},
+	{"90-365 days", 365 * 24 * time.Hour},
+}
+
+const (
+	olderBucket = "> 1 year"
+	// RootGroup stands for files directly under the listed prefix.
+	RootGroup = "./"
+	noGroup   = "(none)"
+)
+
+// Usage aggregates a listing by prefix, extension, storage class and age.
+type Usage struct {
+	Prefix string
+	// Depth is the number of path segments below Prefix that files are
+	// grouped by.
+	Depth int
+	// Now is the reference time for ages.
+	Now   time.Time
+	Total Group
+
+	prefixes map[string]*Group
+	exts     map[string]*Group
+	classes  map[string]*Group
+	ages     map[string]*Group
+}
+
+func NewUsage(prefix string, depth int, now time.Time) *Usage {
+	if depth < 1 {
+		depth = 1
+	}
+	return &Usage{
+		Prefix:   prefix,
+		Depth:    depth,
+		Now:      now,
+		Total:    Group{Key: prefix},
+		prefixes: make(map[string]*Group),
+		exts:     make(map[string]*Group),
+		classes:  make(map[string]*Group),
+		ages:     make(map[string]*Group),
+	}
+}
+
+// Add counts one file.
+func (u *Usage) Add(file types.FileInfo) {
+	u.Total.add(file.Size)
+	group(u.prefixes, u.prefixKey(file.Path)).add(file.Size)
+	group(u.exts, extensionKey(file.Path)).add(file.Size)
+	class := file.StorageClass
+	if class == "" {
+		class = noGroup
+	}
+	group(u.classes, class).add(file.Size)
+	group(u.ages, u.ageKey(file.ModTime)).add(file.Size)
+}
+
+func group(groups map[string]*Group, key string) *Group {
+	g, ok := groups[key]
+	if !ok {
+		g = &Group{Key: key}
+		groups[key] = g
+	}
+	return g
+}
+
+// prefixKey returns the first Depth directories of key below Prefix.
+func (u *Usage) prefixKey(key string) string {
+	// A prefix without a trailing slash, like "logs", lists "logs/..." and
+	// also "logs-old/...": group relative to the directory it ends in.
+	base := u.Prefix
+	if !strings.HasSuffix(base, "/") {
+		base = base[:strings.LastIndex(base, "/")+1]
+	}
+	parts := strings.Split(strings.TrimPrefix(key, base), "/")
+	if len(parts) == 1 {
+		return RootGroup
+	}
+	parts = parts[:len(parts)-1]
+	if len(parts) > u.Depth {
+		parts = parts[:u.Depth]
+	}
+	return strings.Join(parts, "/") + "/"
+}
+
+func extensionKey(key string) string {
+	ext := strings.ToLower(path.Ext(path.Base(key)))
+	if ext == "" {
+		return noGroup
+	}
+	return ext
+}
+
+func (u *Usage) ageKey(modified time.Time) string {
+	age := u.Now.Sub(modified)
+	for _, bucket := range AgeBuckets {
+		if age < bucket.MaxAge {
+			return bucket.Label
+		}
+	}
+	return olderBucket
+}
+
+// ByPrefix returns the prefix groups, largest first.
+func (u *Usage) ByPrefix() []Group { return bySize(u.prefixes) }
+
+// ByExtension returns the extension groups, largest first.
+func (u *Usage) ByExtension() []Group { return bySize(u.exts) }
+
+// ByStorageClass returns the storage class groups, largest first.
+func (u *Usage) ByStorageClass() []Group { return bySize(u.classes) }
+
+// ByAge returns the age groups, youngest first, omitting empty bands.
+func (u *Usage) ByAge() []Group {
+	var groups []Group
+	for _, label := range append(ageLabels(), olderBucket) {
+		if g, ok := u.ages[label]; ok {
+			groups = append(groups, *g)
+		}
+	}
+	return groups
+}
+
+func ageLabels() []string {
+	labels := make([]string, len(AgeBuckets))
+	for i, bucket := range AgeBuckets {
+		labels[i] = bucket.Label
+	}
+	return labels
+}
+
+func bySize(groups map[string]*Group) []Group {
+	sorted := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, *g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Bytes != sorted[j].Bytes {
+			return sorted[i].Bytes > sorted[j].Bytes
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
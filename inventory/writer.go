@@ -0,0 +1,138 @@
+package inventory
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"datasyncer/types"
+)
+
+// Output formats supported by NewWriter.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// rowGroupSize bounds the rows a Parquet writer buffers before flushing a
+// row group, so inventories of large buckets are written in constant memory.
+const rowGroupSize = 100000
+
+// Record is one file of an inventory.
+type Record struct {
+	Remote       string    `json:"remote" parquet:"remote,dict"`
+	Path         string    `json:"path" parquet:"path"`
+	Size         int64     `json:"size" parquet:"size"`
+	LastModified time.Time `json:"last_modified" parquet:"last_modified,timestamp(millisecond)"`
+	ModTime      time.Time `json:"mod_time" parquet:"mod_time,timestamp(millisecond)"`
+	ETag         string    `json:"etag,omitempty" parquet:"etag"`
+	StorageClass string    `json:"storage_class,omitempty" parquet:"storage_class,dict"`
+}
+
+func NewRecord(remote string, file types.FileInfo) Record {
+	return Record{
+		Remote:       remote,
+		Path:         file.Path,
+		Size:         file.Size,
+		LastModified: file.LastModified.UTC(),
+		ModTime:      file.ModTime.UTC(),
+		ETag:         file.ETag,
+		StorageClass: file.StorageClass,
+	}
+}
+
+// Writer writes inventory records in one format. Close flushes buffered
+// records; it does not close the underlying writer.
+type Writer interface {
+	Write(record Record) error
+	Close() error
+}
+
+// NewWriter returns a Writer for format.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		writer := &csvWriter{w: csv.NewWriter(w)}
+		return writer, writer.w.Write([]string{"remote", "path", "size", "last_modified", "mod_time", "etag", "storage_class"})
+	case FormatJSONL:
+		buffered := bufio.NewWriter(w)
+		return &jsonlWriter{buffered: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case FormatParquet:
+		return &parquetWriter{w: parquet.NewGenericWriter[Record](w,
+			parquet.Compression(&parquet.Zstd),
+			parquet.MaxRowsPerRowGroup(rowGroupSize),
+		)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported inventory format %q: use csv, jsonl or parquet", format)
+	}
+}
+
+// FormatForPath infers the format from a file name's extension, returning
+// "" when it names none.
+func FormatForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	case ".parquet":
+		return FormatParquet
+	}
+	return ""
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(record Record) error {
+	return c.w.Write([]string{
+		record.Remote,
+		record.Path,
+		strconv.FormatInt(record.Size, 10),
+		record.LastModified.Format(time.RFC3339),
+		record.ModTime.Format(time.RFC3339),
+		record.ETag,
+		record.StorageClass,
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (j *jsonlWriter) Write(record Record) error {
+	return j.encoder.Encode(record)
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.buffered.Flush()
+}
+
+type parquetWriter struct {
+	w *parquet.GenericWriter[Record]
+}
+
+func (p *parquetWriter) Write(record Record) error {
+	_, err := p.w.Write([]Record{record})
+	return err
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.Close()
+}
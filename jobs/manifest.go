@@ -0,0 +1,256 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+
+	"datasyncer/config"
+	"datasyncer/types"
+)
+
+// Job is one sync declared in a jobs file. Source and Destination are
+// remote:path locations; unset fields fall back to the config defaults.
+type Job struct {
+	Name               string   `mapstructure:"name"`
+	Source             string   `mapstructure:"source"`
+	Destination        string   `mapstructure:"destination"`
+	Include            []string `mapstructure:"include"`
+	Exclude            []string `mapstructure:"exclude"`
+	ConflictResolution string   `mapstructure:"conflict_resolution"`
+	Parallel           int      `mapstructure:"parallel"`
+	IncrementalSync    *bool    `mapstructure:"incremental_sync"`
+	Mirror             bool     `mapstructure:"mirror"`
+	ReportPath         string   `mapstructure:"report_path"`
+	// StorageClass and StorageRules replace the destination remote's.
+	StorageClass string              `mapstructure:"storage_class"`
+	StorageRules []types.StorageRule `mapstructure:"storage_rules"`
+	// Restore requests restores of archived source files and waits for
+	// them, using the restore settings in the config defaults.
+	Restore bool `mapstructure:"restore"`
+	// AllVersions replays every source version instead of the current one.
+	AllVersions bool `mapstructure:"all_versions"`
+	// Backup takes a snapshot under the destination instead of syncing to
+	// it. With Prune set, snapshots are pruned after each backup by
+	// Retention, or the config's backup retention when it is unset.
+	Backup    bool                   `mapstructure:"backup"`
+	Prune     bool                   `mapstructure:"prune"`
+	Retention *types.RetentionPolicy `mapstructure:"retention"`
+	// Schedule is a cron expression, or a descriptor such as "@daily",
+	// used by the daemon; `run` ignores it. Every runs the job at a fixed
+	// interval instead.
+	Schedule string        `mapstructure:"schedule"`
+	Every    time.Duration `mapstructure:"every"`
+}
+
+// Manifest is a jobs file, for example:
+//
+//	concurrency: 2
+//	jobs:
+//	  - name: exports
+//	    source: prod-s3:exports/
+//	    destination: dr-gcs:exports/
+//	    include: ["*.csv"]
+//	    parallel: 8
+//	    schedule: "0 2 * * *"
+//	  - name: nightly-backup
+//	    source: prod-s3:exports/
+//	    destination: dr-gcs:backups/exports
+//	    backup: true
+//	    prune: true
+//	    schedule: "@daily"
+type Manifest struct {
+	// Concurrency is how many jobs run at once; 0 or 1 runs them in order.
+	Concurrency int   `mapstructure:"concurrency"`
+	Jobs        []Job `mapstructure:"jobs"`
+
+	// File is the path the manifest was read from.
+	File string `mapstructure:"-"`
+}
+
+// LoadManifest reads a YAML, TOML or JSON jobs file, rejecting unknown keys.
+func LoadManifest(path string) (*Manifest, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read jobs file: %v", err)
+	}
+
+	var manifest Manifest
+	if err := v.UnmarshalExact(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid jobs file %s: %v", path, err)
+	}
+	manifest.File = path
+	return &manifest, nil
+}
+
+// Validate checks that every job is named uniquely and refers to remotes
+// defined in cfg.
+func (m *Manifest) Validate(cfg *config.Config) error {
+	var errs []error
+
+	if len(m.Jobs) == 0 {
+		errs = append(errs, fmt.Errorf("no jobs defined"))
+	}
+	if m.Concurrency < 0 {
+		errs = append(errs, fmt.Errorf("concurrency must not be negative"))
+	}
+
+	seen := make(map[string]bool)
+	for i, job := range m.Jobs {
+		name := job.Name
+		if name == "" {
+			errs = append(errs, fmt.Errorf("jobs[%d]: name is required", i))
+			name = fmt.Sprintf("jobs[%d]", i)
+		} else if seen[name] {
+			errs = append(errs, fmt.Errorf("job %s: duplicate name", name))
+		}
+		seen[name] = true
+
+		for _, location := range []string{job.Source, job.Destination} {
+			remotePath, err := config.ParseRemotePath(location)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("job %s: %v", name, err))
+				continue
+			}
+			if !cfg.HasRemote(remotePath.Remote) {
+				errs = append(errs, fmt.Errorf("job %s: unknown remote: %s", name, remotePath.Remote))
+			}
+		}
+
+		switch job.ConflictResolution {
+		case "", "overwrite", "skip", "archive":
+		default:
+			errs = append(errs, fmt.Errorf("job %s: conflict_resolution must be overwrite, skip or archive, got %q", name, job.ConflictResolution))
+		}
+		if job.Schedule != "" && job.Every > 0 {
+			errs = append(errs, fmt.Errorf("job %s: set either schedule or every, not both", name))
+		} else if job.Schedule != "" {
+			if _, err := cron.ParseStandard(job.Schedule); err != nil {
+				errs = append(errs, fmt.Errorf("job %s: invalid schedule %q: %v", name, job.Schedule, err))
+			}
+		} else if job.Every < 0 {
+			errs = append(errs, fmt.Errorf("job %s: every must not be negative", name))
+		}
+		if job.Parallel < 0 {
+			errs = append(errs, fmt.Errorf("job %s: parallel must not be negative", name))
+		}
+		if job.Backup && (job.Mirror || job.AllVersions) {
+			errs = append(errs, fmt.Errorf("job %s: backups cannot mirror or replay versions", name))
+		}
+		if (job.Prune || job.Retention != nil) && !job.Backup {
+			errs = append(errs, fmt.Errorf("job %s: prune and retention only apply to backups", name))
+		}
+		if job.Retention != nil {
+			if err := job.Retention.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("job %s: retention: %v", name, err))
+			}
+		}
+		if job.StorageClass != "" {
+			if err := types.ValidateStorageClass(job.StorageClass); err != nil {
+				errs = append(errs, fmt.Errorf("job %s: %v", name, err))
+			}
+		}
+		for i, rule := range job.StorageRules {
+			if err := types.ValidateStorageClass(rule.StorageClass); err != nil {
+				errs = append(errs, fmt.Errorf("job %s: storage_rules[%d]: %v", name, i, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Select returns the jobs with the given names, in manifest order, or every
+// job when names is empty.
+func (m *Manifest) Select(names []string) ([]Job, error) {
+	if len(names) == 0 {
+		return m.Jobs, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var selected []Job
+	for _, job := range m.Jobs {
+		if wanted[job.Name] {
+			selected = append(selected, job)
+			delete(wanted, job.Name)
+		}
+	}
+	if len(wanted) > 0 {
+		missing := make([]string, 0, len(wanted))
+		for _, name := range names {
+			if wanted[name] {
+				missing = append(missing, name)
+			}
+		}
+		return nil, fmt.Errorf("unknown job: %s", strings.Join(missing, ", "))
+	}
+	return selected, nil
+}
+
+// Remotes returns the distinct remote names the jobs refer to.
+func Remotes(jobs []Job) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, job := range jobs {
+		for _, location := range []string{job.Source, job.Destination} {
+			remotePath, err := config.ParseRemotePath(location)
+			if err != nil || seen[remotePath.Remote] {
+				continue
+			}
+			seen[remotePath.Remote] = true
+			names = append(names, remotePath.Remote)
+		}
+	}
+	return names
+}
+
+// SyncOptions resolves the job into sync options, starting from the config
+// defaults.
+func (j Job) SyncOptions(cfg *config.Config) (types.SyncOptions, error) {
+	source, err := config.ParseRemotePath(j.Source)
+	if err != nil {
+		return types.SyncOptions{}, fmt.Errorf("job %s: %v", j.Name, err)
+	}
+	destination, err := config.ParseRemotePath(j.Destination)
+	if err != nil {
+		return types.SyncOptions{}, fmt.Errorf("job %s: %v", j.Name, err)
+	}
+
+	opts := cfg.SyncOptions(source, destination)
+	opts.Include = j.Include
+	opts.Exclude = j.Exclude
+	opts.Mirror = j.Mirror
+	opts.Restore = j.Restore
+	opts.AllVersions = j.AllVersions
+	if j.ConflictResolution != "" {
+		opts.ConflictResolution = j.ConflictResolution
+	}
+	if j.Parallel > 0 {
+		opts.Parallel = j.Parallel
+	}
+	if j.IncrementalSync != nil {
+		opts.IncrementalSync = *j.IncrementalSync
+	}
+	if j.ReportPath != "" {
+		opts.ReportPath = j.ReportPath
+	}
+	if j.StorageClass != "" {
+		opts.StorageClass = j.StorageClass
+	}
+	if j.StorageRules != nil {
+		opts.StorageRules = j.StorageRules
+	}
+	if opts.Parallel < 1 {
+		opts.Parallel = 1
+	}
+	return opts, nil
+}
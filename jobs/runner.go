@@ -0,0 +1,231 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"text/template"
+	"time"
+
+	"datasyncer/config"
+	"datasyncer/sync"
+	"datasyncer/types"
+)
+
+// Job statuses in a combined report.
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+type JobResult struct {
+	Name      string          `json:"name"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	StartTime time.Time       `json:"start_time"`
+	EndTime   time.Time       `json:"end_time"`
+	Report    *sync.RunReport `json:"report,omitempty"`
+}
+
+func (r JobResult) Failed() bool {
+	return r.Status == StatusFailed
+}
+
+// Report combines the results of every job in a run, in manifest order.
+type Report struct {
+	StartTime time.Time   `json:"start_time"`
+	EndTime   time.Time   `json:"end_time"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Jobs      []JobResult `json:"jobs"`
+}
+
+// Runner executes jobs through a SyncManager. Connect must authenticate a
+// remote and register it with Manager; the runner calls it for every remote
+// before starting any job, since jobs running concurrently share the
+// manager's providers.
+type Runner struct {
+	Config      *config.Config
+	Manager     *sync.SyncManager
+	Connect     func(ctx context.Context, remote string) error
+	Concurrency int
+	// RetryFailed gives files that ran out of attempts in earlier runs a
+	// fresh set at the start of every job, so a scheduled job is not stuck
+	// on files that failed during a passing outage.
+	RetryFailed bool
+
+	connectErrs map[string]error
+}
+
+// Prepare connects every remote the jobs use. A remote that fails to
+// connect only fails the jobs that use it.
+func (r *Runner) Prepare(ctx context.Context, jobs []Job) {
+	if r.connectErrs == nil {
+		r.connectErrs = make(map[string]error)
+	}
+	for _, remote := range Remotes(jobs) {
+		if err := r.Connect(ctx, remote); err != nil {
+			r.connectErrs[remote] = err
+		}
+	}
+}
+
+func (r *Runner) Run(ctx context.Context, jobs []Job) *Report {
+	report := &Report{
+		StartTime: time.Now(),
+		Jobs:      make([]JobResult, len(jobs)),
+	}
+
+	r.Prepare(ctx, jobs)
+
+	concurrency := r.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg gosync.WaitGroup
+
+	for i, job := range jobs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, job Job) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			report.Jobs[i] = r.RunJob(ctx, job)
+		}(i, job)
+	}
+	wg.Wait()
+
+	report.EndTime = time.Now()
+	for _, result := range report.Jobs {
+		if result.Failed() {
+			report.Failed++
+		} else {
+			report.Succeeded++
+		}
+	}
+	return report
+}
+
+// RunJob runs a single job. Prepare must have been called with it first.
+func (r *Runner) RunJob(ctx context.Context, job Job) JobResult {
+	result := JobResult{Name: job.Name, StartTime: time.Now()}
+	finish := func(err error) JobResult {
+		result.EndTime = time.Now()
+		result.Status = StatusSucceeded
+		if err != nil {
+			result.Status = StatusFailed
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	opts, err := job.SyncOptions(r.Config)
+	if err != nil {
+		return finish(err)
+	}
+	for _, remote := range []types.CloudProvider{opts.SourceProvider, opts.DestinationProvider} {
+		if err := r.connectErrs[string(remote)]; err != nil {
+			return finish(err)
+		}
+	}
+
+	r.Manager.Logger.LogInfo(fmt.Sprintf("Starting job %s", job.Name))
+	if r.RetryFailed {
+		if n := r.Manager.Recovery.ResetAttempts(opts); n > 0 {
+			r.Manager.Logger.LogInfo(fmt.Sprintf("Retrying %d files that failed in earlier runs of %s", n, job.Name))
+		}
+	}
+	if job.Backup {
+		result.Report, err = r.backup(ctx, job, opts)
+	} else {
+		result.Report, err = r.Manager.Sync(ctx, opts)
+	}
+	if err == nil && result.Report.Failed > 0 {
+		err = fmt.Errorf("%d files failed to sync", result.Report.Failed)
+	}
+	if err != nil {
+		r.Manager.Logger.LogError(fmt.Sprintf("Job %s failed: %v", job.Name, err))
+	} else {
+		r.Manager.Logger.LogInfo(fmt.Sprintf("Job %s finished: %s", job.Name, result.Report.Summary()))
+	}
+	return finish(err)
+}
+
+// backup takes the job's snapshot, then prunes when the job asks to.
+// Pruning only follows a backup without failures, so a broken source
+// never ages out good snapshots.
+func (r *Runner) backup(ctx context.Context, job Job, opts types.SyncOptions) (*sync.RunReport, error) {
+	_, report, err := r.Manager.Backup(ctx, opts)
+	if err != nil || !job.Prune || report.Failed > 0 {
+		return report, err
+	}
+
+	policy := r.Config.Backup.Retention
+	if job.Retention != nil {
+		policy = *job.Retention
+	}
+	if policy.IsZero() {
+		return report, fmt.Errorf("no retention policy to prune with; set retention or backup.retention")
+	}
+	_, pruned, err := r.Manager.Prune(ctx, opts.DestinationProvider, opts.DestinationPath, policy, opts.Parallel)
+	if err != nil {
+		return report, fmt.Errorf("failed to prune snapshots: %v", err)
+	}
+	if pruned.Failed > 0 {
+		return report, fmt.Errorf("%d files failed to delete while pruning", pruned.Failed)
+	}
+	return report, nil
+}
+
+// WriteFiles writes the combined report as JSON to path and as Markdown next
+// to it.
+func (r *Report) WriteFiles(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create report directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+
+	markdown, err := r.RenderMarkdown()
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if err := os.WriteFile(base+".md", []byte(markdown), 0644); err != nil {
+		return fmt.Errorf("failed to write markdown report: %v", err)
+	}
+	return nil
+}
+
+const markdownReport = `# Jobs report
+
+{{.Succeeded}} succeeded, {{.Failed}} failed, started {{time .StartTime}}, took {{since .StartTime .EndTime}}.
+
+| Job | Status | Duration | Result |
+|---|---|---|---|
+{{range .Jobs}}| {{.Name}} | {{.Status}} | {{since .StartTime .EndTime}} | {{if .Error}}{{.Error}}{{else if .Report}}{{.Report.Summary}}{{end}} |
+{{end}}`
+
+var markdownTemplate = template.Must(template.New("jobs").Funcs(map[string]any{
+	"time":  func(t time.Time) string { return t.Format(time.RFC3339) },
+	"since": func(start, end time.Time) string { return end.Sub(start).Round(time.Millisecond).String() },
+}).Parse(markdownReport))
+
+func (r *Report) RenderMarkdown() (string, error) {
+	var b strings.Builder
+	if err := markdownTemplate.Execute(&b, r); err != nil {
+		return "", fmt.Errorf("failed to render markdown report: %v", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,274 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"datasyncer/types"
+)
+
+// Job run states persisted by the scheduler. A job left "running" when the
+// daemon stopped is reported as interrupted on the next start; the
+// recovery state lets its next scheduled run skip the files it completed.
+const (
+	StatusRunning     = "running"
+	StatusInterrupted = "interrupted"
+)
+
+// JobState is the last known outcome of a scheduled job.
+type JobState struct {
+	Status      string    `json:"status"`
+	LastStart   time.Time `json:"last_start"`
+	LastEnd     time.Time `json:"last_end"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastSummary string    `json:"last_summary,omitempty"`
+	Runs        int       `json:"runs"`
+	Failures    int       `json:"failures"`
+	Skipped     int       `json:"skipped"`
+	NextRun     time.Time `json:"next_run"`
+}
+
+// Scheduler runs jobs on their schedules until its context is cancelled.
+// A run that is due while the previous run of the same job is still going
+// is skipped rather than queued.
+type Scheduler struct {
+	Runner    *Runner
+	StatePath string
+
+	cron    *cron.Cron
+	slots   chan struct{}
+	mu      gosync.Mutex
+	saveMu  gosync.Mutex
+	running map[string]bool
+	states  map[string]*JobState
+	entries map[string]cron.EntryID
+}
+
+func NewScheduler(runner *Runner, statePath string) *Scheduler {
+	return &Scheduler{
+		Runner:    runner,
+		StatePath: statePath,
+		running:   make(map[string]bool),
+		states:    make(map[string]*JobState),
+		entries:   make(map[string]cron.EntryID),
+	}
+}
+
+// Run schedules every job that has a schedule or interval and blocks until
+// ctx is cancelled, then waits for running jobs to stop and saves state.
+func (s *Scheduler) Run(ctx context.Context, jobs []Job) error {
+	if err := s.loadState(); err != nil {
+		return err
+	}
+
+	concurrency := s.Runner.Concurrency
+	if concurrency > 0 {
+		s.slots = make(chan struct{}, concurrency)
+	}
+
+	logger := s.Runner.Manager.Logger
+	s.cron = cron.New()
+	var scheduled []Job
+	for _, job := range jobs {
+		var schedule cron.Schedule
+		switch {
+		case job.Schedule != "":
+			parsed, err := cron.ParseStandard(job.Schedule)
+			if err != nil {
+				return fmt.Errorf("job %s: invalid schedule %q: %v", job.Name, job.Schedule, err)
+			}
+			schedule = parsed
+		case job.Every > 0:
+			schedule = cron.Every(job.Every)
+		default:
+			logger.LogWarn(fmt.Sprintf("Job %s has no schedule; skipping", job.Name))
+			continue
+		}
+
+		job := job
+		s.entries[job.Name] = s.cron.Schedule(schedule, cron.FuncJob(func() { s.trigger(ctx, job) }))
+		scheduled = append(scheduled, job)
+	}
+	if len(scheduled) == 0 {
+		return fmt.Errorf("no scheduled jobs; set schedule or every on at least one job")
+	}
+
+	s.Runner.Prepare(ctx, scheduled)
+
+	s.cron.Start()
+	s.mu.Lock()
+	for _, job := range scheduled {
+		state := s.state(job.Name)
+		if state.Status == StatusRunning {
+			state.Status = StatusInterrupted
+			logger.LogWarn(fmt.Sprintf("Job %s was interrupted; it resumes on its next run", job.Name))
+		}
+		state.NextRun = s.cron.Entry(s.entries[job.Name]).Next
+		logger.LogInfo(fmt.Sprintf("Scheduled job %s, next run at %s", job.Name, state.NextRun.Format(time.RFC3339)))
+	}
+	s.mu.Unlock()
+	s.saveState()
+
+	<-ctx.Done()
+	logger.LogInfo("Stopping scheduler; waiting for running jobs")
+	<-s.cron.Stop().Done()
+
+	if err := s.Runner.Manager.Recovery.Save(); err != nil {
+		logger.LogError(fmt.Sprintf("Failed to save recovery state: %v", err))
+	}
+	return s.saveState()
+}
+
+func (s *Scheduler) trigger(ctx context.Context, job Job) {
+	logger := s.Runner.Manager.Logger
+	metrics := logger.Metrics()
+
+	s.mu.Lock()
+	if s.running[job.Name] {
+		s.state(job.Name).Skipped++
+		s.mu.Unlock()
+		metrics.JobSkipped(job.Name)
+		logger.Log(types.WARN, types.LogEntry{
+			Message:   fmt.Sprintf("Skipping job %s: previous run still in progress", job.Name),
+			Operation: "job.skip",
+		})
+		return
+	}
+	s.running[job.Name] = true
+	state := s.state(job.Name)
+	state.Status = StatusRunning
+	state.LastStart = time.Now()
+	s.mu.Unlock()
+	s.saveState()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, job.Name)
+		s.mu.Unlock()
+	}()
+
+	if s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+			defer func() { <-s.slots }()
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	metrics.JobStarted(job.Name)
+	result := s.Runner.RunJob(ctx, job)
+	metrics.JobFinished(job.Name, result.Failed())
+
+	entry := types.LogEntry{
+		Message:   fmt.Sprintf("Job %s %s", job.Name, result.Status),
+		Operation: "job",
+		Source:    job.Source,
+		Duration:  result.EndTime.Sub(result.StartTime),
+		Error:     result.Error,
+	}
+	if result.Report != nil {
+		entry.Message += ": " + result.Report.Summary()
+	}
+	level := types.INFO
+	if result.Failed() {
+		level = types.ERROR
+	}
+	logger.Log(level, entry)
+
+	// Persist progress so a crash before the next auto-save does not
+	// repeat the files this run completed.
+	if err := s.Runner.Manager.Recovery.Save(); err != nil {
+		logger.LogError(fmt.Sprintf("Failed to save recovery state: %v", err))
+	}
+
+	s.mu.Lock()
+	state.LastEnd = result.EndTime
+	state.LastError = result.Error
+	state.Runs++
+	switch {
+	case ctx.Err() != nil:
+		state.Status = StatusInterrupted
+	case result.Failed():
+		state.Status = StatusFailed
+		state.Failures++
+	default:
+		state.Status = StatusSucceeded
+		state.LastSuccess = result.EndTime
+	}
+	state.LastSummary = ""
+	if result.Report != nil {
+		state.LastSummary = result.Report.Summary()
+	}
+	if id, ok := s.entries[job.Name]; ok {
+		state.NextRun = s.cron.Entry(id).Next
+	}
+	s.mu.Unlock()
+	s.saveState()
+}
+
+// States returns a copy of the per-job state.
+func (s *Scheduler) States() map[string]JobState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make(map[string]JobState, len(s.states))
+	for name, state := range s.states {
+		states[name] = *state
+	}
+	return states
+}
+
+// state returns the state for a job, creating it if needed. s.mu must be
+// held.
+func (s *Scheduler) state(name string) *JobState {
+	state, ok := s.states[name]
+	if !ok {
+		state = &JobState{}
+		s.states[name] = state
+	}
+	return state
+}
+
+func (s *Scheduler) loadState() error {
+	data, err := os.ReadFile(s.StatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read daemon state: %v", err)
+	}
+	if err := json.Unmarshal(data, &s.states); err != nil {
+		return fmt.Errorf("failed to parse daemon state: %v", err)
+	}
+	return nil
+}
+
+func (s *Scheduler) saveState() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.states, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal daemon state: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.StatePath), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	tempFile := s.StatePath + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write daemon state: %v", err)
+	}
+	return os.Rename(tempFile, s.StatePath)
+}
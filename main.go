@@ -2,91 +2,62 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
+	"datasyncer/config"
+	"datasyncer/events"
+	"datasyncer/providers"
 	"datasyncer/sync"
 	"datasyncer/types"
 )
 
-type CloudProvider string
-
-const (
-	AWS   CloudProvider = "aws"
-	GCP   CloudProvider = "gcp"
-	AZURE CloudProvider = "azure"
-)
-
-type FileInfo struct {
-	Path         string
-	Size         int64
-	LastModified time.Time
-	ETag         string
-}
-
-type SyncOptions struct {
-	SourceProvider      CloudProvider
-	DestinationProvider CloudProvider
-	SourcePath          string
-	DestinationPath     string
-	Parallel            int
-	ConflictResolution  string
-	IncrementalSync     bool
-}
-
-type CloudStorage interface {
-	// Authentication
-	Authenticate(ctx context.Context) error
-
-	// File operations
-	ListFiles(ctx context.Context, path string) ([]FileInfo, error)
-	UploadFile(ctx context.Context, localPath, remotePath string) error
-	DownloadFile(ctx context.Context, remotePath, localPath string) error
-	DeleteFile(ctx context.Context, path string) error
-
-	// Metadata operations
-	GetFileInfo(ctx context.Context, path string) (FileInfo, error)
-}
-
-type SyncManager struct {
-	providers map[CloudProvider]CloudStorage
-	logger    *types.Logger
-	recovery  *sync.RecoveryManager
-	notifier  *Notifier
-}
-
-type Notifier struct {
-	emailConfig EmailConfig
-}
-
-type EmailConfig struct {
-	SMTPServer string
-	Port       int
-	Username   string
-	Password   string
-	FromEmail  string
-}
-
 type contextKey string
 
 const (
 	syncManagerKey contextKey = "syncManager"
+	configKey      contextKey = "config"
 )
 
 func main() {
-	logger, err := types.NewLogger("sync.log", types.INFO)
+	config.Setup()
+	cfg, configErr := config.Load()
+
+	logFile := cfg.Logger.File
+	if logFile == "" {
+		logFile = "sync.log"
+	}
+	level, err := types.ParseLogLevel(cfg.Logger.Level)
+	if err != nil && configErr == nil {
+		configErr = err
+	}
+
+	logger, err := types.NewLogger(logFile, level)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
 		os.Exit(1)
 	}
 	defer logger.Close()
 
-	recovery, err := sync.NewRecoveryManager("sync_state.json", 3)
+	stateFile := cfg.Recovery.StateFile
+	if stateFile == "" {
+		stateFile = "sync_state.json"
+	}
+	maxAttempts := cfg.Recovery.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 3
+	}
+	recovery, err := sync.NewRecoveryManager(stateFile, maxAttempts)
 	if err != nil {
 		logger.LogError(fmt.Sprintf("Failed to initialize recovery manager: %v", err))
 		os.Exit(1)
@@ -95,65 +66,511 @@ func main() {
 	ctx := context.Background()
 	recovery.StartAutoSave(ctx)
 
-	syncManager := &SyncManager{
-		providers: make(map[CloudProvider]CloudStorage),
-		logger:    logger,
-		recovery:  recovery,
-		notifier:  NewNotifier(),
+	syncManager := sync.NewSyncManager(logger, cfg.NewNotifier(), recovery)
+
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		ctx := context.WithValue(cmd.Context(), syncManagerKey, syncManager)
+		cmd.SetContext(context.WithValue(ctx, configKey, cfg))
+
+		// The config commands must keep working on a broken config file so
+		// it can be inspected and fixed.
+		if configErr != nil && !isConfigCommand(cmd) {
+			return configErr
+		}
+
+		metricsAddr, _ := cmd.Flags().GetString("metrics-addr")
+		if metricsAddr != "" {
+			if err := startMetricsServer(metricsAddr, logger); err != nil {
+				return err
+			}
+		}
+
+		traceExporter, _ := cmd.Flags().GetString("trace-exporter")
+		traceEndpoint, _ := cmd.Flags().GetString("trace-endpoint")
+		traceFile, _ := cmd.Flags().GetString("trace-file")
+		shutdown, err := types.SetupTracing(cmd.Context(), types.TracingConfig{
+			Exporter: traceExporter,
+			Endpoint: traceEndpoint,
+			FilePath: traceFile,
+		})
+		if err != nil {
+			return err
+		}
+		shutdownTracing = shutdown
+		return nil
 	}
+	configLoadErr = configErr
+
+	err = rootCmd.Execute()
 
-	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
-		cmd.SetContext(context.WithValue(cmd.Context(), syncManagerKey, syncManager))
+	// Auto-save only runs every 30 seconds; keep the progress of short
+	// runs too.
+	if saveErr := recovery.Save(); saveErr != nil {
+		logger.LogError(fmt.Sprintf("Failed to save recovery state: %v", saveErr))
 	}
 
-	if err := rootCmd.Execute(); err != nil {
+	if shutdownTracing != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.LogError(fmt.Sprintf("Failed to flush traces: %v", err))
+		}
+		cancel()
+	}
+
+	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
-func getSyncManager(cmd *cobra.Command) *SyncManager {
-	return cmd.Context().Value(syncManagerKey).(*SyncManager)
+// shutdownTracing flushes pending spans; it is set once flags are parsed.
+var shutdownTracing func(context.Context) error
+
+// configLoadErr is the error from reading the config file at startup, if any.
+var configLoadErr error
+
+func startMetricsServer(addr string, logger *types.Logger) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on metrics address %s: %v", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", logger.Metrics().Handler())
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logger.LogError(fmt.Sprintf("Metrics server stopped: %v", err))
+		}
+	}()
+
+	logger.LogInfo(fmt.Sprintf("Serving metrics on %s/metrics", listener.Addr()))
+	return nil
+}
+
+func getSyncManager(cmd *cobra.Command) *sync.SyncManager {
+	return cmd.Context().Value(syncManagerKey).(*sync.SyncManager)
+}
+
+func getConfig(cmd *cobra.Command) *config.Config {
+	return cmd.Context().Value(configKey).(*config.Config)
+}
+
+// connectRemote returns the authenticated provider for a configured remote,
+// creating it on first use and registering it with the sync manager under
+// the remote's name.
+func connectRemote(cmd *cobra.Command, name string) (types.CloudStorage, error) {
+	sm := getSyncManager(cmd)
+	key := types.CloudProvider(name)
+	if provider, ok := sm.Providers[key]; ok {
+		return provider, nil
+	}
+
+	provider, err := getConfig(cmd).NewProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Authenticate(cmd.Context()); err != nil {
+		return nil, fmt.Errorf("failed to authenticate remote %s: %v", name, err)
+	}
+
+	sm.Providers[key] = provider
+	return provider, nil
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "datasyncer",
 	Short: "DataSyncer - Multi-cloud storage synchronization tool",
+	// main prints the error once; usage is only useful for flag errors.
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
 
 func init() {
 	rootCmd.AddCommand(authCmd())
 	rootCmd.AddCommand(syncCmd())
 	rootCmd.AddCommand(logCmd())
+	rootCmd.AddCommand(configCmd())
+	rootCmd.AddCommand(runCmd())
+	rootCmd.AddCommand(daemonCmd())
+	rootCmd.AddCommand(lsCmd())
+	rootCmd.AddCommand(cpCmd())
+	rootCmd.AddCommand(mvCmd())
+	rootCmd.AddCommand(rmCmd())
+	rootCmd.AddCommand(catCmd())
+	rootCmd.AddCommand(statCmd())
+	rootCmd.AddCommand(duCmd())
+	rootCmd.AddCommand(inventoryCmd())
+	rootCmd.AddCommand(versionsCmd())
+	rootCmd.AddCommand(restoreCmd())
+	rootCmd.AddCommand(backupCmd())
+	rootCmd.AddCommand(snapshotsCmd())
+	rootCmd.AddCommand(repoCmd())
 
-	viper.SetConfigName("config")
-	viper.AddConfigPath("$HOME/.datasyncer")
-	viper.AutomaticEnv()
+	rootCmd.PersistentFlags().String("metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9090)")
+	rootCmd.PersistentFlags().String("trace-exporter", "", "Export OpenTelemetry traces: otlp or file")
+	rootCmd.PersistentFlags().String("trace-endpoint", "", "OTLP/HTTP collector URL (default from OTEL_EXPORTER_OTLP_ENDPOINT)")
+	rootCmd.PersistentFlags().String("trace-file", "traces.jsonl", "File that receives spans when --trace-exporter=file")
 }
 
 func authCmd() *cobra.Command {
+	var name string
+	var providerConfig types.ProviderConfig
+
 	cmd := &cobra.Command{
 		Use:   "auth [provider]",
 		Short: "Authenticate with a cloud provider",
+		Long: `Validate credentials for a cloud provider and save them as a named profile
+in $HOME/.datasyncer/credentials.json. Values not given as flags are prompted for.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			providerConfig.Type = types.CloudProvider(strings.ToLower(args[0]))
+			if err := promptCredentials(cmd, &providerConfig); err != nil {
+				return err
+			}
+			if name == "" {
+				name = string(providerConfig.Type)
+			}
+
+			provider, err := providers.CreateProvider(providerConfig)
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			if err := provider.Authenticate(ctx); err != nil {
+				return fmt.Errorf("authentication failed: %v", err)
+			}
+			if prober, ok := provider.(types.Prober); ok {
+				if err := prober.Probe(ctx); err != nil {
+					return fmt.Errorf("credentials were accepted but access check failed: %v", err)
+				}
+			}
+
+			store, err := types.DefaultCredentialStore()
+			if err != nil {
+				return err
+			}
+			if err := store.Save(name, providerConfig); err != nil {
+				return err
+			}
+
+			getSyncManager(cmd).Logger.LogInfo(fmt.Sprintf("Saved credential profile %s for %s", name, providerConfig.Type))
+			fmt.Fprintf(cmd.OutOrStdout(), "Authenticated with %s; saved profile %q to %s\n", providerConfig.Type, name, store.Path)
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVar(&name, "name", "", "Name of the credential profile (default: provider name)")
+	cmd.Flags().StringVar(&providerConfig.Bucket, "bucket", "", "Bucket to check access against (AWS, GCP)")
+	cmd.Flags().StringVar(&providerConfig.Profile, "profile", "", "AWS shared config profile")
+	cmd.Flags().StringVar(&providerConfig.CredentialsFile, "key-file", "", "GCP service account key file or AWS shared credentials file")
+	cmd.Flags().StringVar(&providerConfig.QuotaProject, "quota-project", "", "GCP project billed for requests, e.g. to requester-pays buckets")
+	cmd.Flags().StringVar(&providerConfig.AccountName, "account", "", "Azure storage account name")
+	cmd.Flags().StringVar(&providerConfig.AccountKey, "key", "", "Azure storage account key")
+	cmd.Flags().StringVar(&providerConfig.SASToken, "sas-token", "", "Azure SAS token (instead of an account key)")
+	cmd.Flags().StringVar(&providerConfig.ContainerName, "container", "", "Azure container name")
+	cmd.Flags().BoolVar(&providerConfig.ManagedIdentity, "managed-identity", false, "Authenticate to Azure with the managed identity")
+	cmd.Flags().StringVar(&providerConfig.ManagedIdentityClientID, "managed-identity-client-id", "", "Client ID of a user-assigned managed identity")
+	cmd.Flags().StringVar(&providerConfig.Region, "region", "", "AWS region")
+	cmd.Flags().StringVar(&providerConfig.Endpoint, "endpoint", "", "Custom service endpoint URL")
+	cmd.Flags().BoolVar(&providerConfig.PathStyle, "path-style", false, "Use path-style S3 addressing (for S3-compatible endpoints)")
 	return cmd
 }
 
+// promptCredentials asks on stdin for the settings each provider needs that
+// were not supplied as flags.
+func promptCredentials(cmd *cobra.Command, providerConfig *types.ProviderConfig) error {
+	reader := bufio.NewReader(cmd.InOrStdin())
+	ask := func(label string, value *string, required bool) error {
+		if *value != "" {
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: ", label)
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			if required {
+				return fmt.Errorf("%s is required", strings.ToLower(label))
+			}
+			return nil
+		}
+		*value = strings.TrimSpace(line)
+		if required && *value == "" {
+			return fmt.Errorf("%s is required", strings.ToLower(label))
+		}
+		return nil
+	}
+
+	switch providerConfig.Type {
+	case types.AWS:
+		if err := ask("Bucket", &providerConfig.Bucket, true); err != nil {
+			return err
+		}
+		return ask("AWS profile (empty for default chain)", &providerConfig.Profile, false)
+
+	case types.GCP:
+		if err := ask("Bucket", &providerConfig.Bucket, true); err != nil {
+			return err
+		}
+		return ask("Service account key file (empty for Application Default Credentials)", &providerConfig.CredentialsFile, false)
+
+	case types.AZURE:
+		if err := ask("Storage account name", &providerConfig.AccountName, true); err != nil {
+			return err
+		}
+		if err := ask("Container name", &providerConfig.ContainerName, true); err != nil {
+			return err
+		}
+		if providerConfig.SASToken == "" && !providerConfig.ManagedIdentity {
+			if err := ask("Storage account key (empty to use a SAS token)", &providerConfig.AccountKey, false); err != nil {
+				return err
+			}
+			if providerConfig.AccountKey == "" {
+				return ask("SAS token", &providerConfig.SASToken, true)
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported provider type: %s", providerConfig.Type)
+	}
+}
+
 func syncCmd() *cobra.Command {
+	var watchOpts sync.WatchOptions
+	var pollOpts sync.PollWatcher
+
 	cmd := &cobra.Command{
 		Use:   "sync [source] [destination]",
 		Short: "Sync files between cloud providers",
+		Long: `Sync files between two locations, each either a configured remote written as
+remote:path or a local path, for example:
+
+  datasyncer sync prod-s3:exports/ dr-gcs:exports/
+  datasyncer sync --watch ./exports/ prod-s3:exports/
+
+With --watch and a local source, changed files are pushed as filesystem
+events arrive after an initial full sync, with a full sync every
+--rescan-interval to catch missed events. With a cloud source the source is
+listed every --poll-interval and only objects that changed since the last
+listing are copied; the interval backs off to --max-poll-interval while
+nothing changes. Combine with --conflict overwrite so modified files replace
+their previous copies.
+
+With --events-listen or --events-file, objects are synced as S3, GCS Pub/Sub
+or Azure Event Grid notifications name them, without listing the source.
+Notifications posted to --events-listen must carry --events-token.`,
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Sync logic here
+			source, err := config.ParseRemotePath(args[0])
+			if err != nil {
+				return err
+			}
+			destination, err := config.ParseRemotePath(args[1])
+			if err != nil {
+				return err
+			}
+
+			cfg := getConfig(cmd)
+			opts := cfg.SyncOptions(source, destination)
+			applySyncFlags(cmd, &opts)
+			if err := validateTransferFlags(opts); err != nil {
+				return err
+			}
+
+			if _, err := connectRemote(cmd, source.Remote); err != nil {
+				return err
+			}
+			if _, err := connectRemote(cmd, destination.Remote); err != nil {
+				return err
+			}
+
+			eventsListen, _ := cmd.Flags().GetString("events-listen")
+			eventsFile, _ := cmd.Flags().GetString("events-file")
+			if eventsListen != "" || eventsFile != "" {
+				return syncEvents(cmd, source.Remote, opts, eventsListen, eventsFile)
+			}
+
+			if watch, _ := cmd.Flags().GetBool("watch"); watch {
+				sourceConfig, err := cfg.ProviderConfig(source.Remote)
+				if err != nil {
+					return err
+				}
+
+				ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+				defer stop()
+
+				fmt.Fprintf(cmd.OutOrStdout(), "Watching %s; press Ctrl+C to stop\n", source)
+				if sourceConfig.Type == types.LOCAL {
+					watcher := &sync.LocalWatcher{
+						Manager:      getSyncManager(cmd),
+						Options:      opts,
+						Root:         sourceConfig.Path,
+						WatchOptions: watchOpts,
+					}
+					return watcher.Run(ctx)
+				}
+
+				if pollOpts.SnapshotPath == "" {
+					if pollOpts.SnapshotPath, err = sync.SnapshotPath(opts); err != nil {
+						return err
+					}
+				}
+				pollOpts.Manager = getSyncManager(cmd)
+				pollOpts.Options = opts
+				return pollOpts.Run(ctx)
+			}
+
+			report, err := getSyncManager(cmd).Sync(cmd.Context(), opts)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), report.Summary())
+			if report.Failed > 0 {
+				return fmt.Errorf("%d files failed to sync", report.Failed)
+			}
 			return nil
 		},
 	}
+
+	addSyncFlags(cmd)
+	cmd.Flags().Bool("watch", false, "Keep running and sync changes as they happen")
+	cmd.Flags().DurationVar(&watchOpts.Debounce, "debounce", 2*time.Second, "With --watch, wait this long after the last change before syncing")
+	cmd.Flags().DurationVar(&watchOpts.RescanInterval, "rescan-interval", 10*time.Minute, "With --watch, run a full sync this often (negative to disable)")
+	cmd.Flags().DurationVar(&pollOpts.MinInterval, "poll-interval", 30*time.Second, "With --watch on a cloud source, list it this often while it changes")
+	cmd.Flags().DurationVar(&pollOpts.MaxInterval, "max-poll-interval", 10*time.Minute, "With --watch on a cloud source, longest wait between listings")
+	cmd.Flags().StringVar(&pollOpts.SnapshotPath, "snapshot", "", "With --watch on a cloud source, file that keeps the last listing (default under $HOME/.datasyncer/snapshots)")
+	cmd.Flags().String("events-listen", "", "Sync objects named by bucket notifications posted to this address (e.g. :8080)")
+	cmd.Flags().String("events-file", "", "Sync objects named by newline-delimited notifications in this file or pipe (- for stdin)")
+	cmd.Flags().String("events-token", "", "Token notifications must send in the X-Datasyncer-Token header or token query parameter (required with --events-listen)")
 	return cmd
 }
 
+// syncEvents replicates the objects named by bucket notifications, received
+// over HTTP on listen or read line by line from file.
+func syncEvents(cmd *cobra.Command, remote string, opts types.SyncOptions, listen, file string) error {
+	// Anyone who can reach the port could otherwise post events, and
+	// delete destination objects with --mirror.
+	token, _ := cmd.Flags().GetString("events-token")
+	if listen != "" && token == "" {
+		return fmt.Errorf("--events-listen requires --events-token")
+	}
+
+	sourceConfig, err := getConfig(cmd).ProviderConfig(remote)
+	if err != nil {
+		return err
+	}
+	bucket := sourceConfig.Bucket
+	if sourceConfig.Type == types.AZURE {
+		bucket = sourceConfig.ContainerName
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sm := getSyncManager(cmd)
+	queue := make(chan events.Event, 1024)
+	consumer := &events.Consumer{Manager: sm, Options: opts, Bucket: bucket}
+	consumed := make(chan error, 1)
+	go func() { consumed <- consumer.Run(ctx, queue) }()
+
+	if listen != "" {
+		receiver := &events.Receiver{Events: queue, Logger: sm.Logger, Token: token}
+		err = receiver.Serve(ctx, listen)
+		// Handlers may still be sending, so the queue stays open and the
+		// consumer is stopped through the context instead.
+		stop()
+	} else {
+		input := os.Stdin
+		if file != "-" {
+			f, openErr := os.Open(file)
+			if openErr != nil {
+				stop()
+				return fmt.Errorf("failed to open events file: %v", openErr)
+			}
+			defer f.Close()
+			input = f
+		}
+		err = events.ReadLines(ctx, input, queue, sm.Logger)
+		close(queue)
+	}
+
+	if consumeErr := <-consumed; err == nil {
+		err = consumeErr
+	}
+	return err
+}
+
+func addSyncFlags(cmd *cobra.Command) {
+	addTransferFlags(cmd)
+	cmd.Flags().String("conflict", "", "Conflict resolution: overwrite, skip or archive (default from config)")
+	cmd.Flags().Bool("mirror", false, "Delete destination files that do not exist at the source")
+	cmd.Flags().Bool("all-versions", false, "Replay every version of each source file, oldest first, instead of only the current one")
+}
+
+// addTransferFlags adds the flags shared by every command that copies a
+// tree through the sync manager.
+func addTransferFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("parallel", 0, "Number of parallel transfers (default from config)")
+	cmd.Flags().String("report", "", "Write a run report to this JSON path, with .md and .html next to it")
+	cmd.Flags().Bool("restore", false, "Restore archived source files (S3 GLACIER, DEEP_ARCHIVE, Azure Archive) and copy them once readable")
+	cmd.Flags().Int("restore-days", 0, "Days S3 keeps restored copies (default from config)")
+	cmd.Flags().String("restore-priority", "", "Restore priority: bulk, standard or expedited (default from config)")
+	cmd.Flags().Duration("restore-wait", 0, "How long to wait for restores before leaving files for a later run (default from config)")
+	cmd.Flags().String("storage-class", "", "Storage tier (hot, cool, cold, archive) or provider class for every uploaded file, replacing the remote's storage rules")
+}
+
+// applySyncFlags overrides config defaults with flags given on the command
+// line. Flags the command does not define are left alone.
+func applySyncFlags(cmd *cobra.Command, opts *types.SyncOptions) {
+	if cmd.Flags().Changed("parallel") {
+		opts.Parallel, _ = cmd.Flags().GetInt("parallel")
+	}
+	if cmd.Flags().Changed("conflict") {
+		opts.ConflictResolution, _ = cmd.Flags().GetString("conflict")
+	}
+	if cmd.Flags().Changed("report") {
+		opts.ReportPath, _ = cmd.Flags().GetString("report")
+	}
+	if cmd.Flags().Changed("mirror") {
+		opts.Mirror, _ = cmd.Flags().GetBool("mirror")
+	}
+	if cmd.Flags().Changed("restore") {
+		opts.Restore, _ = cmd.Flags().GetBool("restore")
+	}
+	if cmd.Flags().Changed("restore-days") {
+		opts.RestoreOptions.Days, _ = cmd.Flags().GetInt("restore-days")
+	}
+	if cmd.Flags().Changed("restore-priority") {
+		opts.RestoreOptions.Priority, _ = cmd.Flags().GetString("restore-priority")
+	}
+	if cmd.Flags().Changed("restore-wait") {
+		opts.RestoreOptions.Timeout, _ = cmd.Flags().GetDuration("restore-wait")
+	}
+	if cmd.Flags().Changed("all-versions") {
+		opts.AllVersions, _ = cmd.Flags().GetBool("all-versions")
+	}
+	if cmd.Flags().Changed("storage-class") {
+		opts.StorageClass, _ = cmd.Flags().GetString("storage-class")
+		opts.StorageRules = nil
+	}
+	if opts.Parallel < 1 {
+		opts.Parallel = 1
+	}
+}
+
+// validateTransferFlags checks the options transfer flags may have set,
+// which config validation has not seen.
+func validateTransferFlags(opts types.SyncOptions) error {
+	if opts.StorageClass != "" {
+		if err := types.ValidateStorageClass(opts.StorageClass); err != nil {
+			return err
+		}
+	}
+	return opts.RestoreOptions.Validate()
+}
+
 func logCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "log",
@@ -164,16 +581,3 @@ func logCmd() *cobra.Command {
 	}
 	return cmd
 }
-
-func NewNotifier() *Notifier {
-	// For now returning a basic notifier with empty config
-	return &Notifier{
-		emailConfig: EmailConfig{
-			SMTPServer: "",
-			Port:       587,
-			Username:   "",
-			Password:   "",
-			FromEmail:  "",
-		},
-	}
-}
@@ -2,67 +2,180 @@ package providers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"datasyncer/types"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 type AWSS3Provider struct {
 	client *s3.Client
 	bucket string
+	config types.ProviderConfig
 }
 
-func NewAWSS3Provider(bucket string) *AWSS3Provider {
+func NewAWSS3Provider(config types.ProviderConfig) *AWSS3Provider {
 	return &AWSS3Provider{
-		bucket: bucket,
+		bucket: config.Bucket,
+		config: config,
 	}
 }
 
+// s3StorageClass fills in the class S3 omits from HEAD responses for
+// STANDARD objects.
+func s3StorageClass(class string) string {
+	if class == "" {
+		return "STANDARD"
+	}
+	return class
+}
+
+// s3FileInfo converts a listed object. Listings carry the object's upload
+// time but not its mtime metadata.
+func s3FileInfo(obj s3types.Object) types.FileInfo {
+	info := types.FileInfo{
+		Path:         *obj.Key,
+		Size:         *obj.Size,
+		LastModified: *obj.LastModified,
+		ModTime:      *obj.LastModified,
+		ETag:         *obj.ETag,
+		StorageClass: string(obj.StorageClass),
+	}
+	var restoring, restored bool
+	if status := obj.RestoreStatus; status != nil {
+		restoring = aws.ToBool(status.IsRestoreInProgress)
+		restored = !restoring
+	}
+	setS3Archived(&info, restoring, restored)
+	return info
+}
+
+// setS3Archived marks GLACIER and DEEP_ARCHIVE objects that have no
+// restored copy as archived. GLACIER_IR objects are readable directly.
+func setS3Archived(info *types.FileInfo, restoring, restored bool) {
+	switch info.StorageClass {
+	case "GLACIER", "DEEP_ARCHIVE":
+		info.Archived = !restored
+		info.Restoring = restoring
+	}
+}
+
+// optionalString returns nil for "", so unset headers are left out of
+// requests.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
 func (a *AWSS3Provider) Authenticate(ctx context.Context) error {
-	cfg, err := config.LoadDefaultConfig(ctx)
+	var opts []func(*config.LoadOptions) error
+	if a.config.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(a.config.Profile))
+	}
+	if a.config.CredentialsFile != "" {
+		opts = append(opts, config.WithSharedCredentialsFiles([]string{a.config.CredentialsFile}))
+	}
+	if a.config.Region != "" {
+		opts = append(opts, config.WithRegion(a.config.Region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		return fmt.Errorf("unable to load SDK config: %v", err)
 	}
 
-	a.client = s3.NewFromConfig(cfg)
+	a.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if a.config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(a.config.Endpoint)
+		}
+		o.UsePathStyle = a.config.PathStyle
+	})
+	return nil
+}
+
+func (a *AWSS3Provider) Probe(ctx context.Context) error {
+	_, err := a.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  &a.bucket,
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list bucket %s: %v", a.bucket, err)
+	}
 	return nil
 }
 
 func (a *AWSS3Provider) ListFiles(ctx context.Context, path string) ([]types.FileInfo, error) {
-	var files []types.FileInfo
+	return collectFiles(ctx, path, a.ListIter)
+}
 
+func (a *AWSS3Provider) ListIter(ctx context.Context, path string, fn func(types.FileInfo) error) error {
 	input := &s3.ListObjectsV2Input{
-		Bucket: &a.bucket,
-		Prefix: &path,
+		Bucket:                   &a.bucket,
+		Prefix:                   &path,
+		OptionalObjectAttributes: []s3types.OptionalObjectAttributes{s3types.OptionalObjectAttributesRestoreStatus},
 	}
 
 	paginator := s3.NewListObjectsV2Paginator(a.client, input)
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list objects: %v", err)
+			return fmt.Errorf("failed to list objects: %v", err)
 		}
 
 		for _, obj := range page.Contents {
-			files = append(files, types.FileInfo{
-				Path:         *obj.Key,
-				Size:         *obj.Size,
-				LastModified: *obj.LastModified,
-				ETag:         *obj.ETag,
-			})
+			if err := fn(s3FileInfo(obj)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *AWSS3Provider) ListDir(ctx context.Context, prefix, delimiter string, fn func(types.DirEntry) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket:                   &a.bucket,
+		Prefix:                   &prefix,
+		Delimiter:                &delimiter,
+		OptionalObjectAttributes: []s3types.OptionalObjectAttributes{s3types.OptionalObjectAttributesRestoreStatus},
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(a.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %v", err)
+		}
+
+		for _, common := range page.CommonPrefixes {
+			if err := fn(types.DirEntry{FileInfo: types.FileInfo{Path: *common.Prefix}, IsPrefix: true}); err != nil {
+				return err
+			}
+		}
+		for _, obj := range page.Contents {
+			if err := fn(types.DirEntry{FileInfo: s3FileInfo(obj)}); err != nil {
+				return err
+			}
 		}
 	}
 
-	return files, nil
+	return nil
 }
 
-func (a *AWSS3Provider) UploadFile(ctx context.Context, localPath, remotePath string) error {
+func (a *AWSS3Provider) UploadFile(ctx context.Context, localPath, remotePath string, info types.FileInfo) error {
 	file, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
@@ -70,9 +183,15 @@ func (a *AWSS3Provider) UploadFile(ctx context.Context, localPath, remotePath st
 	defer file.Close()
 
 	_, err = a.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: &a.bucket,
-		Key:    &remotePath,
-		Body:   file,
+		Bucket:             &a.bucket,
+		Key:                &remotePath,
+		Body:               file,
+		ContentType:        optionalString(info.ContentType),
+		ContentEncoding:    optionalString(info.ContentEncoding),
+		CacheControl:       optionalString(info.CacheControl),
+		ContentDisposition: optionalString(info.ContentDisposition),
+		Metadata:           uploadMetadata(types.AWS, info, formatModTimeSeconds),
+		StorageClass:       s3types.StorageClass(types.StorageClassFor(types.AWS, a.config.StorageClasses, info.StorageClass)),
 	})
 
 	if err != nil {
@@ -83,13 +202,23 @@ func (a *AWSS3Provider) UploadFile(ctx context.Context, localPath, remotePath st
 }
 
 func (a *AWSS3Provider) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	return a.download(ctx, remotePath, "", localPath)
+}
+
+func (a *AWSS3Provider) DownloadVersion(ctx context.Context, remotePath, versionID, localPath string) error {
+	return a.download(ctx, remotePath, versionID, localPath)
+}
+
+// download saves an object, or one version of it when versionID is set.
+func (a *AWSS3Provider) download(ctx context.Context, remotePath, versionID, localPath string) error {
 	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %v", err)
 	}
 
 	result, err := a.client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: &a.bucket,
-		Key:    &remotePath,
+		Bucket:    &a.bucket,
+		Key:       &remotePath,
+		VersionId: optionalString(versionID),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to download file: %v", err)
@@ -110,21 +239,143 @@ func (a *AWSS3Provider) DownloadFile(ctx context.Context, remotePath, localPath
 	return nil
 }
 
-func (a *AWSS3Provider) GetFileInfo(ctx context.Context, path string) (types.FileInfo, error) {
-	result, err := a.client.HeadObject(ctx, &s3.HeadObjectInput{
+func (a *AWSS3Provider) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	result, err := a.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: &a.bucket,
 		Key:    &path,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	return result.Body, nil
+}
+
+func (a *AWSS3Provider) GetFileInfo(ctx context.Context, path string) (types.FileInfo, error) {
+	return a.head(ctx, path, "")
+}
+
+func (a *AWSS3Provider) GetVersionInfo(ctx context.Context, path, versionID string) (types.FileInfo, error) {
+	return a.head(ctx, path, versionID)
+}
+
+// head describes an object, or one version of it when versionID is set.
+func (a *AWSS3Provider) head(ctx context.Context, path, versionID string) (types.FileInfo, error) {
+	result, err := a.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    &a.bucket,
+		Key:       &path,
+		VersionId: optionalString(versionID),
+	})
 	if err != nil {
 		return types.FileInfo{}, fmt.Errorf("failed to get file info: %v", err)
 	}
 
-	return types.FileInfo{
-		Path:         path,
-		Size:         *result.ContentLength,
-		LastModified: *result.LastModified,
-		ETag:         *result.ETag,
-	}, nil
+	info := types.FileInfo{
+		VersionID:          versionID,
+		Path:               path,
+		Size:               *result.ContentLength,
+		LastModified:       *result.LastModified,
+		ETag:               *result.ETag,
+		StorageClass:       s3StorageClass(string(result.StorageClass)),
+		ContentType:        aws.ToString(result.ContentType),
+		ContentEncoding:    aws.ToString(result.ContentEncoding),
+		CacheControl:       aws.ToString(result.CacheControl),
+		ContentDisposition: aws.ToString(result.ContentDisposition),
+		Metadata:           result.Metadata,
+	}
+	setModTime(&info)
+	// The Restore header is absent until a restore is requested, then reads
+	// ongoing-request="true" until the restored copy is available.
+	if restore := aws.ToString(result.Restore); restore != "" {
+		restoring := strings.Contains(restore, `ongoing-request="true"`)
+		setS3Archived(&info, restoring, !restoring)
+	} else {
+		setS3Archived(&info, false, false)
+	}
+	return info, nil
+}
+
+func (a *AWSS3Provider) ListVersions(ctx context.Context, prefix string, fn func(types.FileVersion) error) error {
+	input := &s3.ListObjectVersionsInput{
+		Bucket: &a.bucket,
+		Prefix: &prefix,
+	}
+
+	paginator := s3.NewListObjectVersionsPaginator(a.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list object versions: %v", err)
+		}
+
+		versions := make([]types.FileVersion, 0, len(page.Versions)+len(page.DeleteMarkers))
+		for _, v := range page.Versions {
+			info := types.FileInfo{
+				Path:         *v.Key,
+				Size:         aws.ToInt64(v.Size),
+				LastModified: *v.LastModified,
+				ModTime:      *v.LastModified,
+				ETag:         aws.ToString(v.ETag),
+				StorageClass: string(v.StorageClass),
+				VersionID:    aws.ToString(v.VersionId),
+			}
+			setS3Archived(&info, false, false)
+			versions = append(versions, types.FileVersion{FileInfo: info, IsLatest: aws.ToBool(v.IsLatest)})
+		}
+		for _, m := range page.DeleteMarkers {
+			versions = append(versions, types.FileVersion{
+				FileInfo: types.FileInfo{
+					Path:         *m.Key,
+					LastModified: *m.LastModified,
+					VersionID:    aws.ToString(m.VersionId),
+				},
+				IsLatest:     aws.ToBool(m.IsLatest),
+				DeleteMarker: true,
+			})
+		}
+		// Versions and delete markers come in separate lists, each sorted
+		// by key and then newest first; merge them in that order.
+		sort.SliceStable(versions, func(i, j int) bool {
+			if versions[i].Path != versions[j].Path {
+				return versions[i].Path < versions[j].Path
+			}
+			return versions[i].LastModified.After(versions[j].LastModified)
+		})
+		for _, v := range versions {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RestoreFile starts a restore of a GLACIER or DEEP_ARCHIVE object,
+// keeping the restored copy for opts.Days.
+func (a *AWSS3Provider) RestoreFile(ctx context.Context, path string, opts types.RestoreOptions) error {
+	tier := s3types.TierStandard
+	switch opts.Priority {
+	case "bulk":
+		tier = s3types.TierBulk
+	case "expedited":
+		tier = s3types.TierExpedited
+	}
+	_, err := a.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: &a.bucket,
+		Key:    &path,
+		RestoreRequest: &s3types.RestoreRequest{
+			Days:                 aws.Int32(int32(max(opts.Days, 1))),
+			GlacierJobParameters: &s3types.GlacierJobParameters{Tier: tier},
+		},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore object: %v", err)
+	}
+	return nil
 }
 
 func (a *AWSS3Provider) DeleteFile(ctx context.Context, path string) error {
@@ -2,11 +2,17 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"datasyncer/types"
 
@@ -16,25 +22,49 @@ import (
 type AzureProvider struct {
 	containerURL azblob.ContainerURL
 	credential   azblob.Credential
+	config       types.ProviderConfig
 }
 
-func NewAzureProvider(accountName, accountKey, containerName string) *AzureProvider {
-	return &AzureProvider{}
+func NewAzureProvider(config types.ProviderConfig) *AzureProvider {
+	return &AzureProvider{
+		config: config,
+	}
 }
 
 func (a *AzureProvider) Authenticate(ctx context.Context) error {
-	credential, err := azblob.NewSharedKeyCredential(
-		os.Getenv("AZURE_STORAGE_ACCOUNT"),
-		os.Getenv("AZURE_STORAGE_ACCESS_KEY"))
-	if err != nil {
-		return fmt.Errorf("failed to create Azure credential: %v", err)
+	var credential azblob.Credential
+	switch {
+	case a.config.SASToken != "":
+		credential = azblob.NewAnonymousCredential()
+
+	case a.config.ManagedIdentity:
+		token, err := newManagedIdentityCredential(ctx, a.config.ManagedIdentityClientID)
+		if err != nil {
+			return fmt.Errorf("failed to get managed identity token: %v", err)
+		}
+		credential = token
+
+	default:
+		sharedKey, err := azblob.NewSharedKeyCredential(a.config.AccountName, a.config.AccountKey)
+		if err != nil {
+			return fmt.Errorf("failed to create Azure credential: %v", err)
+		}
+		credential = sharedKey
 	}
 
 	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
 
-	URL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s",
-		os.Getenv("AZURE_STORAGE_ACCOUNT"),
-		os.Getenv("AZURE_STORAGE_CONTAINER")))
+	endpoint := a.config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", a.config.AccountName)
+	}
+	URL, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + a.config.ContainerName)
+	if err != nil {
+		return fmt.Errorf("invalid Azure container URL: %v", err)
+	}
+	if a.config.SASToken != "" {
+		URL.RawQuery = strings.TrimPrefix(a.config.SASToken, "?")
+	}
 
 	a.containerURL = azblob.NewContainerURL(*URL, pipeline)
 	a.credential = credential
@@ -42,33 +72,136 @@ func (a *AzureProvider) Authenticate(ctx context.Context) error {
 	return nil
 }
 
+func (a *AzureProvider) Probe(ctx context.Context) error {
+	_, err := a.containerURL.ListBlobsFlatSegment(ctx, azblob.Marker{}, azblob.ListBlobsSegmentOptions{
+		MaxResults: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list container: %v", err)
+	}
+	return nil
+}
+
 func (a *AzureProvider) ListFiles(ctx context.Context, path string) ([]types.FileInfo, error) {
-	var files []types.FileInfo
+	return collectFiles(ctx, path, a.ListIter)
+}
+
+func (a *AzureProvider) ListIter(ctx context.Context, path string, fn func(types.FileInfo) error) error {
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlob, err := a.containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{
+			Prefix:  path,
+			Details: azblob.BlobListingDetails{Metadata: true},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list blobs: %v", err)
+		}
+
+		marker = listBlob.NextMarker
+
+		for _, blobInfo := range listBlob.Segment.BlobItems {
+			if err := fn(azureFileInfo(blobInfo)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
 
+func (a *AzureProvider) ListDir(ctx context.Context, prefix, delimiter string, fn func(types.DirEntry) error) error {
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlob, err := a.containerURL.ListBlobsHierarchySegment(ctx, marker, delimiter, azblob.ListBlobsSegmentOptions{
+			Prefix:  prefix,
+			Details: azblob.BlobListingDetails{Metadata: true},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list blobs: %v", err)
+		}
+
+		marker = listBlob.NextMarker
+
+		for _, blobPrefix := range listBlob.Segment.BlobPrefixes {
+			if err := fn(types.DirEntry{FileInfo: types.FileInfo{Path: blobPrefix.Name}, IsPrefix: true}); err != nil {
+				return err
+			}
+		}
+		for _, blobInfo := range listBlob.Segment.BlobItems {
+			if err := fn(types.DirEntry{FileInfo: azureFileInfo(blobInfo)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ListVersions lists every version of the blobs under prefix. Azure has
+// no delete markers: a deleted blob is one with no current version.
+func (a *AzureProvider) ListVersions(ctx context.Context, prefix string, fn func(types.FileVersion) error) error {
 	for marker := (azblob.Marker{}); marker.NotDone(); {
 		listBlob, err := a.containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{
-			Prefix: path,
+			Prefix:  prefix,
+			Details: azblob.BlobListingDetails{Metadata: true, Versions: true},
 		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to list blobs: %v", err)
+			return fmt.Errorf("failed to list blob versions: %v", err)
 		}
 
 		marker = listBlob.NextMarker
 
 		for _, blobInfo := range listBlob.Segment.BlobItems {
-			files = append(files, types.FileInfo{
-				Path:         blobInfo.Name,
-				Size:         *blobInfo.Properties.ContentLength,
-				LastModified: blobInfo.Properties.LastModified,
-				ETag:         string(blobInfo.Properties.Etag),
-			})
+			info := azureFileInfo(blobInfo)
+			if blobInfo.VersionID != nil {
+				info.VersionID = *blobInfo.VersionID
+			}
+			if err := fn(types.FileVersion{
+				FileInfo: info,
+				IsLatest: blobInfo.IsCurrentVersion != nil && *blobInfo.IsCurrentVersion,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func azureFileInfo(blob azblob.BlobItemInternal) types.FileInfo {
+	props := blob.Properties
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
 		}
+		return *s
 	}
+	info := types.FileInfo{
+		Path:               blob.Name,
+		Size:               *props.ContentLength,
+		LastModified:       props.LastModified,
+		ETag:               string(props.Etag),
+		StorageClass:       string(props.AccessTier),
+		ContentType:        deref(props.ContentType),
+		ContentEncoding:    deref(props.ContentEncoding),
+		CacheControl:       deref(props.CacheControl),
+		ContentDisposition: deref(props.ContentDisposition),
+		Metadata:           blob.Metadata,
+	}
+	setModTime(&info)
+	setAzureArchived(&info, string(props.ArchiveStatus))
+	return info
+}
 
-	return files, nil
+// setAzureArchived marks Archive-tier blobs as archived. A blob keeps the
+// Archive tier while it is rehydrated, with an archive status such as
+// rehydrate-pending-to-cool.
+func setAzureArchived(info *types.FileInfo, archiveStatus string) {
+	if info.StorageClass == string(azblob.AccessTierArchive) {
+		info.Archived = true
+		info.Restoring = archiveStatus != ""
+	}
 }
 
-func (a *AzureProvider) UploadFile(ctx context.Context, localPath, remotePath string) error {
+func (a *AzureProvider) UploadFile(ctx context.Context, localPath, remotePath string, info types.FileInfo) error {
 	blobURL := a.containerURL.NewBlockBlobURL(remotePath)
 
 	file, err := os.Open(localPath)
@@ -80,6 +213,14 @@ func (a *AzureProvider) UploadFile(ctx context.Context, localPath, remotePath st
 	_, err = azblob.UploadFileToBlockBlob(ctx, file, blobURL, azblob.UploadToBlockBlobOptions{
 		BlockSize:   4 * 1024 * 1024, // 4MB block size
 		Parallelism: 16,              // 16 parallel operations
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
+			ContentType:        info.ContentType,
+			ContentEncoding:    info.ContentEncoding,
+			CacheControl:       info.CacheControl,
+			ContentDisposition: info.ContentDisposition,
+		},
+		Metadata:       uploadMetadata(types.AZURE, info, formatModTimeRFC3339),
+		BlobAccessTier: azblob.AccessTierType(types.StorageClassFor(types.AZURE, a.config.StorageClasses, info.StorageClass)),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to upload file: %v", err)
@@ -89,8 +230,14 @@ func (a *AzureProvider) UploadFile(ctx context.Context, localPath, remotePath st
 }
 
 func (a *AzureProvider) DownloadFile(ctx context.Context, remotePath, localPath string) error {
-	blobURL := a.containerURL.NewBlockBlobURL(remotePath)
+	return a.download(ctx, a.containerURL.NewBlobURL(remotePath), localPath)
+}
+
+func (a *AzureProvider) DownloadVersion(ctx context.Context, remotePath, versionID, localPath string) error {
+	return a.download(ctx, a.containerURL.NewBlobURL(remotePath).WithVersionID(versionID), localPath)
+}
 
+func (a *AzureProvider) download(ctx context.Context, blobURL azblob.BlobURL, localPath string) error {
 	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %v", err)
 	}
@@ -117,20 +264,69 @@ func (a *AzureProvider) DownloadFile(ctx context.Context, remotePath, localPath
 	return nil
 }
 
-func (a *AzureProvider) GetFileInfo(ctx context.Context, path string) (types.FileInfo, error) {
+func (a *AzureProvider) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
 	blobURL := a.containerURL.NewBlockBlobURL(path)
 
+	response, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob: %v", err)
+	}
+	return response.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3}), nil
+}
+
+func (a *AzureProvider) GetFileInfo(ctx context.Context, path string) (types.FileInfo, error) {
+	return a.properties(ctx, a.containerURL.NewBlobURL(path), path)
+}
+
+func (a *AzureProvider) GetVersionInfo(ctx context.Context, path, versionID string) (types.FileInfo, error) {
+	info, err := a.properties(ctx, a.containerURL.NewBlobURL(path).WithVersionID(versionID), path)
+	if err != nil {
+		return types.FileInfo{}, err
+	}
+	info.VersionID = versionID
+	return info, nil
+}
+
+func (a *AzureProvider) properties(ctx context.Context, blobURL azblob.BlobURL, path string) (types.FileInfo, error) {
 	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
 	if err != nil {
 		return types.FileInfo{}, fmt.Errorf("failed to get blob properties: %v", err)
 	}
 
-	return types.FileInfo{
-		Path:         path,
-		Size:         props.ContentLength(),
-		LastModified: props.LastModified(),
-		ETag:         string(props.ETag()),
-	}, nil
+	info := types.FileInfo{
+		Path:               path,
+		Size:               props.ContentLength(),
+		LastModified:       props.LastModified(),
+		ETag:               string(props.ETag()),
+		StorageClass:       props.AccessTier(),
+		ContentType:        props.ContentType(),
+		ContentEncoding:    props.ContentEncoding(),
+		CacheControl:       props.CacheControl(),
+		ContentDisposition: props.ContentDisposition(),
+		Metadata:           props.NewMetadata(),
+	}
+	setModTime(&info)
+	setAzureArchived(&info, props.ArchiveStatus())
+	return info, nil
+}
+
+// RestoreFile rehydrates an Archive-tier blob to the Cool tier, where it
+// stays once readable.
+func (a *AzureProvider) RestoreFile(ctx context.Context, path string, opts types.RestoreOptions) error {
+	priority := azblob.RehydratePriorityStandard
+	if opts.Priority == "expedited" {
+		priority = azblob.RehydratePriorityHigh
+	}
+	blobURL := a.containerURL.NewBlobURL(path)
+	_, err := blobURL.SetTier(ctx, azblob.AccessTierCool, azblob.LeaseAccessConditions{}, priority)
+	var storageErr azblob.StorageError
+	if errors.As(err, &storageErr) && storageErr.ServiceCode() == azblob.ServiceCodeType(azblob.StorageErrorCodeBlobBeingRehydrated) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rehydrate blob: %v", err)
+	}
+	return nil
 }
 
 func (a *AzureProvider) DeleteFile(ctx context.Context, path string) error {
@@ -143,3 +339,83 @@ func (a *AzureProvider) DeleteFile(ctx context.Context, path string) error {
 
 	return nil
 }
+
+const (
+	imdsTokenURL   = "http://169.254.169.254/metadata/identity/oauth2/token"
+	storageScope   = "https://storage.azure.com/"
+	refreshLeadway = 5 * time.Minute
+)
+
+type imdsToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   string `json:"expires_in"`
+}
+
+// newManagedIdentityCredential fetches a storage token from the Azure
+// Instance Metadata Service and refreshes it shortly before it expires.
+func newManagedIdentityCredential(ctx context.Context, clientID string) (azblob.TokenCredential, error) {
+	token, expiresIn, err := fetchIMDSToken(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return azblob.NewTokenCredential(token, func(credential azblob.TokenCredential) time.Duration {
+		if credential.Token() == token {
+			// First call happens immediately with the token we just fetched.
+			return refreshAfter(expiresIn)
+		}
+		newToken, newExpiresIn, err := fetchIMDSToken(context.Background(), clientID)
+		if err != nil {
+			return 0
+		}
+		credential.SetToken(newToken)
+		return refreshAfter(newExpiresIn)
+	}), nil
+}
+
+func refreshAfter(expiresIn time.Duration) time.Duration {
+	if expiresIn > 2*refreshLeadway {
+		return expiresIn - refreshLeadway
+	}
+	return expiresIn / 2
+}
+
+func fetchIMDSToken(ctx context.Context, clientID string) (string, time.Duration, error) {
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", storageScope)
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsTokenURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("instance metadata service unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", 0, fmt.Errorf("instance metadata service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var token imdsToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", 0, fmt.Errorf("failed to decode token response: %v", err)
+	}
+	seconds, err := strconv.Atoi(token.ExpiresIn)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid token expiry %q: %v", token.ExpiresIn, err)
+	}
+
+	return token.AccessToken, time.Duration(seconds) * time.Second, nil
+}
@@ -1,37 +1,96 @@
 package providers
 
 import (
+	"context"
 	"datasyncer/types"
 	"fmt"
+	"strings"
 )
 
-// type ProviderConfig struct {
-// 	Type          types.CloudProvider
-// 	Bucket        string
-// 	ProjectID     string // For GCP
-// 	AccountName   string // For Azure
-// 	AccountKey    string // For Azure
-// 	ContainerName string // For Azure
-// }
-
 func CreateProvider(config types.ProviderConfig) (types.CloudStorage, error) {
+	provider, err := newProvider(config)
+	if err != nil {
+		return nil, err
+	}
+	return withTracing(provider, config), nil
+}
+
+// ValidateConfig reports the first required field missing for the
+// config's provider type.
+func ValidateConfig(config types.ProviderConfig) error {
 	switch config.Type {
 	case types.AWS:
-		return NewAWSS3Provider(config.Bucket), nil
+		if config.Bucket == "" {
+			return fmt.Errorf("bucket is required for AWS provider")
+		}
 
 	case types.GCP:
-		if config.ProjectID == "" {
-			return nil, fmt.Errorf("project ID is required for GCP provider")
+		if config.Bucket == "" {
+			return fmt.Errorf("bucket is required for GCP provider")
 		}
-		return NewGCPProvider(config.Bucket, config.ProjectID), nil
 
 	case types.AZURE:
-		if config.AccountName == "" || config.AccountKey == "" || config.ContainerName == "" {
-			return nil, fmt.Errorf("account name, account key, and container name are required for Azure provider")
+		if config.AccountName == "" || config.ContainerName == "" {
+			return fmt.Errorf("account name and container name are required for Azure provider")
+		}
+		if config.AccountKey == "" && config.SASToken == "" && !config.ManagedIdentity {
+			return fmt.Errorf("one of account key, SAS token or managed identity is required for Azure provider")
 		}
-		return NewAzureProvider(config.AccountName, config.AccountKey, config.ContainerName), nil
 
+	case types.LOCAL:
+		// An empty path is valid: keys are then filesystem paths.
+
+	default:
+		return fmt.Errorf("unsupported provider type: %s", config.Type)
+	}
+	return nil
+}
+
+// collectFiles gathers a streamed listing into a slice, for callers that
+// need the whole listing at once.
+func collectFiles(ctx context.Context, path string, list func(context.Context, string, func(types.FileInfo) error) error) ([]types.FileInfo, error) {
+	var files []types.FileInfo
+	err := list(ctx, path, func(file types.FileInfo) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// listDirFromIter emulates a delimiter listing on top of a flat one.
+func listDirFromIter(ctx context.Context, prefix, delimiter string, list func(context.Context, string, func(types.FileInfo) error) error, fn func(types.DirEntry) error) error {
+	seen := make(map[string]bool)
+	return list(ctx, prefix, func(file types.FileInfo) error {
+		rest := strings.TrimPrefix(file.Path, prefix)
+		i := strings.Index(rest, delimiter)
+		if i < 0 {
+			return fn(types.DirEntry{FileInfo: file})
+		}
+		common := prefix + rest[:i+len(delimiter)]
+		if seen[common] {
+			return nil
+		}
+		seen[common] = true
+		return fn(types.DirEntry{FileInfo: types.FileInfo{Path: common}, IsPrefix: true})
+	})
+}
+
+func newProvider(config types.ProviderConfig) (types.CloudStorage, error) {
+	if err := ValidateConfig(config); err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case types.AWS:
+		return NewAWSS3Provider(config), nil
+	case types.GCP:
+		return NewGCPProvider(config), nil
+	case types.LOCAL:
+		return NewLocalProvider(config), nil
 	default:
-		return nil, fmt.Errorf("unsupported provider type: %s", config.Type)
+		return NewAzureProvider(config), nil
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"cloud.google.com/go/storage"
 	"google.golang.org/api/iterator"
@@ -14,29 +15,56 @@ import (
 )
 
 type GCPProvider struct {
-	client    *storage.Client
-	bucket    string
-	projectID string
+	client *storage.Client
+	bucket string
+	config types.ProviderConfig
 }
 
-func NewGCPProvider(bucket, projectID string) *GCPProvider {
+func NewGCPProvider(config types.ProviderConfig) *GCPProvider {
 	return &GCPProvider{
-		bucket:    bucket,
-		projectID: projectID,
+		bucket: config.Bucket,
+		config: config,
 	}
 }
 
+// Authenticate uses the configured service account key file, or Application
+// Default Credentials when none is set. A configured quota project is
+// billed for requests, as requester-pays buckets need; it also needs the
+// serviceusage.services.use permission on that project.
 func (g *GCPProvider) Authenticate(ctx context.Context) error {
+	var opts []option.ClientOption
+	if g.config.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(g.config.CredentialsFile))
+	}
+	if g.config.QuotaProject != "" {
+		opts = append(opts, option.WithQuotaProject(g.config.QuotaProject))
+	}
+	if g.config.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(g.config.Endpoint))
+	}
+
 	var err error
-	g.client, err = storage.NewClient(ctx, option.WithCredentialsFile("gcp-credentials.json"))
+	g.client, err = storage.NewClient(ctx, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to create GCP client: %v", err)
 	}
 	return nil
 }
 
+func (g *GCPProvider) Probe(ctx context.Context) error {
+	it := g.client.Bucket(g.bucket).Objects(ctx, nil)
+	it.PageInfo().MaxSize = 1
+	if _, err := it.Next(); err != nil && err != iterator.Done {
+		return fmt.Errorf("failed to list bucket %s: %v", g.bucket, err)
+	}
+	return nil
+}
+
 func (g *GCPProvider) ListFiles(ctx context.Context, path string) ([]types.FileInfo, error) {
-	var files []types.FileInfo
+	return collectFiles(ctx, path, g.ListIter)
+}
+
+func (g *GCPProvider) ListIter(ctx context.Context, path string, fn func(types.FileInfo) error) error {
 	bucket := g.client.Bucket(g.bucket)
 	it := bucket.Objects(ctx, &storage.Query{Prefix: path})
 
@@ -46,21 +74,59 @@ func (g *GCPProvider) ListFiles(ctx context.Context, path string) ([]types.FileI
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("error iterating objects: %v", err)
+			return fmt.Errorf("error iterating objects: %v", err)
 		}
 
-		files = append(files, types.FileInfo{
-			Path:         attrs.Name,
-			Size:         attrs.Size,
-			LastModified: attrs.Updated,
-			ETag:         attrs.Etag,
-		})
+		if err := fn(gcsFileInfo(attrs)); err != nil {
+			return err
+		}
 	}
 
-	return files, nil
+	return nil
 }
 
-func (g *GCPProvider) UploadFile(ctx context.Context, localPath, remotePath string) error {
+func (g *GCPProvider) ListDir(ctx context.Context, prefix, delimiter string, fn func(types.DirEntry) error) error {
+	it := g.client.Bucket(g.bucket).Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: delimiter})
+
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error iterating objects: %v", err)
+		}
+
+		entry := types.DirEntry{FileInfo: gcsFileInfo(attrs)}
+		if attrs.Prefix != "" {
+			entry = types.DirEntry{FileInfo: types.FileInfo{Path: attrs.Prefix}, IsPrefix: true}
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func gcsFileInfo(attrs *storage.ObjectAttrs) types.FileInfo {
+	info := types.FileInfo{
+		Path:               attrs.Name,
+		Size:               attrs.Size,
+		LastModified:       attrs.Updated,
+		ETag:               attrs.Etag,
+		StorageClass:       attrs.StorageClass,
+		ContentType:        attrs.ContentType,
+		ContentEncoding:    attrs.ContentEncoding,
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		Metadata:           attrs.Metadata,
+	}
+	setModTime(&info)
+	return info
+}
+
+func (g *GCPProvider) UploadFile(ctx context.Context, localPath, remotePath string, info types.FileInfo) error {
 	bucket := g.client.Bucket(g.bucket)
 	obj := bucket.Object(remotePath)
 
@@ -71,6 +137,12 @@ func (g *GCPProvider) UploadFile(ctx context.Context, localPath, remotePath stri
 	defer file.Close()
 
 	writer := obj.NewWriter(ctx)
+	writer.ContentType = info.ContentType
+	writer.ContentEncoding = info.ContentEncoding
+	writer.CacheControl = info.CacheControl
+	writer.ContentDisposition = info.ContentDisposition
+	writer.Metadata = uploadMetadata(types.GCP, info, formatModTimeRFC3339)
+	writer.StorageClass = types.StorageClassFor(types.GCP, g.config.StorageClasses, info.StorageClass)
 
 	if _, err := io.Copy(writer, file); err != nil {
 		writer.Close()
@@ -85,9 +157,18 @@ func (g *GCPProvider) UploadFile(ctx context.Context, localPath, remotePath stri
 }
 
 func (g *GCPProvider) DownloadFile(ctx context.Context, remotePath, localPath string) error {
-	bucket := g.client.Bucket(g.bucket)
-	obj := bucket.Object(remotePath)
+	return g.download(ctx, g.client.Bucket(g.bucket).Object(remotePath), localPath)
+}
+
+func (g *GCPProvider) DownloadVersion(ctx context.Context, remotePath, versionID, localPath string) error {
+	obj, err := g.generation(remotePath, versionID)
+	if err != nil {
+		return err
+	}
+	return g.download(ctx, obj, localPath)
+}
 
+func (g *GCPProvider) download(ctx context.Context, obj *storage.ObjectHandle, localPath string) error {
 	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %v", err)
 	}
@@ -111,6 +192,24 @@ func (g *GCPProvider) DownloadFile(ctx context.Context, remotePath, localPath st
 	return nil
 }
 
+// generation returns a handle on one generation of an object; GCS
+// generations serve as version IDs.
+func (g *GCPProvider) generation(path, versionID string) (*storage.ObjectHandle, error) {
+	gen, err := strconv.ParseInt(versionID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GCS generation %q", versionID)
+	}
+	return g.client.Bucket(g.bucket).Object(path).Generation(gen), nil
+}
+
+func (g *GCPProvider) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := g.client.Bucket(g.bucket).Object(path).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %v", err)
+	}
+	return reader, nil
+}
+
 func (g *GCPProvider) GetFileInfo(ctx context.Context, path string) (types.FileInfo, error) {
 	bucket := g.client.Bucket(g.bucket)
 	obj := bucket.Object(path)
@@ -120,12 +219,52 @@ func (g *GCPProvider) GetFileInfo(ctx context.Context, path string) (types.FileI
 		return types.FileInfo{}, fmt.Errorf("failed to get object attributes: %v", err)
 	}
 
-	return types.FileInfo{
-		Path:         attrs.Name,
-		Size:         attrs.Size,
-		LastModified: attrs.Updated,
-		ETag:         attrs.Etag,
-	}, nil
+	return gcsFileInfo(attrs), nil
+}
+
+func (g *GCPProvider) GetVersionInfo(ctx context.Context, path, versionID string) (types.FileInfo, error) {
+	obj, err := g.generation(path, versionID)
+	if err != nil {
+		return types.FileInfo{}, err
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return types.FileInfo{}, fmt.Errorf("failed to get object attributes: %v", err)
+	}
+
+	info := gcsFileInfo(attrs)
+	info.VersionID = versionID
+	return info, nil
+}
+
+// ListVersions lists every generation under prefix. GCS has no delete
+// markers: a generation that is no longer live reports when it was
+// replaced or deleted.
+func (g *GCPProvider) ListVersions(ctx context.Context, prefix string, fn func(types.FileVersion) error) error {
+	it := g.client.Bucket(g.bucket).Objects(ctx, &storage.Query{Prefix: prefix, Versions: true})
+
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error iterating object versions: %v", err)
+		}
+
+		info := gcsFileInfo(attrs)
+		info.LastModified = attrs.Created
+		info.VersionID = strconv.FormatInt(attrs.Generation, 10)
+		if err := fn(types.FileVersion{
+			FileInfo: info,
+			IsLatest: attrs.Deleted.IsZero(),
+			Deleted:  attrs.Deleted,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (g *GCPProvider) DeleteFile(ctx context.Context, path string) error {
@@ -0,0 +1,261 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"datasyncer/types"
+)
+
+// LocalProvider stores files in a directory on the local filesystem. Keys
+// are slash-separated paths relative to Root; with an empty Root they are
+// plain filesystem paths.
+type LocalProvider struct {
+	root   string
+	config types.ProviderConfig
+}
+
+func NewLocalProvider(config types.ProviderConfig) *LocalProvider {
+	return &LocalProvider{
+		root:   config.Path,
+		config: config,
+	}
+}
+
+// LocalPath returns the filesystem path of key under root.
+func LocalPath(root, key string) string {
+	if root == "" {
+		return filepath.FromSlash(key)
+	}
+	return filepath.Join(root, filepath.FromSlash(key))
+}
+
+// LocalKey returns the key of the filesystem path under root.
+func LocalKey(root, path string) (string, error) {
+	if root == "" {
+		return filepath.ToSlash(path), nil
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside %s", path, root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func localETag(info fs.FileInfo) string {
+	return fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+}
+
+// localFileInfo describes a file, taking its content type from the
+// extension so uploads from local paths get one.
+func localFileInfo(key string, info fs.FileInfo) types.FileInfo {
+	return types.FileInfo{
+		Path:         key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		ModTime:      info.ModTime(),
+		ETag:         localETag(info),
+		ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+	}
+}
+
+func (l *LocalProvider) Authenticate(ctx context.Context) error {
+	return nil
+}
+
+func (l *LocalProvider) Probe(ctx context.Context) error {
+	if l.root == "" {
+		return nil
+	}
+	info, err := os.Stat(l.root)
+	if err != nil {
+		return fmt.Errorf("failed to access %s: %v", l.root, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", l.root)
+	}
+	return nil
+}
+
+func (l *LocalProvider) ListFiles(ctx context.Context, prefix string) ([]types.FileInfo, error) {
+	return collectFiles(ctx, prefix, l.ListIter)
+}
+
+func (l *LocalProvider) ListIter(ctx context.Context, prefix string, fn func(types.FileInfo) error) error {
+	// Walk from the deepest directory the prefix names and filter the rest
+	// by key, so "logs/2024" matches both "logs/2024/" and "logs/2024-01.gz".
+	dir := LocalPath(l.root, prefix)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	var fnErr error
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		key, err := LocalKey(l.root, path)
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fnErr = fn(localFileInfo(key, info))
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list files: %v", err)
+	}
+
+	return nil
+}
+
+func (l *LocalProvider) ListDir(ctx context.Context, prefix, delimiter string, fn func(types.DirEntry) error) error {
+	if delimiter != "/" {
+		return listDirFromIter(ctx, prefix, delimiter, l.ListIter, fn)
+	}
+
+	// "a/b/" lists directory a/b; "a/b" lists the entries of a named b*.
+	dirKey, namePrefix := "", prefix
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dirKey, namePrefix = prefix[:i+1], prefix[i+1:]
+	}
+
+	entries, err := os.ReadDir(LocalPath(l.root, dirKey))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to list files: %v", err)
+	}
+
+	for _, d := range entries {
+		if !strings.HasPrefix(d.Name(), namePrefix) {
+			continue
+		}
+		key := dirKey + d.Name()
+		if d.IsDir() {
+			if err := fn(types.DirEntry{FileInfo: types.FileInfo{Path: key + "/"}, IsPrefix: true}); err != nil {
+				return err
+			}
+			continue
+		}
+		if !d.Type().IsRegular() {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		if err := fn(types.DirEntry{FileInfo: localFileInfo(key, info)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UploadFile copies the file's content and restores its modification time;
+// the filesystem has nowhere to keep headers or user metadata.
+func (l *LocalProvider) UploadFile(ctx context.Context, localPath, remotePath string, info types.FileInfo) error {
+	path := LocalPath(l.root, remotePath)
+	if err := copyLocalFile(localPath, path); err != nil {
+		return fmt.Errorf("failed to upload file: %v", err)
+	}
+	if !info.ModTime.IsZero() {
+		if err := os.Chtimes(path, time.Time{}, info.ModTime); err != nil {
+			return fmt.Errorf("failed to set modification time: %v", err)
+		}
+	}
+	return nil
+}
+
+func (l *LocalProvider) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	if err := copyLocalFile(LocalPath(l.root, remotePath), localPath); err != nil {
+		return fmt.Errorf("failed to download file: %v", err)
+	}
+	return nil
+}
+
+func (l *LocalProvider) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	file, err := os.Open(LocalPath(l.root, path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		file.Close()
+		return nil, fmt.Errorf("failed to open file: %s is a directory", path)
+	}
+	return file, nil
+}
+
+func (l *LocalProvider) GetFileInfo(ctx context.Context, path string) (types.FileInfo, error) {
+	info, err := os.Stat(LocalPath(l.root, path))
+	if err != nil {
+		return types.FileInfo{}, fmt.Errorf("failed to get file info: %v", err)
+	}
+	if info.IsDir() {
+		return types.FileInfo{}, fmt.Errorf("failed to get file info: %s is a directory", path)
+	}
+
+	return localFileInfo(path, info), nil
+}
+
+func (l *LocalProvider) DeleteFile(ctx context.Context, path string) error {
+	if err := os.Remove(LocalPath(l.root, path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %v", err)
+	}
+	return nil
+}
+
+// copyLocalFile copies src to dst through a temporary file in dst's
+// directory, so readers never see a partial file.
+func copyLocalFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"datasyncer/types"
+)
+
+// modTimeKey is the user metadata key holding a file's original
+// modification time. rclone uses the same key, so files copied by either
+// tool keep their times when handled by the other.
+const modTimeKey = "mtime"
+
+// setModTime fills info.ModTime from the mtime metadata key, falling back
+// to the provider's own timestamp.
+func setModTime(info *types.FileInfo) {
+	info.ModTime = info.LastModified
+	for key, value := range info.Metadata {
+		if !strings.EqualFold(key, modTimeKey) {
+			continue
+		}
+		if t, ok := parseModTime(value); ok {
+			info.ModTime = t
+		}
+		return
+	}
+}
+
+// parseModTime accepts both forms rclone writes: Unix seconds with a
+// fraction on S3, and RFC 3339 elsewhere.
+func parseModTime(value string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	secs, frac, _ := strings.Cut(value, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(sec, nsec).UTC(), true
+}
+
+func formatModTimeSeconds(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+func formatModTimeRFC3339(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// uploadMetadata returns info's user metadata, normalized for provider,
+// with the modification time stored under the mtime key.
+func uploadMetadata(provider types.CloudProvider, info types.FileInfo, format func(time.Time) string) map[string]string {
+	metadata := make(map[string]string, len(info.Metadata)+1)
+	for key, value := range info.Metadata {
+		if !strings.EqualFold(key, modTimeKey) {
+			metadata[key] = value
+		}
+	}
+	if !info.ModTime.IsZero() {
+		metadata[modTimeKey] = format(info.ModTime)
+	}
+	return types.NormalizeMetadata(provider, metadata)
+}
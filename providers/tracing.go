@@ -0,0 +1,197 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"datasyncer/types"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedStorage wraps a CloudStorage and records a span for every call.
+type tracedStorage struct {
+	next     types.CloudStorage
+	provider types.CloudProvider
+	bucket   string
+}
+
+func withTracing(next types.CloudStorage, config types.ProviderConfig) types.CloudStorage {
+	bucket := config.Bucket
+	switch config.Type {
+	case types.AZURE:
+		bucket = config.ContainerName
+	case types.LOCAL:
+		bucket = config.Path
+	}
+	return &tracedStorage{
+		next:     next,
+		provider: config.Type,
+		bucket:   bucket,
+	}
+}
+
+func (t *tracedStorage) start(ctx context.Context, name, key string) (context.Context, trace.Span) {
+	return types.Tracer().Start(ctx, name, trace.WithAttributes(
+		attribute.String(types.AttrProvider, string(t.provider)),
+		attribute.String(types.AttrBucket, t.bucket),
+		attribute.String(types.AttrKey, key),
+	))
+}
+
+func (t *tracedStorage) Authenticate(ctx context.Context) error {
+	ctx, span := t.start(ctx, "provider.Authenticate", "")
+	err := t.next.Authenticate(ctx)
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) Probe(ctx context.Context) error {
+	prober, ok := t.next.(types.Prober)
+	if !ok {
+		_, err := t.ListFiles(ctx, "")
+		return err
+	}
+	ctx, span := t.start(ctx, "provider.Probe", "")
+	err := prober.Probe(ctx)
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) RestoreFile(ctx context.Context, path string, opts types.RestoreOptions) error {
+	restorer, ok := t.next.(types.Restorer)
+	if !ok {
+		return fmt.Errorf("%s remotes cannot restore archived files", t.provider)
+	}
+	ctx, span := t.start(ctx, "provider.RestoreFile", path)
+	err := restorer.RestoreFile(ctx, path, opts)
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) versioner() (types.Versioner, error) {
+	versioner, ok := t.next.(types.Versioner)
+	if !ok {
+		return nil, fmt.Errorf("%s remotes do not keep file versions", t.provider)
+	}
+	return versioner, nil
+}
+
+func (t *tracedStorage) ListVersions(ctx context.Context, prefix string, fn func(types.FileVersion) error) error {
+	versioner, err := t.versioner()
+	if err != nil {
+		return err
+	}
+	ctx, span := t.start(ctx, "provider.ListVersions", prefix)
+	count := 0
+	err = versioner.ListVersions(ctx, prefix, func(version types.FileVersion) error {
+		count++
+		return fn(version)
+	})
+	span.SetAttributes(attribute.Int("datasyncer.versions", count))
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) GetVersionInfo(ctx context.Context, path, versionID string) (types.FileInfo, error) {
+	versioner, err := t.versioner()
+	if err != nil {
+		return types.FileInfo{}, err
+	}
+	ctx, span := t.start(ctx, "provider.GetVersionInfo", path)
+	info, err := versioner.GetVersionInfo(ctx, path, versionID)
+	types.EndSpan(span, err)
+	return info, err
+}
+
+func (t *tracedStorage) DownloadVersion(ctx context.Context, remotePath, versionID, localPath string) error {
+	versioner, err := t.versioner()
+	if err != nil {
+		return err
+	}
+	ctx, span := t.start(ctx, "provider.DownloadVersion", remotePath)
+	err = versioner.DownloadVersion(ctx, remotePath, versionID, localPath)
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) ListFiles(ctx context.Context, path string) ([]types.FileInfo, error) {
+	ctx, span := t.start(ctx, "provider.ListFiles", path)
+	files, err := t.next.ListFiles(ctx, path)
+	span.SetAttributes(attribute.Int("datasyncer.files", len(files)))
+	types.EndSpan(span, err)
+	return files, err
+}
+
+func (t *tracedStorage) ListIter(ctx context.Context, path string, fn func(types.FileInfo) error) error {
+	ctx, span := t.start(ctx, "provider.ListIter", path)
+	count := 0
+	err := t.next.ListIter(ctx, path, func(file types.FileInfo) error {
+		count++
+		return fn(file)
+	})
+	span.SetAttributes(attribute.Int("datasyncer.files", count))
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) ListDir(ctx context.Context, prefix, delimiter string, fn func(types.DirEntry) error) error {
+	ctx, span := t.start(ctx, "provider.ListDir", prefix)
+	count := 0
+	err := t.next.ListDir(ctx, prefix, delimiter, func(entry types.DirEntry) error {
+		count++
+		return fn(entry)
+	})
+	span.SetAttributes(attribute.Int("datasyncer.entries", count))
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) UploadFile(ctx context.Context, localPath, remotePath string, info types.FileInfo) error {
+	ctx, span := t.start(ctx, "provider.UploadFile", remotePath)
+	if info, err := os.Stat(localPath); err == nil {
+		span.SetAttributes(attribute.Int64(types.AttrSize, info.Size()))
+	}
+	err := t.next.UploadFile(ctx, localPath, remotePath, info)
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	ctx, span := t.start(ctx, "provider.DownloadFile", remotePath)
+	err := t.next.DownloadFile(ctx, remotePath, localPath)
+	if err == nil {
+		if info, statErr := os.Stat(localPath); statErr == nil {
+			span.SetAttributes(attribute.Int64(types.AttrSize, info.Size()))
+		}
+	}
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) DeleteFile(ctx context.Context, path string) error {
+	ctx, span := t.start(ctx, "provider.DeleteFile", path)
+	err := t.next.DeleteFile(ctx, path)
+	types.EndSpan(span, err)
+	return err
+}
+
+func (t *tracedStorage) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	ctx, span := t.start(ctx, "provider.OpenFile", path)
+	reader, err := t.next.OpenFile(ctx, path)
+	types.EndSpan(span, err)
+	return reader, err
+}
+
+func (t *tracedStorage) GetFileInfo(ctx context.Context, path string) (types.FileInfo, error) {
+	ctx, span := t.start(ctx, "provider.GetFileInfo", path)
+	info, err := t.next.GetFileInfo(ctx, path)
+	if err == nil {
+		span.SetAttributes(attribute.Int64(types.AttrSize, info.Size))
+	}
+	types.EndSpan(span, err)
+	return info, err
+}
@@ -0,0 +1,284 @@
+package repo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"datasyncer/types"
+)
+
+// File is one file of a snapshot.
+type File struct {
+	// Path is relative to the backed-up source path.
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	ETag    string    `json:"etag,omitempty"`
+	SHA256  string    `json:"sha256"`
+	// Chunks are the IDs of the file's chunks, in order.
+	Chunks []string `json:"chunks"`
+}
+
+// newFile describes a listed source file, without its content.
+func newFile(info types.FileInfo, sourcePath string) File {
+	rel := strings.TrimPrefix(strings.TrimPrefix(info.Path, sourcePath), "/")
+	if rel == "" {
+		rel = path.Base(info.Path)
+	}
+	modTime := info.ModTime
+	if modTime.IsZero() {
+		modTime = info.LastModified
+	}
+	return File{Path: rel, Size: info.Size, ModTime: modTime, ETag: info.ETag}
+}
+
+// unchanged reports whether f, as listed at the source, has the same
+// content as an earlier backup of it.
+func (f File) unchanged(earlier File) bool {
+	if f.Size != earlier.Size {
+		return false
+	}
+	if f.ETag != "" && earlier.ETag != "" {
+		return f.ETag == earlier.ETag
+	}
+	return !f.ModTime.IsZero() && f.ModTime.Equal(earlier.ModTime)
+}
+
+// Snapshot is a snapshot's index.
+type Snapshot struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Source     string    `json:"source"`
+	TotalFiles int       `json:"total_files"`
+	TotalBytes int64     `json:"total_bytes"`
+	Files      []File    `json:"files"`
+}
+
+// FailedFile is a file that could not be backed up or restored.
+type FailedFile struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// BackupStats describes what a backup stored.
+type BackupStats struct {
+	// Unchanged files were not read again; their chunks come from the
+	// previous snapshot of the same source.
+	Unchanged int
+	// NewChunks and NewBytes count the chunks uploaded; ReadBytes counts
+	// what was read from the source.
+	NewChunks int
+	NewBytes  int64
+	ReadBytes int64
+	Failed    []FailedFile
+}
+
+// Backup stores every file under sourcePath as a new snapshot, reading
+// them on parallel workers. source names the source in the index, and the
+// newest snapshot with the same name is the one unchanged files are taken
+// from. Files that fail are left out of the snapshot and listed in the
+// stats.
+func (r *Repository) Backup(ctx context.Context, storage types.CloudStorage, sourcePath, source string, parallel int) (*Snapshot, *BackupStats, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	snapshot := &Snapshot{ID: newSnapshotID(now), Time: now, Source: source}
+
+	// gc writes its lock before it looks for backup locks, and a backup
+	// looks for gc locks again after writing its own, so one of the two
+	// always sees the other and stops before chunks are both reused and
+	// deleted.
+	if err := r.checkGCLock(ctx); err != nil {
+		return nil, nil, err
+	}
+	unlock, err := r.lock(ctx, snapshot.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+	if err := r.checkGCLock(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	snapshots, err := r.SnapshotIDs(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(snapshots) > 0 && snapshot.ID <= snapshots[len(snapshots)-1] {
+		return nil, nil, fmt.Errorf("snapshot %s already exists", snapshots[len(snapshots)-1])
+	}
+
+	var earlier map[string]File
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		previous, err := r.LoadSnapshot(ctx, snapshots[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if previous.Source == source {
+			earlier = make(map[string]File, len(previous.Files))
+			for _, file := range previous.Files {
+				earlier[file.Path] = file
+			}
+			break
+		}
+	}
+
+	stored := make(map[string]bool)
+	err = r.eachChunk(ctx, func(id string, _ types.FileInfo) error {
+		stored[id] = true
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := &BackupStats{}
+	state := &uploadState{stored: stored, lost: make(map[string]string)}
+	err = forEach(ctx, parallel, func(send func(types.FileInfo) error) error {
+		err := storage.ListIter(ctx, sourcePath, func(info types.FileInfo) error {
+			file := newFile(info, sourcePath)
+			if prior, ok := earlier[file.Path]; ok && file.unchanged(prior) {
+				file.SHA256, file.Chunks = prior.SHA256, prior.Chunks
+				state.mu.Lock()
+				snapshot.Files = append(snapshot.Files, file)
+				stats.Unchanged++
+				state.mu.Unlock()
+				return nil
+			}
+			return send(info)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list source files: %v", err)
+		}
+		return nil
+	}, func(info types.FileInfo) {
+		file, err := r.storeFile(ctx, storage, info, sourcePath, state, stats)
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		if err != nil {
+			stats.Failed = append(stats.Failed, FailedFile{Path: info.Path, Error: err.Error()})
+			return
+		}
+		snapshot.Files = append(snapshot.Files, file)
+	})
+	if err != nil {
+		// Chunks uploaded so far are collected by gc.
+		return nil, stats, err
+	}
+
+	// A file may share a chunk another worker claimed but failed to
+	// upload; such files are not restorable and are left out.
+	files := snapshot.Files
+	snapshot.Files = nil
+	for _, file := range files {
+		if lostErr := state.lostChunk(file); lostErr != "" {
+			stats.Failed = append(stats.Failed, FailedFile{Path: path.Join(sourcePath, file.Path), Error: lostErr})
+			continue
+		}
+		snapshot.Files = append(snapshot.Files, file)
+		snapshot.TotalFiles++
+		snapshot.TotalBytes += file.Size
+	}
+	sort.Slice(snapshot.Files, func(i, j int) bool {
+		return snapshot.Files[i].Path < snapshot.Files[j].Path
+	})
+	if err := r.writeJSON(ctx, r.snapshotKey(snapshot.ID), snapshot); err != nil {
+		return nil, stats, err
+	}
+	return snapshot, stats, nil
+}
+
+// uploadState tracks the repository's chunks across backup workers.
+type uploadState struct {
+	mu gosync.Mutex
+	// stored holds the chunks in the repository or claimed for upload.
+	stored map[string]bool
+	// lost holds the error of chunks whose upload failed.
+	lost map[string]string
+}
+
+func (s *uploadState) lostChunk(file File) string {
+	for _, id := range file.Chunks {
+		if err, ok := s.lost[id]; ok {
+			return fmt.Sprintf("chunk %s failed to upload: %s", id, err)
+		}
+	}
+	return ""
+}
+
+// storeFile downloads a source file, then chunks and hashes it, uploading
+// the chunks the repository does not have yet.
+func (r *Repository) storeFile(ctx context.Context, storage types.CloudStorage, info types.FileInfo, sourcePath string, state *uploadState, stats *BackupStats) (File, error) {
+	temp, err := os.CreateTemp("", "datasyncer-repo-*")
+	if err != nil {
+		return File{}, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+	if err := storage.DownloadFile(ctx, info.Path, temp.Name()); err != nil {
+		return File{}, fmt.Errorf("failed to download %s: %v", info.Path, err)
+	}
+
+	local, err := os.Open(temp.Name())
+	if err != nil {
+		return File{}, fmt.Errorf("failed to open downloaded file: %v", err)
+	}
+	defer local.Close()
+
+	file := newFile(info, sourcePath)
+	file.Size = 0
+	file.Chunks = []string{}
+
+	whole := sha256.New()
+	chunker := NewChunker(io.TeeReader(local, whole), r.Config.Chunker)
+	for {
+		data, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return File{}, fmt.Errorf("failed to read %s: %v", info.Path, err)
+		}
+		id := chunkID(data)
+		file.Chunks = append(file.Chunks, id)
+		file.Size += int64(len(data))
+
+		// Claim the chunk before uploading it, so two workers do not
+		// upload the same one.
+		state.mu.Lock()
+		upload := !state.stored[id]
+		state.stored[id] = true
+		state.mu.Unlock()
+		if !upload {
+			continue
+		}
+		err = r.put(ctx, r.chunkKey(id), data, "application/octet-stream")
+		state.mu.Lock()
+		if err != nil {
+			delete(state.stored, id)
+			state.lost[id] = err.Error()
+		} else {
+			delete(state.lost, id)
+			stats.NewChunks++
+			stats.NewBytes += int64(len(data))
+		}
+		state.mu.Unlock()
+		if err != nil {
+			return File{}, err
+		}
+	}
+	file.SHA256 = hex.EncodeToString(whole.Sum(nil))
+
+	state.mu.Lock()
+	stats.ReadBytes += file.Size
+	state.mu.Unlock()
+	return file, nil
+}
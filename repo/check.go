@@ -0,0 +1,230 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	gosync "sync"
+	"time"
+
+	"datasyncer/backup"
+	"datasyncer/types"
+)
+
+// Problem is a chunk check found missing or corrupt, with a file that
+// needs it.
+type Problem struct {
+	Chunk    string
+	Snapshot string
+	Path     string
+	Error    string
+}
+
+// CheckResult describes the state of a repository.
+type CheckResult struct {
+	Snapshots int
+	Chunks    int
+	Bytes     int64
+	// Unreferenced chunks are left by interrupted backups and removed
+	// snapshots until gc deletes them.
+	Unreferenced      int
+	UnreferencedBytes int64
+	Problems          []Problem
+}
+
+// Check verifies that every chunk a snapshot refers to exists. With
+// readData set each chunk is also read back and checked against its hash,
+// on parallel workers.
+func (r *Repository) Check(ctx context.Context, readData bool, parallel int) (*CheckResult, error) {
+	snapshots, err := r.Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]int64)
+	err = r.eachChunk(ctx, func(id string, info types.FileInfo) error {
+		stored[id] = info.Size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CheckResult{Snapshots: len(snapshots), Chunks: len(stored)}
+	// owner remembers one file per chunk to name in problems.
+	owner := make(map[string]Problem)
+	for _, snapshot := range snapshots {
+		for _, file := range snapshot.Files {
+			for _, id := range file.Chunks {
+				if _, seen := owner[id]; seen {
+					continue
+				}
+				owner[id] = Problem{Chunk: id, Snapshot: snapshot.ID, Path: file.Path}
+				if _, ok := stored[id]; !ok {
+					problem := owner[id]
+					problem.Error = "missing"
+					result.Problems = append(result.Problems, problem)
+				}
+			}
+		}
+	}
+	for id, size := range stored {
+		result.Bytes += size
+		if _, ok := owner[id]; !ok {
+			result.Unreferenced++
+			result.UnreferencedBytes += size
+		}
+	}
+	if !readData {
+		return result, nil
+	}
+
+	var mu gosync.Mutex
+	err = forEach(ctx, parallel, func(send func(Problem) error) error {
+		for id, problem := range owner {
+			if _, ok := stored[id]; ok {
+				if err := send(problem); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}, func(problem Problem) {
+		if _, err := r.readChunk(ctx, problem.Chunk); err != nil {
+			problem.Error = err.Error()
+			mu.Lock()
+			result.Problems = append(result.Problems, problem)
+			mu.Unlock()
+		}
+	})
+	return result, err
+}
+
+// GCResult describes what gc removed, or would remove.
+type GCResult struct {
+	// Decisions are the retention decisions, newest first; empty without
+	// a policy.
+	Decisions []backup.Decision
+	Removed   []string
+	Chunks    int
+	Bytes     int64
+	Failed    []FailedFile
+}
+
+// GC removes the snapshots policy does not keep, when it has rules, then
+// deletes every chunk no remaining snapshot refers to with parallel
+// workers. It holds an exclusive lock while it deletes, which backups
+// wait for, and refuses to run while a backup or another gc holds a lock,
+// since a backup's chunks are not indexed yet, unless force is set. With
+// dryRun nothing is deleted.
+func (r *Repository) GC(ctx context.Context, policy types.RetentionPolicy, dryRun, force bool, parallel int) (*GCResult, error) {
+	if err := r.checkLocks(ctx, "", force); err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		name := gcLockPrefix + newSnapshotID(time.Now().UTC())
+		unlock, err := r.lock(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+		// A backup that wrote its lock before ours may not have seen it.
+		if err := r.checkLocks(ctx, r.key(locksDir+name+".json"), force); err != nil {
+			return nil, err
+		}
+	}
+
+	snapshots, err := r.Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &GCResult{}
+	removed := make(map[string]bool)
+	if !policy.IsZero() {
+		summaries := make([]*backup.Snapshot, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			summaries = append(summaries, &backup.Snapshot{
+				ID:         snapshot.ID,
+				Time:       snapshot.Time,
+				Source:     snapshot.Source,
+				TotalFiles: snapshot.TotalFiles,
+				TotalBytes: snapshot.TotalBytes,
+			})
+		}
+		result.Decisions = backup.Apply(policy, summaries)
+		for _, decision := range result.Decisions {
+			if !decision.Keep {
+				removed[decision.Snapshot.ID] = true
+				result.Removed = append(result.Removed, decision.Snapshot.ID)
+			}
+		}
+	}
+
+	referenced := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		if removed[snapshot.ID] {
+			continue
+		}
+		for _, file := range snapshot.Files {
+			for _, id := range file.Chunks {
+				referenced[id] = true
+			}
+		}
+	}
+
+	// Indexes go first, so an interrupted gc never leaves a snapshot
+	// with missing chunks.
+	if !dryRun {
+		for _, id := range result.Removed {
+			if err := r.Storage.DeleteFile(ctx, r.snapshotKey(id)); err != nil {
+				return result, fmt.Errorf("failed to delete snapshot %s: %v", id, err)
+			}
+		}
+	}
+
+	var mu gosync.Mutex
+	err = forEach(ctx, parallel, func(send func(types.FileInfo) error) error {
+		return r.eachChunk(ctx, func(id string, info types.FileInfo) error {
+			if referenced[id] {
+				return nil
+			}
+			if dryRun {
+				result.Chunks++
+				result.Bytes += info.Size
+				return nil
+			}
+			return send(info)
+		})
+	}, func(info types.FileInfo) {
+		err := r.Storage.DeleteFile(ctx, info.Path)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			result.Failed = append(result.Failed, FailedFile{Path: info.Path, Error: err.Error()})
+			return
+		}
+		result.Chunks++
+		result.Bytes += info.Size
+	})
+	return result, err
+}
+
+// checkLocks returns an error when a lock other than own is held, unless
+// force is set.
+func (r *Repository) checkLocks(ctx context.Context, own string, force bool) error {
+	if force {
+		return nil
+	}
+	locks, err := r.Locks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, lock := range locks {
+		if lock == own {
+			continue
+		}
+		if isGCLock(lock) {
+			return fmt.Errorf("the repository is locked by another gc (%s); wait for it, or use --force if it is not running", lock)
+		}
+		return fmt.Errorf("the repository is locked by a running or interrupted backup (%s); wait for it, or use --force if it is not running", lock)
+	}
+	return nil
+}
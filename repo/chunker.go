@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// ChunkerParams bound the chunk sizes. AvgSize must be a power of two.
+type ChunkerParams struct {
+	MinSize int `json:"min_size"`
+	AvgSize int `json:"avg_size"`
+	MaxSize int `json:"max_size"`
+}
+
+// DefaultChunkerParams give chunks of 1 MiB on average.
+var DefaultChunkerParams = ChunkerParams{
+	MinSize: 256 << 10,
+	AvgSize: 1 << 20,
+	MaxSize: 8 << 20,
+}
+
+// Validate checks that the sizes are ordered and AvgSize is a power of two.
+func (p ChunkerParams) Validate() error {
+	if p.MinSize < 1 || p.MinSize >= p.AvgSize || p.AvgSize >= p.MaxSize {
+		return fmt.Errorf("chunk sizes must satisfy 0 < min < avg < max, got %d, %d, %d", p.MinSize, p.AvgSize, p.MaxSize)
+	}
+	if p.AvgSize&(p.AvgSize-1) != 0 {
+		return fmt.Errorf("average chunk size must be a power of two, got %d", p.AvgSize)
+	}
+	return nil
+}
+
+// gear maps each byte to a random 64-bit value for the rolling hash. It is
+// derived from a fixed seed and must never change: chunk boundaries, and so
+// deduplication against existing repositories, depend on it.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6461746173796e63) // "datasync"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into content-defined chunks with a gear rolling
+// hash, so an insertion only changes the chunks around it and the rest
+// still deduplicate.
+type Chunker struct {
+	r      io.Reader
+	params ChunkerParams
+	mask   uint64
+	buf    []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+// NewChunker reads chunks from r. params must be valid.
+func NewChunker(r io.Reader, params ChunkerParams) *Chunker {
+	// The hash's top bits mix in the most bytes, so boundaries are
+	// checked there.
+	avgBits := bits.TrailingZeros(uint(params.AvgSize))
+	return &Chunker{
+		r:      r,
+		params: params,
+		mask:   ^uint64(0) << (64 - avgBits),
+		buf:    make([]byte, params.MaxSize),
+	}
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	data := c.buf[c.start:c.end]
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	n := c.boundary(data)
+	c.start += n
+	return data[:n], nil
+}
+
+// fill reads until the buffer holds MaxSize bytes or the input ends.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start == len(c.buf) {
+		return nil
+	}
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	n, err := io.ReadFull(c.r, c.buf[c.end:])
+	c.end += n
+	switch err {
+	case nil:
+		return nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		c.eof = true
+		return nil
+	default:
+		return err
+	}
+}
+
+func (c *Chunker) boundary(data []byte) int {
+	if len(data) <= c.params.MinSize {
+		return len(data)
+	}
+	var hash uint64
+	for i := c.params.MinSize; i < len(data); i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,313 @@
+// Package repo implements a deduplicating backup repository on top of any
+// types.CloudStorage. Files are split into content-defined chunks that are
+// stored once under their SHA-256; each snapshot is an index listing the
+// chunks of every file. A repository under root looks like:
+//
+//	<root>/config.json
+//	<root>/chunks/<first two hex digits>/<sha256>
+//	<root>/snapshots/<id>.json
+//	<root>/locks/<id>.json
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"datasyncer/backup"
+	"datasyncer/types"
+)
+
+// FormatVersion is the repository layout this package reads and writes.
+const FormatVersion = 1
+
+const (
+	configName   = "config.json"
+	chunksDir    = "chunks/"
+	snapshotsDir = "snapshots/"
+	locksDir     = "locks/"
+)
+
+// Config is a repository's config.json.
+type Config struct {
+	Version int           `json:"version"`
+	ID      string        `json:"id"`
+	Created time.Time     `json:"created"`
+	Chunker ChunkerParams `json:"chunker"`
+}
+
+// Repository is an initialized repository under Root on Storage.
+type Repository struct {
+	Storage types.CloudStorage
+	Root    string
+	Config  Config
+}
+
+// Init creates a repository under root, failing when one already exists.
+func Init(ctx context.Context, storage types.CloudStorage, root string, params ChunkerParams) (*Repository, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	r := &Repository{Storage: storage, Root: root}
+	if _, err := storage.GetFileInfo(ctx, r.key(configName)); err == nil {
+		return nil, fmt.Errorf("a repository already exists at %s", r.key(configName))
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate repository ID: %v", err)
+	}
+	r.Config = Config{
+		Version: FormatVersion,
+		ID:      hex.EncodeToString(id),
+		Created: time.Now().UTC().Truncate(time.Second),
+		Chunker: params,
+	}
+	if err := r.writeJSON(ctx, r.key(configName), r.Config); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Open reads the repository under root.
+func Open(ctx context.Context, storage types.CloudStorage, root string) (*Repository, error) {
+	r := &Repository{Storage: storage, Root: root}
+	if err := r.readJSON(ctx, r.key(configName), &r.Config); err != nil {
+		return nil, fmt.Errorf("no repository at %s: %v", r.key(configName), err)
+	}
+	if r.Config.Version != FormatVersion {
+		return nil, fmt.Errorf("repository format version %d is not supported", r.Config.Version)
+	}
+	if err := r.Config.Chunker.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid repository config: %v", err)
+	}
+	return r, nil
+}
+
+func (r *Repository) key(name string) string {
+	if root := strings.TrimSuffix(r.Root, "/"); root != "" {
+		return root + "/" + name
+	}
+	return name
+}
+
+func (r *Repository) chunkKey(id string) string {
+	return r.key(chunksDir + id[:2] + "/" + id)
+}
+
+func (r *Repository) snapshotKey(id string) string {
+	return r.key(snapshotsDir + id + ".json")
+}
+
+func chunkID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// eachChunk calls fn with the ID of every stored chunk.
+func (r *Repository) eachChunk(ctx context.Context, fn func(id string, info types.FileInfo) error) error {
+	prefix := r.key(chunksDir)
+	err := r.Storage.ListIter(ctx, prefix, func(file types.FileInfo) error {
+		id := file.Path[strings.LastIndex(file.Path, "/")+1:]
+		if !strings.HasPrefix(file.Path, prefix) || len(id) != sha256.Size*2 {
+			return nil
+		}
+		return fn(id, file)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list chunks: %v", err)
+	}
+	return nil
+}
+
+// SnapshotIDs returns the IDs of the repository's snapshots, oldest first.
+func (r *Repository) SnapshotIDs(ctx context.Context) ([]string, error) {
+	prefix := r.key(snapshotsDir)
+	var ids []string
+	err := r.Storage.ListIter(ctx, prefix, func(file types.FileInfo) error {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(file.Path, prefix), ".json")
+		if ok && !strings.Contains(id, "/") {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Snapshots loads every snapshot index, oldest first.
+func (r *Repository) Snapshots(ctx context.Context) ([]*Snapshot, error) {
+	ids, err := r.SnapshotIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*Snapshot, 0, len(ids))
+	for _, id := range ids {
+		snapshot, err := r.LoadSnapshot(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// LoadSnapshot reads the index of snapshot id; "latest" names the newest.
+func (r *Repository) LoadSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	if id == "latest" {
+		ids, err := r.SnapshotIDs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("the repository has no snapshots")
+		}
+		id = ids[len(ids)-1]
+	}
+	var snapshot Snapshot
+	if err := r.readJSON(ctx, r.snapshotKey(id), &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to load snapshot %s: %v", id, err)
+	}
+	return &snapshot, nil
+}
+
+// gcLockPrefix starts the names of the exclusive locks gc holds while it
+// deletes snapshots and chunks; backup locks are named after their
+// snapshot.
+const gcLockPrefix = "gc-"
+
+// lock records a running backup or gc under name. Backups lock so gc does
+// not collect the chunks they have uploaded but not yet indexed, and gc
+// locks so backups do not reuse the chunks it deletes. The returned
+// function removes the lock.
+func (r *Repository) lock(ctx context.Context, name string) (func(), error) {
+	key := r.key(locksDir + name + ".json")
+	host, _ := os.Hostname()
+	info := map[string]any{"time": time.Now().UTC(), "host": host, "pid": os.Getpid()}
+	if err := r.writeJSON(ctx, key, info); err != nil {
+		return nil, fmt.Errorf("failed to lock repository: %v", err)
+	}
+	return func() { r.Storage.DeleteFile(context.WithoutCancel(ctx), key) }, nil
+}
+
+// Locks returns the keys of the locks of running, or crashed, backups and
+// gc runs.
+func (r *Repository) Locks(ctx context.Context) ([]string, error) {
+	var locks []string
+	err := r.Storage.ListIter(ctx, r.key(locksDir), func(file types.FileInfo) error {
+		locks = append(locks, file.Path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list locks: %v", err)
+	}
+	return locks, nil
+}
+
+// isGCLock reports whether the lock key belongs to gc.
+func isGCLock(key string) bool {
+	return strings.HasPrefix(path.Base(key), gcLockPrefix)
+}
+
+// checkGCLock returns an error when gc holds a lock on the repository.
+func (r *Repository) checkGCLock(ctx context.Context) error {
+	locks, err := r.Locks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, lock := range locks {
+		if isGCLock(lock) {
+			return fmt.Errorf("the repository is locked by gc (%s); wait for it, or delete the lock if gc is not running", lock)
+		}
+	}
+	return nil
+}
+
+func (r *Repository) readJSON(ctx context.Context, key string, v any) error {
+	reader, err := r.Storage.OpenFile(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := json.NewDecoder(reader).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	return nil
+}
+
+func (r *Repository) writeJSON(ctx context.Context, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", key, err)
+	}
+	return r.put(ctx, key, data, "application/json")
+}
+
+// put uploads data to key through a temporary file, since providers
+// upload from disk.
+func (r *Repository) put(ctx context.Context, key string, data []byte, contentType string) error {
+	temp, err := os.CreateTemp("", "datasyncer-repo-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write temporary file: %v", err)
+	}
+	if err := r.Storage.UploadFile(ctx, temp.Name(), key, types.FileInfo{ContentType: contentType}); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", key, err)
+	}
+	return nil
+}
+
+// forEach runs fn on parallel workers for every item feed sends. fn's
+// errors are the caller's to record; forEach returns feed's error.
+func forEach[T any](ctx context.Context, parallel int, feed func(send func(T) error) error, fn func(T)) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+	queue := make(chan T, parallel)
+	var wg gosync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				fn(item)
+			}
+		}()
+	}
+	err := feed(func(item T) error {
+		select {
+		case queue <- item:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(queue)
+	wg.Wait()
+	return err
+}
+
+// newSnapshotID names a snapshot by its time, like backup snapshots.
+func newSnapshotID(now time.Time) string {
+	return now.UTC().Format(backup.IDFormat)
+}
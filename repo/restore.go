@@ -0,0 +1,119 @@
+package repo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	gosync "sync"
+
+	"datasyncer/types"
+)
+
+// RestoreStats describes what a restore wrote.
+type RestoreStats struct {
+	Files  int
+	Bytes  int64
+	Failed []FailedFile
+}
+
+// Restore writes the files of snapshot below path, or all of them when
+// path is empty, under destPath on storage with parallel workers. Every
+// chunk and file is checked against its hash before it is uploaded.
+func (r *Repository) Restore(ctx context.Context, snapshot *Snapshot, path string, storage types.CloudStorage, destPath string, parallel int) (*RestoreStats, error) {
+	stats := &RestoreStats{}
+	var mu gosync.Mutex
+	err := forEach(ctx, parallel, func(send func(File) error) error {
+		for _, file := range snapshot.Files {
+			if underPath(file.Path, path) {
+				if err := send(file); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}, func(file File) {
+		key := file.Path
+		if dest := strings.TrimSuffix(destPath, "/"); dest != "" {
+			key = dest + "/" + file.Path
+		}
+		err := r.restoreFile(ctx, file, storage, key)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			stats.Failed = append(stats.Failed, FailedFile{Path: file.Path, Error: err.Error()})
+			return
+		}
+		stats.Files++
+		stats.Bytes += file.Size
+	})
+	return stats, err
+}
+
+func (r *Repository) restoreFile(ctx context.Context, file File, storage types.CloudStorage, key string) error {
+	temp, err := os.CreateTemp("", "datasyncer-repo-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	whole := sha256.New()
+	err = r.assemble(ctx, file, io.MultiWriter(temp, whole))
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(whole.Sum(nil)); sum != file.SHA256 {
+		return fmt.Errorf("%s does not match its recorded hash", file.Path)
+	}
+
+	info := types.FileInfo{Path: file.Path, Size: file.Size, ModTime: file.ModTime}
+	if err := storage.UploadFile(ctx, temp.Name(), key, info); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", key, err)
+	}
+	return nil
+}
+
+// assemble writes the file's chunks to w in order.
+func (r *Repository) assemble(ctx context.Context, file File, w io.Writer) error {
+	for _, id := range file.Chunks {
+		data, err := r.readChunk(ctx, id)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %v", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// readChunk reads a chunk and checks it against its ID.
+func (r *Repository) readChunk(ctx context.Context, id string) ([]byte, error) {
+	reader, err := r.Storage.OpenFile(ctx, r.chunkKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chunk %s: %v", id, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %v", id, err)
+	}
+	if chunkID(data) != id {
+		return nil, fmt.Errorf("chunk %s is corrupt", id)
+	}
+	return data, nil
+}
+
+// underPath reports whether rel is path itself or below it as a
+// directory.
+func underPath(rel, path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	return path == "" || rel == path || strings.HasPrefix(rel, path+"/")
+}
@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"datasyncer/backup"
+	"datasyncer/types"
+)
+
+// Backup takes a snapshot of opts.SourcePath under the backup root
+// opts.DestinationPath. Files unchanged since the latest snapshot are not
+// copied again; the new manifest refers to the snapshot that holds them.
+// Files that fail, or are still waiting for a restore, are left out of the
+// snapshot. Conflict resolution, mirroring and version replay do not apply
+// to backups.
+func (sm *SyncManager) Backup(ctx context.Context, opts types.SyncOptions) (snapshot *backup.Snapshot, report *RunReport, err error) {
+	ctx, span := types.Tracer().Start(ctx, "Backup", trace.WithAttributes(
+		attribute.String("datasyncer.source.provider", string(opts.SourceProvider)),
+		attribute.String("datasyncer.source.path", opts.SourcePath),
+		attribute.String("datasyncer.destination.provider", string(opts.DestinationProvider)),
+		attribute.String("datasyncer.destination.path", opts.DestinationPath),
+	))
+	defer func() { types.EndSpan(span, err) }()
+
+	sourceProvider, destProvider, err := sm.providers(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root := opts.DestinationPath
+	previous, err := backup.Latest(ctx, destProvider, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot = backup.New(time.Now(), fmt.Sprintf("%s:%s", opts.SourceProvider, opts.SourcePath))
+	var earlier map[string]backup.File
+	if previous != nil {
+		if snapshot.ID <= previous.ID {
+			return nil, nil, fmt.Errorf("snapshot %s already exists under %s", previous.ID, root)
+		}
+		earlier = previous.FileMap()
+	}
+
+	dataOpts := opts
+	dataOpts.DestinationPath = backup.DataPrefix(root, snapshot.ID)
+	dataOpts.ConflictResolution = ""
+	dataOpts.Mirror = false
+	dataOpts.AllVersions = false
+
+	report = newRunReport(opts)
+	err = sm.execute(ctx, dataOpts, report, sourceProvider, destProvider, func(send func(SyncJob) error) error {
+		err := sourceProvider.ListIter(ctx, opts.SourcePath, func(file types.FileInfo) error {
+			rel := relativeKey(file.Path, opts.SourcePath)
+			if !matchesFilters(rel, opts) {
+				return nil
+			}
+			if rel == "" {
+				rel = path.Base(file.Path)
+			}
+			modTime := file.ModTime
+			if modTime.IsZero() {
+				modTime = file.LastModified
+			}
+			entry := backup.File{Path: rel, Size: file.Size, ModTime: modTime, ETag: file.ETag, Snapshot: snapshot.ID}
+			job := newSyncJob(dataOpts, file)
+			if prior, ok := earlier[rel]; ok && entry.Unchanged(prior) {
+				entry.Snapshot = prior.Snapshot
+				snapshot.Add(entry)
+				report.record(job, OutcomeSkipped, nil)
+				return nil
+			}
+			snapshot.Add(entry)
+			return send(job)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list source files: %v", err)
+		}
+		return nil
+	})
+	if err == nil {
+		err = sm.awaitRestores(ctx, dataOpts, report, sourceProvider, destProvider)
+	}
+	report.TotalFiles = snapshot.TotalFiles
+	if err != nil {
+		// Without a manifest the partial snapshot is not listed, and
+		// pruning removes its data.
+		report.finish()
+		sm.complete(opts, report, true, err)
+		return nil, report, err
+	}
+
+	missing := make(map[string]bool)
+	for _, job := range report.takeWaiting() {
+		missing[relativeKey(job.SourcePath, opts.SourcePath)] = true
+	}
+	for _, failed := range report.FailedKeys {
+		missing[relativeKey(failed.Path, opts.SourcePath)] = true
+	}
+	snapshot.Remove(missing)
+
+	err = backup.Save(ctx, destProvider, root, snapshot)
+	report.finish()
+	span.SetAttributes(
+		attribute.String("datasyncer.snapshot", snapshot.ID),
+		attribute.Int("datasyncer.files", snapshot.TotalFiles),
+		attribute.Int("datasyncer.copied", report.Copied),
+		attribute.Int("datasyncer.failed", report.Failed),
+	)
+	if err != nil {
+		sm.complete(opts, report, true, err)
+		return nil, report, err
+	}
+	sm.Logger.LogInfo(fmt.Sprintf("Saved snapshot %s of %s: %d files, %d new (%s)",
+		snapshot.ID, snapshot.Source, snapshot.TotalFiles, snapshot.NewFiles, types.FormatBytes(snapshot.NewBytes)))
+
+	sm.complete(opts, report, true, nil)
+	return snapshot, report, nil
+}
+
+// Prune removes the snapshots under root that policy does not keep, then
+// deletes the data no kept snapshot refers to with parallel workers,
+// including the data of backups that never finished.
+func (sm *SyncManager) Prune(ctx context.Context, provider types.CloudProvider, root string, policy types.RetentionPolicy, parallel int) (*backup.PrunePlan, *RunReport, error) {
+	storage := sm.Providers[provider]
+	if storage == nil {
+		return nil, nil, fmt.Errorf("provider not configured")
+	}
+
+	plan, err := backup.PlanPrune(ctx, storage, root, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, key := range plan.Manifests {
+		if err := storage.DeleteFile(ctx, key); err != nil {
+			return plan, nil, fmt.Errorf("failed to delete %s: %v", key, err)
+		}
+	}
+	for _, removed := range plan.Removed() {
+		sm.Logger.LogInfo(fmt.Sprintf("Removed snapshot %s under %s", removed.ID, root))
+	}
+
+	report, err := sm.Remove(ctx, provider, parallel, func(send func(key string) error) error {
+		return plan.EachGarbage(ctx, storage, send)
+	})
+	return plan, report, err
+}
@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"datasyncer/types"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Copy copies one file between registered providers through the same
+// download, upload and retry path as Sync. Recovery state and conflict
+// resolution do not apply: an existing destination file is replaced.
+func (sm *SyncManager) Copy(ctx context.Context, source types.CloudProvider, sourceKey string, dest types.CloudProvider, destKey string) (info types.FileInfo, err error) {
+	src, dst, err := sm.providers(types.SyncOptions{SourceProvider: source, DestinationProvider: dest})
+	if err != nil {
+		return types.FileInfo{}, err
+	}
+
+	ctx, span := types.Tracer().Start(ctx, "copy", trace.WithAttributes(
+		attribute.String(types.AttrKey, sourceKey),
+	))
+	defer func() { types.EndSpan(span, err) }()
+
+	info, err = src.GetFileInfo(ctx, sourceKey)
+	if err != nil {
+		return types.FileInfo{}, err
+	}
+	span.SetAttributes(attribute.Int64(types.AttrSize, info.Size))
+	if info.Archived {
+		return types.FileInfo{}, fmt.Errorf("%s is archived and must be restored before it can be read; sync it with --restore", sourceKey)
+	}
+
+	job := SyncJob{
+		SourcePath:          sourceKey,
+		DestinationPath:     destKey,
+		FileInfo:            info,
+		SourceProvider:      source,
+		DestinationProvider: dest,
+	}
+	if err := sm.transferFile(ctx, job, src, dst); err != nil {
+		sm.logCopyFailure(job, err)
+		return types.FileInfo{}, err
+	}
+	return info, nil
+}
+
+// CopyFrom uploads everything read from r to destKey with the headers and
+// metadata set in info. The input is spooled to a temporary file first so
+// failed upload attempts can be retried.
+func (sm *SyncManager) CopyFrom(ctx context.Context, r io.Reader, dest types.CloudProvider, destKey string, info types.FileInfo) (int64, error) {
+	dst := sm.Providers[dest]
+	if dst == nil {
+		return 0, fmt.Errorf("destination provider not configured")
+	}
+
+	metrics := sm.Logger.Metrics()
+	metrics.TransferStarted()
+	defer metrics.TransferFinished()
+
+	temp, err := os.CreateTemp("", "datasyncer-stdin-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	size, err := io.Copy(temp, r)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read input: %v", err)
+	}
+
+	info.Path = "-"
+	info.Size = size
+	info.LastModified = time.Now()
+	job := SyncJob{
+		SourcePath:          "-",
+		DestinationPath:     destKey,
+		FileInfo:            info,
+		DestinationProvider: dest,
+	}
+	if err := sm.uploadFile(ctx, job, dst, temp.Name()); err != nil {
+		sm.logCopyFailure(job, err)
+		return 0, err
+	}
+	return size, nil
+}
+
+// Remove deletes the keys feed sends from provider with parallel workers,
+// the way mirror deletes run, and reports the outcome.
+func (sm *SyncManager) Remove(ctx context.Context, provider types.CloudProvider, parallel int, feed func(send func(key string) error) error) (*RunReport, error) {
+	opts := types.SyncOptions{
+		SourceProvider:      provider,
+		DestinationProvider: provider,
+		Parallel:            parallel,
+	}
+	_, dst, err := sm.providers(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := newRunReport(opts)
+	err = sm.execute(ctx, opts, report, nil, dst, func(send func(SyncJob) error) error {
+		return feed(func(key string) error {
+			return send(SyncJob{
+				SourcePath:          key,
+				DestinationPath:     key,
+				SourceProvider:      provider,
+				DestinationProvider: provider,
+				Delete:              true,
+			})
+		})
+	})
+	report.finish()
+	return report, err
+}
+
+func (sm *SyncManager) logCopyFailure(job SyncJob, err error) {
+	sm.Logger.Log(types.ERROR, types.LogEntry{
+		Message:     fmt.Sprintf("Failed to copy %s to %s: %v", job.SourcePath, job.DestinationPath, err),
+		Operation:   "copy",
+		Provider:    string(job.DestinationProvider),
+		Source:      job.SourcePath,
+		Destination: job.DestinationPath,
+		Error:       err.Error(),
+	})
+}
@@ -0,0 +1,61 @@
+package sync
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"datasyncer/types"
+)
+
+// relativeKey returns key relative to the base prefix.
+func relativeKey(key, base string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(key, base), "/")
+}
+
+// matchesFilters reports whether a key, given relative to the sync root,
+// passes the include and exclude glob patterns in opts. Patterns use
+// path.Match syntax; patterns without a "/" also match the base name, so
+// "*.tmp" excludes temporary files at any depth.
+func matchesFilters(rel string, opts types.SyncOptions) bool {
+	if len(opts.Include) > 0 && !matchesAny(rel, opts.Include) {
+		return false
+	}
+	return !matchesAny(rel, opts.Exclude)
+}
+
+func matchesAny(rel string, patterns []string) bool {
+	base := path.Base(rel)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, base); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// storageClass returns the class of the first storage rule in opts that
+// matches file, or opts.StorageClass. Rule ages are measured from the
+// file's modification time.
+func storageClass(opts types.SyncOptions, file types.FileInfo, now time.Time) string {
+	rel := relativeKey(file.Path, opts.SourcePath)
+	modified := file.ModTime
+	if modified.IsZero() {
+		modified = file.LastModified
+	}
+	for _, rule := range opts.StorageRules {
+		if len(rule.Include) > 0 && !matchesAny(rel, rule.Include) {
+			continue
+		}
+		if rule.OlderThan > 0 && (modified.IsZero() || now.Sub(modified) < rule.OlderThan) {
+			continue
+		}
+		return rule.StorageClass
+	}
+	return opts.StorageClass
+}
@@ -3,20 +3,54 @@ package sync
 import (
 	"context"
 	"datasyncer/types"
+	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SyncJob struct {
-	SourcePath      string
-	DestinationPath string
-	FileInfo        types.FileInfo
+	SourcePath          string
+	DestinationPath     string
+	FileInfo            types.FileInfo
+	SourceProvider      types.CloudProvider
+	DestinationProvider types.CloudProvider
+	// Delete removes DestinationPath instead of copying to it.
+	Delete bool
+	// StorageClass is the tier or class to upload in; empty uses the
+	// destination's default class.
+	StorageClass string
+	// Versions, when set, are the versions of the source file to replay
+	// oldest first instead of copying only the current one.
+	Versions []types.FileInfo
+	// RecordVersion stores the source version in the copy's metadata.
+	RecordVersion bool
+	// Rollback copies the FileInfo version over DestinationPath on the
+	// same remote.
+	Rollback bool
+}
+
+// stateKey identifies the job in the recovery state. It includes the
+// destination so the same source can be synced to several places.
+func (j SyncJob) stateKey() string {
+	key := fmt.Sprintf("%s:%s->%s:%s", j.SourceProvider, j.SourcePath, j.DestinationProvider, j.DestinationPath)
+	if j.Versions != nil {
+		key += "#versions"
+	}
+	return key
 }
 
 type SyncManager struct {
+	// Providers are keyed by the names SyncOptions refers to. A provider
+	// type may be registered more than once under different names, e.g. two
+	// Azure accounts or two AWS regions.
 	Providers map[types.CloudProvider]types.CloudStorage
 	Logger    *types.Logger
 	Notifier  *types.Notifier
@@ -32,65 +66,495 @@ func NewSyncManager(logger *types.Logger, notifier *types.Notifier, recovery *Re
 	}
 }
 
-func (sm *SyncManager) Sync(ctx context.Context, opts types.SyncOptions) error {
-	sourceProvider := sm.Providers[opts.SourceProvider]
-	destProvider := sm.Providers[opts.DestinationProvider]
+// Sync copies every file under opts.SourcePath to the destination and
+// returns a report of what happened to each file. With opts.Mirror set,
+// destination files with no source counterpart are deleted. When
+// opts.ReportPath is set the report is also written to disk.
+func (sm *SyncManager) Sync(ctx context.Context, opts types.SyncOptions) (*RunReport, error) {
+	return sm.sync(ctx, opts, true)
+}
+
+// sync implements Sync; watchers pass notifyAlways=false so their periodic
+// full syncs only notify on failure.
+func (sm *SyncManager) sync(ctx context.Context, opts types.SyncOptions, notifyAlways bool) (report *RunReport, err error) {
+	ctx, span := types.Tracer().Start(ctx, "Sync", trace.WithAttributes(
+		attribute.String("datasyncer.source.provider", string(opts.SourceProvider)),
+		attribute.String("datasyncer.source.path", opts.SourcePath),
+		attribute.String("datasyncer.destination.provider", string(opts.DestinationProvider)),
+		attribute.String("datasyncer.destination.path", opts.DestinationPath),
+		attribute.Int("datasyncer.parallel", opts.Parallel),
+	))
+	defer func() { types.EndSpan(span, err) }()
+
+	sourceProvider, destProvider, err := sm.providers(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report = newRunReport(opts)
+
+	// Files are queued as listing pages arrive, so transfers start before
+	// the listing finishes and memory does not grow with the bucket.
+	listed := 0
+	listStart := time.Now()
+	err = sm.execute(ctx, opts, report, sourceProvider, destProvider, func(send func(SyncJob) error) error {
+		queue := func(job SyncJob) error {
+			listed++
+			return send(job)
+		}
+		var err error
+		if opts.AllVersions {
+			err = listVersionJobs(ctx, sourceProvider, opts, queue)
+		} else {
+			err = sourceProvider.ListIter(ctx, opts.SourcePath, func(file types.FileInfo) error {
+				if !matchesFilters(relativeKey(file.Path, opts.SourcePath), opts) {
+					return nil
+				}
+				return queue(newSyncJob(opts, file))
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list source files: %v", err)
+		}
+		sm.Logger.Log(types.INFO, types.LogEntry{
+			Message:   fmt.Sprintf("Listed %d files under %s", listed, opts.SourcePath),
+			Operation: "list",
+			Provider:  string(opts.SourceProvider),
+			Source:    opts.SourcePath,
+			Duration:  time.Since(listStart),
+		})
+		return nil
+	})
 
-	if sourceProvider == nil || destProvider == nil {
-		return fmt.Errorf("source or destination provider not configured")
+	// Archived files parked while their restores run are copied before
+	// mirror deletes, like every other copy.
+	if err == nil {
+		err = sm.awaitRestores(ctx, opts, report, sourceProvider, destProvider)
 	}
 
-	files, err := sourceProvider.ListFiles(ctx, opts.SourcePath)
+	// Deletes run once every copy is done, so the destination listing
+	// does not race with files still being written.
+	if err == nil && opts.Mirror {
+		err = sm.execute(ctx, opts, report, sourceProvider, destProvider, func(send func(SyncJob) error) error {
+			return mirrorDeletes(ctx, sourceProvider, destProvider, opts, send)
+		})
+	}
+	report.finish()
+	report.TotalFiles = listed
+	span.SetAttributes(
+		attribute.Int("datasyncer.files", listed),
+		attribute.Int("datasyncer.copied", report.Copied),
+		attribute.Int("datasyncer.failed", report.Failed),
+	)
+	sm.complete(opts, report, notifyAlways, err)
 	if err != nil {
-		return fmt.Errorf("failed to list source files: %v", err)
+		// Files queued before the failure were still synced; the report
+		// says which.
+		return report, err
 	}
+	return report, nil
+}
 
-	jobs := make(chan SyncJob, len(files))
-	var wg sync.WaitGroup
+// Change is a single source object that was created, modified or deleted,
+// as reported by a watcher.
+type Change struct {
+	Path string
+	// Info describes the new object. When its Path is empty SyncChanges
+	// looks the object up on the source.
+	Info    types.FileInfo
+	Deleted bool
+}
+
+// SyncChanges applies a batch of source changes to the destination instead
+// of listing the whole source. Deletions are only propagated with
+// opts.Mirror set, and only for objects the source no longer has. Unlike
+// Sync it only sends a notification when something failed, so continuous
+// watchers do not flood the channels. Archived files are parked in the
+// report, waiting for their restores, but not waited for; callers retry
+// them later, like failed files.
+func (sm *SyncManager) SyncChanges(ctx context.Context, opts types.SyncOptions, changes []Change) (report *RunReport, err error) {
+	ctx, span := types.Tracer().Start(ctx, "SyncChanges", trace.WithAttributes(
+		attribute.String("datasyncer.source.provider", string(opts.SourceProvider)),
+		attribute.String("datasyncer.destination.provider", string(opts.DestinationProvider)),
+		attribute.Int("datasyncer.changes", len(changes)),
+	))
+	defer func() { types.EndSpan(span, err) }()
+
+	sourceProvider, destProvider, err := sm.providers(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report = newRunReport(opts)
+
+	// Jobs are sent as the changes are checked, so lookups overlap with
+	// transfers and a large batch is never held as jobs as well.
+	queued := 0
+	err = sm.execute(ctx, opts, report, sourceProvider, destProvider, func(send func(SyncJob) error) error {
+		queue := func(job SyncJob) error {
+			queued++
+			return send(job)
+		}
+		for _, change := range changes {
+			if !strings.HasPrefix(change.Path, opts.SourcePath) {
+				continue
+			}
+			if !matchesFilters(relativeKey(change.Path, opts.SourcePath), opts) {
+				continue
+			}
+			info := change.Info
+			if change.Deleted {
+				if !opts.Mirror {
+					continue
+				}
+				// Delete notifications also come for overwritten versions and
+				// may arrive after the object was written again, so the source
+				// has the last word: an object that still exists is copied.
+				current, exists, lookupErr := lookupFile(ctx, sourceProvider, change.Path)
+				if lookupErr != nil {
+					report.record(newSyncJob(opts, types.FileInfo{Path: change.Path}), OutcomeFailed, lookupErr)
+					continue
+				}
+				if !exists {
+					job := newSyncJob(opts, types.FileInfo{Path: change.Path})
+					job.Delete = true
+					if err := queue(job); err != nil {
+						return err
+					}
+					continue
+				}
+				info = current
+			}
+
+			if info.Path == "" {
+				var statErr error
+				if info, statErr = sourceProvider.GetFileInfo(ctx, change.Path); statErr != nil {
+					report.record(newSyncJob(opts, types.FileInfo{Path: change.Path}), OutcomeFailed, statErr)
+					continue
+				}
+			}
+			if err := queue(newSyncJob(opts, info)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	report.TotalFiles = queued
+	report.finish()
+	sm.complete(opts, report, false, err)
+	if err != nil {
+		// Changes that were never queued are neither copied nor failed,
+		// so callers must not record the batch as applied.
+		return report, err
+	}
+	return report, nil
+}
+
+// errFound stops a listing once lookupFile has found its key.
+var errFound = errors.New("found")
+
+// lookupFile finds key on storage by listing its directory, which tells a
+// missing object apart from a failed request on every provider.
+func lookupFile(ctx context.Context, storage types.CloudStorage, key string) (types.FileInfo, bool, error) {
+	var found types.FileInfo
+	err := storage.ListDir(ctx, key, "/", func(entry types.DirEntry) error {
+		if !entry.IsPrefix && entry.Path == key {
+			found = entry.FileInfo
+			return errFound
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errFound):
+		return found, true, nil
+	case err != nil:
+		return types.FileInfo{}, false, fmt.Errorf("failed to look up %s: %v", key, err)
+	}
+	return types.FileInfo{}, false, nil
+}
+
+func (sm *SyncManager) providers(opts types.SyncOptions) (source, dest types.CloudStorage, err error) {
+	source = sm.Providers[opts.SourceProvider]
+	dest = sm.Providers[opts.DestinationProvider]
+	if source == nil || dest == nil {
+		return nil, nil, fmt.Errorf("source or destination provider not configured")
+	}
+	return source, dest, nil
+}
+
+// newSyncJob maps a source file to its destination key, keeping its path
+// relative to opts.SourcePath.
+func newSyncJob(opts types.SyncOptions, file types.FileInfo) SyncJob {
+	return SyncJob{
+		SourcePath:          file.Path,
+		DestinationPath:     destinationKey(opts, file.Path),
+		FileInfo:            file,
+		SourceProvider:      opts.SourceProvider,
+		DestinationProvider: opts.DestinationProvider,
+		StorageClass:        storageClass(opts, file, time.Now()),
+	}
+}
+
+func destinationKey(opts types.SyncOptions, sourceKey string) string {
+	rel := relativeKey(sourceKey, opts.SourcePath)
+	if rel == "" {
+		rel = path.Base(sourceKey)
+	}
+	if opts.DestinationPath == "" {
+		return rel
+	}
+	return strings.TrimSuffix(opts.DestinationPath, "/") + "/" + rel
+}
+
+// mirrorDeletes queues delete jobs for destination files under
+// opts.DestinationPath that have no source counterpart. Source and
+// destination are compared one directory at a time, so memory grows with
+// the largest directory rather than with the whole tree.
+func mirrorDeletes(ctx context.Context, source, dest types.CloudStorage, opts types.SyncOptions, send func(SyncJob) error) error {
+	// "logs" names both the files logs* and the directory logs/.
+	prefixes := []string{opts.SourcePath}
+	if opts.SourcePath != "" && !strings.HasSuffix(opts.SourcePath, "/") {
+		prefixes = append(prefixes, opts.SourcePath+"/")
+	}
+	dir := ""
+	if opts.DestinationPath != "" {
+		dir = strings.TrimSuffix(opts.DestinationPath, "/") + "/"
+	}
+	return mirrorDir(ctx, source, dest, opts, prefixes, dir, send)
+}
+
+// mirrorDir deletes the files of destination directory dir that none of
+// the source directories listed by prefixes maps to, then descends into
+// its subdirectories.
+func mirrorDir(ctx context.Context, source, dest types.CloudStorage, opts types.SyncOptions, prefixes []string, dir string, send func(SyncJob) error) error {
+	wanted := make(map[string]bool)
+	subdirs := make(map[string][]string) // destination subdirectory -> source prefixes
+	for _, prefix := range prefixes {
+		err := source.ListDir(ctx, prefix, "/", func(entry types.DirEntry) error {
+			if !entry.IsPrefix {
+				wanted[destinationKey(opts, entry.Path)] = true
+				return nil
+			}
+			// The source root itself is listed on its own.
+			if relativeKey(entry.Path, opts.SourcePath) == "" {
+				return nil
+			}
+			sub := destinationKey(opts, entry.Path)
+			subdirs[sub] = append(subdirs[sub], entry.Path)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list source files: %v", err)
+		}
+	}
+
+	var descend []string
+	err := dest.ListDir(ctx, dir, "/", func(entry types.DirEntry) error {
+		if entry.IsPrefix {
+			descend = append(descend, entry.Path)
+			return nil
+		}
+		if wanted[entry.Path] {
+			return nil
+		}
+		return mirrorDelete(opts, entry.FileInfo, send)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list destination files: %v", err)
+	}
+	wanted = nil
+
+	for _, sub := range descend {
+		if prefixes, ok := subdirs[sub]; ok {
+			if err := mirrorDir(ctx, source, dest, opts, prefixes, sub, send); err != nil {
+				return err
+			}
+			continue
+		}
+		// Nothing on the source maps to this directory, so all of it goes.
+		err := dest.ListIter(ctx, sub, func(file types.FileInfo) error {
+			return mirrorDelete(opts, file, send)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list destination files: %v", err)
+		}
+	}
+	return nil
+}
+
+// mirrorDelete queues the deletion of destination file unless the filters
+// leave it out of the sync.
+func mirrorDelete(opts types.SyncOptions, file types.FileInfo, send func(SyncJob) error) error {
+	if !matchesFilters(relativeKey(file.Path, opts.DestinationPath), opts) {
+		return nil
+	}
+	return send(SyncJob{
+		DestinationPath:     file.Path,
+		FileInfo:            file,
+		SourceProvider:      opts.SourceProvider,
+		DestinationProvider: opts.DestinationProvider,
+		Delete:              true,
+	})
+}
+
+// queueSize bounds how many listed files wait for a worker.
+const queueSize = 1000
+
+// defaultRestorePoll is how often parked files are checked when the
+// options do not say.
+const defaultRestorePoll = 15 * time.Minute
+
+// execute runs the jobs that feed sends on opts.Parallel workers and
+// records each outcome in report. send blocks while the queue is full and fails once
+// ctx is cancelled. execute returns feed's error after the queued jobs are
+// done.
+func (sm *SyncManager) execute(ctx context.Context, opts types.SyncOptions, report *RunReport, source, dest types.CloudStorage, feed func(send func(SyncJob) error) error) error {
+	metrics := sm.Logger.Metrics()
+
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	queue := make(chan SyncJob, queueSize)
+	send := func(job SyncJob) error {
+		metrics.AddQueueDepth(1)
+		select {
+		case queue <- job:
+			return nil
+		case <-ctx.Done():
+			metrics.AddQueueDepth(-1)
+			return ctx.Err()
+		}
+	}
 
-	for i := 0; i < opts.Parallel; i++ {
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for job := range jobs {
-				if err := sm.processFile(ctx, job, sourceProvider, destProvider, opts); err != nil {
-					sm.Logger.LogError(fmt.Sprintf("Failed to sync file %s: %v", job.SourcePath, err))
+			for job := range queue {
+				metrics.AddQueueDepth(-1)
+				var outcome Outcome
+				var err error
+				switch {
+				case job.Delete:
+					outcome, err = sm.deleteFile(ctx, job, dest)
+				case job.Rollback:
+					outcome, err = sm.rollbackFile(ctx, job, dest)
+				default:
+					outcome, err = sm.processFile(ctx, job, source, dest, opts)
+				}
+				if err != nil {
+					sm.Logger.Log(types.ERROR, types.LogEntry{
+						Message:   fmt.Sprintf("Failed to sync file %s: %v", job.SourcePath, err),
+						Operation: "sync",
+						Source:    job.SourcePath,
+						Error:     err.Error(),
+					})
 				}
+				report.record(job, outcome, err)
 			}
 		}()
 	}
 
-	for _, file := range files {
-		destPath := filepath.Join(opts.DestinationPath, filepath.Base(file.Path))
-		jobs <- SyncJob{
-			SourcePath:      file.Path,
-			DestinationPath: destPath,
-			FileInfo:        file,
+	err := feed(send)
+	close(queue)
+	wg.Wait()
+	return err
+}
+
+// complete writes the report and sends the run notification. Unless always
+// is set, successful runs are not notified. runErr is the error that cut
+// the run short, if any; such runs are always notified.
+func (sm *SyncManager) complete(opts types.SyncOptions, report *RunReport, always bool, runErr error) {
+	if opts.ReportPath != "" {
+		if err := report.WriteFiles(opts.ReportPath); err != nil {
+			sm.Logger.LogError(fmt.Sprintf("Failed to write run report: %v", err))
 		}
 	}
 
-	close(jobs)
-	wg.Wait()
+	failed := report.Failed > 0 || runErr != nil
+	if !always && !failed {
+		return
+	}
 
-	sm.Notifier.SendNotification("Sync Completed", fmt.Sprintf("Synchronized %d files", len(files)))
+	title := "Sync Completed"
+	message := report.Summary()
+	switch {
+	case runErr != nil:
+		title = "Sync Failed"
+		message = fmt.Sprintf("%v\n%s", runErr, message)
+	case report.Failed > 0:
+		title = "Sync Completed with Failures"
+	}
+	if err := sm.Notifier.Send(types.Notification{
+		Title:       title,
+		Message:     message,
+		Report:      report,
+		Failed:      failed,
+		FailedFiles: report.Failed,
+		Duration:    report.Duration(),
+		Key:         fmt.Sprintf("%s:%s->%s:%s:%t", opts.SourceProvider, opts.SourcePath, opts.DestinationProvider, opts.DestinationPath, failed),
+	}); err != nil {
+		sm.Logger.LogError(fmt.Sprintf("Failed to send notification: %v", err))
+	}
+}
 
-	return nil
+func (sm *SyncManager) deleteFile(ctx context.Context, job SyncJob, dest types.CloudStorage) (Outcome, error) {
+	start := time.Now()
+	if err := dest.DeleteFile(ctx, job.DestinationPath); err != nil {
+		return OutcomeFailed, fmt.Errorf("failed to delete %s: %v", job.DestinationPath, err)
+	}
+	sm.Logger.Log(types.INFO, types.LogEntry{
+		Message:     fmt.Sprintf("Deleted %s", job.DestinationPath),
+		Operation:   "delete",
+		Provider:    string(job.DestinationProvider),
+		Destination: job.DestinationPath,
+		Duration:    time.Since(start),
+	})
+	return OutcomeDeleted, nil
 }
 
-func (sm *SyncManager) processFile(ctx context.Context, job SyncJob, source, dest types.CloudStorage, opts types.SyncOptions) error {
+func (sm *SyncManager) processFile(ctx context.Context, job SyncJob, source, dest types.CloudStorage, opts types.SyncOptions) (outcome Outcome, err error) {
+	ctx, span := types.Tracer().Start(ctx, "processFile", trace.WithAttributes(
+		attribute.String(types.AttrKey, job.SourcePath),
+		attribute.Int64(types.AttrSize, job.FileInfo.Size),
+	))
+	defer func() { types.EndSpan(span, err) }()
+
 	rm := sm.Recovery
-	fileState, exists := rm.GetFileState(job.SourcePath)
+	fileState, exists := rm.GetFileState(job.stateKey())
 
+	// A completed file is only skipped while the source is unchanged, so
+	// repeated runs of the same sync pick up new versions.
 	if exists && fileState.Status == "completed" {
-		return nil
+		if fileState.ETag == job.FileInfo.ETag && fileState.Size == job.FileInfo.Size {
+			return OutcomeSkipped, nil
+		}
+		fileState.Attempts = 0
 	}
 
 	if exists && fileState.Attempts >= sm.Recovery.maxAttempts {
 		sm.Logger.LogError(fmt.Sprintf("max retry attempts exceeded for file: %s", job.SourcePath))
-		return fmt.Errorf("max retry attempts exceeded for file: %s", job.SourcePath)
+		return OutcomeFailed, fmt.Errorf("max retry attempts exceeded for file: %s", job.SourcePath)
+	}
+
+	var destInfo types.FileInfo
+	destExists := false
+	// Replays keep their own place in the destination's history.
+	if opts.ConflictResolution != "" && job.Versions == nil {
+		var statErr error
+		destInfo, statErr = dest.GetFileInfo(ctx, job.DestinationPath)
+		destExists = statErr == nil
+	}
+	// Archived files are only restored when they would be copied.
+	keep := destExists && (sameContent(job.FileInfo, destInfo) || opts.ConflictResolution == "skip")
+	if job.FileInfo.Archived && !keep {
+		return sm.requestRestore(ctx, job, fileState.Attempts, source, opts)
 	}
 
 	fileState = FileState{
+		Key:          job.stateKey(),
 		Path:         job.SourcePath,
 		Size:         job.FileInfo.Size,
 		LastModified: job.FileInfo.LastModified,
@@ -99,24 +563,154 @@ func (sm *SyncManager) processFile(ctx context.Context, job SyncJob, source, des
 		Attempts:     fileState.Attempts + 1,
 	}
 	rm.UpdateFileState(fileState)
-
-	err := sm.transferFile(ctx, job, source, dest)
+	span.SetAttributes(attribute.Int(types.AttrAttempt, fileState.Attempts))
+
+	outcome = OutcomeCopied
+	switch {
+	case job.Versions != nil:
+		outcome, err = sm.replayVersions(ctx, job, source, dest)
+	case destExists && sameContent(job.FileInfo, destInfo):
+		// An earlier copy of the same version, not a conflict.
+		outcome = OutcomeSkipped
+	case destExists && opts.ConflictResolution == "skip":
+		outcome = OutcomeSkipped
+		err = sm.handleConflict(ctx, job, destInfo, source, dest, opts)
+	case destExists:
+		outcome = OutcomeConflicted
+		err = sm.handleConflict(ctx, job, destInfo, source, dest, opts)
+	default:
+		err = sm.transferFile(ctx, job, source, dest)
+	}
 	if err != nil {
 		sm.Logger.LogError(fmt.Sprintf("Failed to transfer file %s: %v", job.SourcePath, err))
 
 		fileState.Status = "failed"
 		rm.UpdateFileState(fileState)
-		return err
+		return OutcomeFailed, err
 	}
 
 	fileState.Status = "completed"
 	rm.UpdateFileState(fileState)
 
-	sm.Logger.LogInfo(fmt.Sprintf("Transferred %d bytes from %s to %s", job.FileInfo.Size, job.SourcePath, job.DestinationPath))
+	if outcome != OutcomeSkipped {
+		sm.Logger.LogInfo(fmt.Sprintf("Transferred %d bytes from %s to %s", job.FileInfo.Size, job.SourcePath, job.DestinationPath))
+	}
+
+	return outcome, nil
+}
+
+// requestRestore asks the source to restore an archived file, unless a
+// restore is already in progress, and parks the file until it is readable.
+// Waiting does not count as an attempt.
+func (sm *SyncManager) requestRestore(ctx context.Context, job SyncJob, attempts int, source types.CloudStorage, opts types.SyncOptions) (Outcome, error) {
+	if !opts.Restore {
+		return OutcomeFailed, fmt.Errorf("%s is archived and must be restored before it can be read; sync with --restore", job.SourcePath)
+	}
+
+	if !job.FileInfo.Restoring {
+		restorer, ok := source.(types.Restorer)
+		if !ok {
+			return OutcomeFailed, fmt.Errorf("%s is archived and %s cannot restore it", job.SourcePath, job.SourceProvider)
+		}
+		if err := restorer.RestoreFile(ctx, job.SourcePath, opts.RestoreOptions); err != nil {
+			return OutcomeFailed, fmt.Errorf("failed to request restore of %s: %v", job.SourcePath, err)
+		}
+		sm.Logger.Log(types.INFO, types.LogEntry{
+			Message:   fmt.Sprintf("Requested restore of %s from %s", job.SourcePath, job.FileInfo.StorageClass),
+			Operation: "restore",
+			Provider:  string(job.SourceProvider),
+			Source:    job.SourcePath,
+		})
+	}
+
+	sm.Recovery.UpdateFileState(FileState{
+		Key:          job.stateKey(),
+		Path:         job.SourcePath,
+		Size:         job.FileInfo.Size,
+		LastModified: job.FileInfo.LastModified,
+		ETag:         job.FileInfo.ETag,
+		Status:       "waiting_restore",
+		Attempts:     attempts,
+	})
+	return OutcomeWaitingRestore, nil
+}
+
+// awaitRestores polls the files parked in report until their restores
+// complete and copies each as it becomes readable. It returns when none
+// are left, or once opts.RestoreOptions.Timeout has passed, leaving the
+// rest parked in report for a later run.
+func (sm *SyncManager) awaitRestores(ctx context.Context, opts types.SyncOptions, report *RunReport, source, dest types.CloudStorage) error {
+	waiting := report.takeWaiting()
+	if len(waiting) == 0 {
+		return nil
+	}
+
+	interval := opts.RestoreOptions.PollInterval
+	if interval <= 0 {
+		interval = defaultRestorePoll
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var timeout <-chan time.Time
+	if opts.RestoreOptions.Timeout > 0 {
+		timer := time.NewTimer(opts.RestoreOptions.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for len(waiting) > 0 {
+		sm.Logger.LogInfo(fmt.Sprintf("Waiting for %d archived files to be restored", len(waiting)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			sm.Logger.LogInfo(fmt.Sprintf("Stopped waiting for restores after %s; %d files are left for a later run", opts.RestoreOptions.Timeout, len(waiting)))
+			report.requeue(waiting)
+			return nil
+		case <-ticker.C:
+		}
+
+		var ready []SyncJob
+		still := waiting[:0]
+		for _, job := range waiting {
+			info, err := source.GetFileInfo(ctx, job.SourcePath)
+			switch {
+			case err != nil:
+				report.release(1)
+				report.record(job, OutcomeFailed, fmt.Errorf("failed to check restore of %s: %v", job.SourcePath, err))
+			case info.Archived:
+				still = append(still, job)
+			default:
+				job.FileInfo = info
+				ready = append(ready, job)
+			}
+		}
+		waiting = still
 
+		report.release(len(ready))
+		err := sm.execute(ctx, opts, report, source, dest, func(send func(SyncJob) error) error {
+			for _, job := range ready {
+				if err := send(job); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// sameContent reports whether dest is a copy of source by size and
+// modification time, the check rclone makes by default. Copies keep the
+// source's time in their mtime metadata; S3 listings report the upload
+// time instead, so files listed there may be copied again needlessly.
+func sameContent(source, dest types.FileInfo) bool {
+	return source.Size == dest.Size && !source.ModTime.IsZero() && source.ModTime.Equal(dest.ModTime)
+}
+
 func (sm *SyncManager) handleConflict(ctx context.Context, job SyncJob, destInfo types.FileInfo, source, dest types.CloudStorage, opts types.SyncOptions) error {
 	switch opts.ConflictResolution {
 	case "overwrite":
@@ -131,9 +725,11 @@ func (sm *SyncManager) handleConflict(ctx context.Context, job SyncJob, destInfo
 		archivePath := fmt.Sprintf("%s.%s", job.DestinationPath, timestamp)
 
 		archiveJob := SyncJob{
-			SourcePath:      job.DestinationPath,
-			DestinationPath: archivePath,
-			FileInfo:        destInfo,
+			SourcePath:          job.DestinationPath,
+			DestinationPath:     archivePath,
+			FileInfo:            destInfo,
+			SourceProvider:      job.DestinationProvider,
+			DestinationProvider: job.DestinationProvider,
 		}
 
 		if err := sm.transferFile(ctx, archiveJob, dest, dest); err != nil {
@@ -147,21 +743,103 @@ func (sm *SyncManager) handleConflict(ctx context.Context, job SyncJob, destInfo
 }
 
 func (sm *SyncManager) transferFile(ctx context.Context, job SyncJob, source, dest types.CloudStorage) error {
-	tempDir := os.TempDir()
-	tempFile := filepath.Join(tempDir, filepath.Base(job.SourcePath))
+	metrics := sm.Logger.Metrics()
+	metrics.TransferStarted()
+	defer metrics.TransferFinished()
 
-	if err := source.DownloadFile(ctx, job.SourcePath, tempFile); err != nil {
-		return fmt.Errorf("failed to download file: %v", err)
+	// Files with the same base name in different directories may be in
+	// flight at once, so each transfer gets its own temporary file.
+	temp, err := os.CreateTemp("", "datasyncer-*-"+filepath.Base(job.SourcePath))
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
 	}
+	temp.Close()
+	tempFile := temp.Name()
 	defer os.Remove(tempFile)
 
+	var versioner types.Versioner
+	if job.FileInfo.VersionID != "" {
+		var ok bool
+		if versioner, ok = source.(types.Versioner); !ok {
+			return fmt.Errorf("%s does not keep file versions", job.SourceProvider)
+		}
+	}
+
+	start := time.Now()
+	if versioner != nil {
+		err = versioner.DownloadVersion(ctx, job.SourcePath, job.FileInfo.VersionID, tempFile)
+	} else {
+		err = source.DownloadFile(ctx, job.SourcePath, tempFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download file: %v", err)
+	}
+	sm.Logger.Log(types.INFO, types.LogEntry{
+		Message:   fmt.Sprintf("Downloaded %s", job.SourcePath),
+		Operation: "download",
+		Provider:  string(job.SourceProvider),
+		Source:    job.SourcePath,
+		Duration:  time.Since(start),
+	})
+
+	// S3 listings carry no headers or user metadata; fetch them so the copy
+	// keeps them.
+	if !job.FileInfo.HasMetadata() {
+		var info types.FileInfo
+		if versioner != nil {
+			info, err = versioner.GetVersionInfo(ctx, job.SourcePath, job.FileInfo.VersionID)
+		} else {
+			info, err = source.GetFileInfo(ctx, job.SourcePath)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get file metadata: %v", err)
+		}
+		job.FileInfo = info
+	}
+	if job.RecordVersion {
+		metadata := make(map[string]string, len(job.FileInfo.Metadata)+1)
+		for k, v := range job.FileInfo.Metadata {
+			if !strings.EqualFold(k, sourceVersionKey) {
+				metadata[k] = v
+			}
+		}
+		metadata[sourceVersionKey] = job.FileInfo.VersionID
+		job.FileInfo.Metadata = metadata
+	}
+	// The source's class is not carried over, so copies land in the
+	// destination's default class unless one was configured.
+	job.FileInfo.StorageClass = job.StorageClass
+
+	return sm.uploadFile(ctx, job, dest, tempFile)
+}
+
+// uploadFile uploads localPath to the job's destination, retrying failed
+// attempts.
+func (sm *SyncManager) uploadFile(ctx context.Context, job SyncJob, dest types.CloudStorage, localPath string) error {
 	var lastErr error
 	for i := 0; i < 3; i++ {
-		if err := dest.UploadFile(ctx, tempFile, job.DestinationPath); err != nil {
+		attemptCtx, span := types.Tracer().Start(ctx, "upload.attempt", trace.WithAttributes(
+			attribute.String(types.AttrProvider, string(job.DestinationProvider)),
+			attribute.String(types.AttrKey, job.DestinationPath),
+			attribute.Int(types.AttrAttempt, i+1),
+		))
+		start := time.Now()
+		err := dest.UploadFile(attemptCtx, localPath, job.DestinationPath, job.FileInfo)
+		types.EndSpan(span, err)
+		if err != nil {
 			lastErr = err
 			time.Sleep(time.Second * time.Duration(i+1))
 			continue
 		}
+		sm.Logger.Log(types.INFO, types.LogEntry{
+			Message:     fmt.Sprintf("Uploaded %s", job.DestinationPath),
+			Operation:   "upload",
+			Provider:    string(job.DestinationProvider),
+			Source:      job.SourcePath,
+			Destination: job.DestinationPath,
+			BytesCount:  job.FileInfo.Size,
+			Duration:    time.Since(start),
+		})
 		return nil
 	}
 
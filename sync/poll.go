@@ -0,0 +1,205 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"datasyncer/types"
+)
+
+const (
+	defaultMinPollInterval = 30 * time.Second
+	defaultMaxPollInterval = 10 * time.Minute
+)
+
+// Snapshot is the listing of a source as of the last poll.
+type Snapshot struct {
+	Source string                    `json:"source"`
+	Taken  time.Time                 `json:"taken"`
+	Files  map[string]types.FileInfo `json:"files"`
+}
+
+// SnapshotPath returns the default snapshot file for a sync route, under
+// $HOME/.datasyncer/snapshots.
+func SnapshotPath(opts types.SyncOptions) (string, error) {
+	dir, err := types.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	route := fmt.Sprintf("%s:%s->%s:%s", opts.SourceProvider, opts.SourcePath, opts.DestinationProvider, opts.DestinationPath)
+	sum := sha256.Sum256([]byte(route))
+	return filepath.Join(dir, "snapshots", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// PollWatcher replicates a cloud source by listing it periodically and
+// syncing only the objects that differ from the previous listing. The
+// listing is kept on disk, so a restarted watcher only syncs what changed
+// while it was down. The interval drops to MinInterval whenever changes
+// are found and doubles up to MaxInterval while the source is idle.
+type PollWatcher struct {
+	Manager      *SyncManager
+	Options      types.SyncOptions
+	SnapshotPath string
+	MinInterval  time.Duration
+	MaxInterval  time.Duration
+}
+
+func (w *PollWatcher) Run(ctx context.Context) error {
+	minInterval, maxInterval := w.MinInterval, w.MaxInterval
+	if minInterval <= 0 {
+		minInterval = defaultMinPollInterval
+	}
+	if maxInterval < minInterval {
+		maxInterval = max(defaultMaxPollInterval, minInterval)
+	}
+
+	snapshot, err := w.loadSnapshot()
+	if err != nil {
+		return err
+	}
+
+	interval := minInterval
+	for {
+		changed, err := w.poll(ctx, snapshot)
+		switch {
+		case err != nil:
+			w.Manager.Logger.LogError(fmt.Sprintf("Poll failed: %v", err))
+		case changed > 0:
+			interval = minInterval
+		default:
+			interval = min(interval*2, maxInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// poll lists the source, syncs the differences from snapshot and updates
+// it. Objects that fail to sync keep their old snapshot entry so they are
+// retried on the next poll.
+func (w *PollWatcher) poll(ctx context.Context, snapshot *Snapshot) (int, error) {
+	source, _, err := w.Manager.providers(w.Options)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	current := make(map[string]types.FileInfo, len(snapshot.Files))
+	var changes []Change
+	err = source.ListIter(ctx, w.Options.SourcePath, func(file types.FileInfo) error {
+		if !matchesFilters(relativeKey(file.Path, w.Options.SourcePath), w.Options) {
+			return nil
+		}
+		current[file.Path] = file
+		previous, ok := snapshot.Files[file.Path]
+		if !ok || previous.ETag != file.ETag || previous.Size != file.Size {
+			changes = append(changes, Change{Path: file.Path, Info: file})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list source files: %v", err)
+	}
+	for key := range snapshot.Files {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, Change{Path: key, Deleted: true})
+		}
+	}
+
+	w.Manager.Logger.Log(types.INFO, types.LogEntry{
+		Message:   fmt.Sprintf("Polled %d files under %s, %d changed", len(current), w.Options.SourcePath, len(changes)),
+		Operation: "poll",
+		Provider:  string(w.Options.SourceProvider),
+		Source:    w.Options.SourcePath,
+		Duration:  time.Since(start),
+	})
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	report, err := w.Manager.SyncChanges(ctx, w.Options, changes)
+	if err != nil {
+		return len(changes), err
+	}
+
+	// Failed files, and archived files still being restored, keep their
+	// previous entry so the next poll tries them again.
+	failed := make(map[string]bool, len(report.FailedKeys))
+	for _, key := range report.FailedKeys {
+		failed[key.Path] = true
+	}
+	for _, job := range report.takeWaiting() {
+		failed[job.SourcePath] = true
+	}
+	for key := range failed {
+		if previous, ok := snapshot.Files[key]; ok {
+			current[key] = previous
+		} else {
+			delete(current, key)
+		}
+	}
+	// A failed mirror delete is reported by destination key; keep the
+	// source entry so the delete is retried.
+	for key, previous := range snapshot.Files {
+		if _, ok := current[key]; !ok && failed[destinationKey(w.Options, key)] {
+			current[key] = previous
+		}
+	}
+
+	snapshot.Files = current
+	snapshot.Taken = start
+	if err := w.saveSnapshot(snapshot); err != nil {
+		return len(changes), err
+	}
+
+	w.Manager.Logger.LogInfo(fmt.Sprintf("Synced %d changes: %s", len(changes), report.Summary()))
+	return len(changes), nil
+}
+
+func (w *PollWatcher) loadSnapshot() (*Snapshot, error) {
+	source := string(w.Options.SourceProvider) + ":" + w.Options.SourcePath
+	snapshot := &Snapshot{Source: source, Files: make(map[string]types.FileInfo)}
+
+	data, err := os.ReadFile(w.SnapshotPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return snapshot, nil
+		}
+		return nil, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %v", w.SnapshotPath, err)
+	}
+	if snapshot.Source != source {
+		return nil, fmt.Errorf("snapshot %s belongs to %s, not %s", w.SnapshotPath, snapshot.Source, source)
+	}
+	if snapshot.Files == nil {
+		snapshot.Files = make(map[string]types.FileInfo)
+	}
+	return snapshot, nil
+}
+
+func (w *PollWatcher) saveSnapshot(snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(w.SnapshotPath), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+	tempFile := w.SnapshotPath + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	return os.Rename(tempFile, w.SnapshotPath)
+}
@@ -4,28 +4,39 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"datasyncer/types"
 )
 
+// SyncState is the state file. File states live in shard files next to
+// it; state files written before sharding keep them in FileStates, and
+// they move to the shards on the next save.
 type SyncState struct {
 	ID             string                `json:"id"`
 	StartTime      time.Time             `json:"start_time"`
 	LastUpdated    time.Time             `json:"last_updated"`
 	Status         string                `json:"status"`
-	FileStates     map[string]FileState  `json:"file_states"`
+	FileStates     map[string]FileState  `json:"file_states,omitempty"`
 	FailedFiles    map[string]FailedFile `json:"failed_files"`
 	TotalFiles     int                   `json:"total_files"`
 	ProcessedFiles int                   `json:"processed_files"`
 }
 
 type FileState struct {
+	// Key is the FileStates map key; see SyncJob.stateKey. States written
+	// before it existed are keyed by Path.
+	Key              string    `json:"key,omitempty"`
 	Path             string    `json:"path"`
 	Size             int64     `json:"size"`
 	LastModified     time.Time `json:"last_modified"`
 	ETag             string    `json:"etag"`
-	Status           string    `json:"status"` // "pending", "in_progress", "completed", "failed"
+	Status           string    `json:"status"` // "pending", "in_progress", "waiting_restore", "completed", "failed"
 	BytesTransferred int64     `json:"bytes_transferred"`
 	Attempts         int       `json:"attempts"`
 }
@@ -37,10 +48,16 @@ type FailedFile struct {
 	Attempts  int       `json:"attempts"`
 }
 
+// stateShards is how many files the file states are split across, so a
+// save only rewrites the shards whose files changed since the last one.
+const stateShards = 256
+
 type RecoveryManager struct {
 	statePath    string
 	maxAttempts  int
 	state        *SyncState
+	shards       [stateShards]map[string]FileState
+	dirty        map[int]bool // shards changed since the last save
 	mu           sync.RWMutex
 	saveInterval time.Duration
 }
@@ -49,15 +66,18 @@ func NewRecoveryManager(statePath string, maxAttempts int) (*RecoveryManager, er
 	rm := &RecoveryManager{
 		statePath:    statePath,
 		maxAttempts:  maxAttempts,
+		dirty:        make(map[int]bool),
 		saveInterval: 30 * time.Second,
 	}
+	for i := range rm.shards {
+		rm.shards[i] = make(map[string]FileState)
+	}
 
-	if err := rm.loadState(); err != nil {
+	if err := rm.loadState(); err != nil || rm.state == nil {
 		rm.state = &SyncState{
 			ID:          fmt.Sprintf("sync_%d", time.Now().Unix()),
 			StartTime:   time.Now(),
 			Status:      "initializing",
-			FileStates:  make(map[string]FileState),
 			FailedFiles: make(map[string]FailedFile),
 		}
 	}
@@ -65,7 +85,32 @@ func NewRecoveryManager(statePath string, maxAttempts int) (*RecoveryManager, er
 	return rm, nil
 }
 
+// shardOf returns the shard that keeps the state of key.
+func shardOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % stateShards)
+}
+
+// shardPath returns the file shard i is saved to.
+func (rm *RecoveryManager) shardPath(i int) string {
+	return filepath.Join(rm.statePath+".d", fmt.Sprintf("%02x.json", i))
+}
+
 func (rm *RecoveryManager) loadState() error {
+	for i := range rm.shards {
+		data, err := os.ReadFile(rm.shardPath(i))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read state file: %v", err)
+		}
+		if err := json.Unmarshal(data, &rm.shards[i]); err != nil {
+			return fmt.Errorf("failed to unmarshal state: %v", err)
+		}
+	}
+
 	data, err := os.ReadFile(rm.statePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -79,26 +124,68 @@ func (rm *RecoveryManager) loadState() error {
 		return fmt.Errorf("failed to unmarshal state: %v", err)
 	}
 
+	// States from before sharding move to the shards; a shard written
+	// since then is newer.
+	for key, fileState := range state.FileStates {
+		i := shardOf(key)
+		if _, ok := rm.shards[i][key]; !ok {
+			rm.shards[i][key] = fileState
+			rm.dirty[i] = true
+		}
+	}
+	state.FileStates = nil
+	if state.FailedFiles == nil {
+		state.FailedFiles = make(map[string]FailedFile)
+	}
+
 	rm.state = &state
 	return nil
 }
 
+// saveState writes the shards that changed since the last save, then the
+// state file. It writes nothing when no file state changed.
 func (rm *RecoveryManager) saveState() error {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
+	if len(rm.dirty) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(rm.statePath+".d", 0755); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	for i := range rm.dirty {
+		data, err := json.Marshal(rm.shards[i])
+		if err != nil {
+			return fmt.Errorf("failed to marshal state: %v", err)
+		}
+		if err := writeStateFile(rm.shardPath(i), data); err != nil {
+			return err
+		}
+		delete(rm.dirty, i)
+	}
+
 	rm.state.LastUpdated = time.Now()
 	data, err := json.MarshalIndent(rm.state, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %v", err)
 	}
+	return writeStateFile(rm.statePath, data)
+}
 
-	tempFile := rm.statePath + ".tmp"
+// writeStateFile replaces path with data through a temporary file, so an
+// interrupted save leaves the previous file intact.
+func writeStateFile(path string, data []byte) error {
+	tempFile := path + ".tmp"
 	if err := os.WriteFile(tempFile, data, 0644); err != nil {
 		return fmt.Errorf("failed to write state file: %v", err)
 	}
+	return os.Rename(tempFile, path)
+}
 
-	return os.Rename(tempFile, rm.statePath)
+// Save writes the current state to disk immediately.
+func (rm *RecoveryManager) Save() error {
+	return rm.saveState()
 }
 
 func (rm *RecoveryManager) StartAutoSave(ctx context.Context) {
@@ -120,11 +207,11 @@ func (rm *RecoveryManager) StartAutoSave(ctx context.Context) {
 	}()
 }
 
-func (rm *RecoveryManager) GetFileState(path string) (FileState, bool) {
+func (rm *RecoveryManager) GetFileState(key string) (FileState, bool) {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
 
-	state, exists := rm.state.FileStates[path]
+	state, exists := rm.shards[shardOf(key)][key]
 	return state, exists
 }
 
@@ -132,7 +219,13 @@ func (rm *RecoveryManager) UpdateFileState(state FileState) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
-	rm.state.FileStates[state.Path] = state
+	key := state.Key
+	if key == "" {
+		key = state.Path
+	}
+	i := shardOf(key)
+	rm.shards[i][key] = state
+	rm.dirty[i] = true
 	rm.state.LastUpdated = time.Now()
 
 	// Update processed files count if needed
@@ -140,3 +233,30 @@ func (rm *RecoveryManager) UpdateFileState(state FileState) {
 		rm.state.ProcessedFiles++
 	}
 }
+
+// ResetAttempts clears the attempt counts of the unfinished files of the
+// sync opts describes, so files that ran out of attempts in an earlier run
+// are tried again. It returns how many files were reset.
+func (rm *RecoveryManager) ResetAttempts(opts types.SyncOptions) int {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	source := fmt.Sprintf("%s:%s", opts.SourceProvider, opts.SourcePath)
+	dest := fmt.Sprintf("->%s:%s", opts.DestinationProvider, opts.DestinationPath)
+	reset := 0
+	for i, shard := range rm.shards {
+		for key, state := range shard {
+			if state.Attempts == 0 || state.Status == "completed" {
+				continue
+			}
+			if !strings.HasPrefix(key, source) || !strings.Contains(key, dest) {
+				continue
+			}
+			state.Attempts = 0
+			shard[key] = state
+			rm.dirty[i] = true
+			reset++
+		}
+	}
+	return reset
+}
@@ -0,0 +1,347 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"datasyncer/types"
+)
+
+type Outcome string
+
+const (
+	OutcomeCopied     Outcome = "copied"
+	OutcomeSkipped    Outcome = "skipped"
+	OutcomeConflicted Outcome = "conflicted"
+	OutcomeFailed     Outcome = "failed"
+	OutcomeDeleted    Outcome = "deleted"
+	// OutcomeWaitingRestore parks an archived file until its restore
+	// completes.
+	OutcomeWaitingRestore Outcome = "waiting_restore"
+)
+
+// maxTopErrors limits how many distinct errors a report lists.
+const maxTopErrors = 10
+
+type ErrorCount struct {
+	Error string `json:"error"`
+	Count int64  `json:"count"`
+}
+
+type FailedKey struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type RunReport struct {
+	ID                  string              `json:"id"`
+	SourceProvider      types.CloudProvider `json:"source_provider"`
+	SourcePath          string              `json:"source_path"`
+	DestinationProvider types.CloudProvider `json:"destination_provider"`
+	DestinationPath     string              `json:"destination_path"`
+	StartTime           time.Time           `json:"start_time"`
+	EndTime             time.Time           `json:"end_time"`
+	DurationSeconds     float64             `json:"duration_seconds"`
+	TotalFiles          int                 `json:"total_files"`
+	Copied              int                 `json:"copied"`
+	Skipped             int                 `json:"skipped"`
+	Conflicted          int                 `json:"conflicted"`
+	Failed              int                 `json:"failed"`
+	Deleted             int                 `json:"deleted"`
+	WaitingRestore      int                 `json:"waiting_restore,omitempty"`
+	BytesTransferred    int64               `json:"bytes_transferred"`
+	ThroughputBps       float64             `json:"throughput_bytes_per_second"`
+	TopErrors           []ErrorCount        `json:"top_errors,omitempty"`
+	FailedKeys          []FailedKey         `json:"failed_keys,omitempty"`
+
+	mu      sync.Mutex
+	waiting []SyncJob
+	// errors counts failures by errorClass.
+	errors map[string]int64
+}
+
+func newRunReport(opts types.SyncOptions) *RunReport {
+	start := time.Now()
+	return &RunReport{
+		ID:                  fmt.Sprintf("run_%s", start.Format("20060102T150405")),
+		SourceProvider:      opts.SourceProvider,
+		SourcePath:          opts.SourcePath,
+		DestinationProvider: opts.DestinationProvider,
+		DestinationPath:     opts.DestinationPath,
+		StartTime:           start,
+		errors:              make(map[string]int64),
+	}
+}
+
+func (r *RunReport) record(job SyncJob, outcome Outcome, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch outcome {
+	case OutcomeCopied:
+		r.Copied++
+		r.BytesTransferred += job.FileInfo.Size
+	case OutcomeSkipped:
+		r.Skipped++
+	case OutcomeConflicted:
+		r.Conflicted++
+		r.BytesTransferred += job.FileInfo.Size
+	case OutcomeFailed:
+		r.Failed++
+		failed := FailedKey{Path: job.SourcePath}
+		if job.Delete {
+			failed.Path = job.DestinationPath
+		}
+		if err != nil {
+			failed.Error = err.Error()
+			r.errors[errorClass(job, err)]++
+		}
+		r.FailedKeys = append(r.FailedKeys, failed)
+	case OutcomeDeleted:
+		r.Deleted++
+	case OutcomeWaitingRestore:
+		r.WaitingRestore++
+		r.waiting = append(r.waiting, job)
+	}
+}
+
+// tempName matches the temporary files transfers go through.
+var tempName = regexp.MustCompile(`[^\s:"]*datasyncer-[^\s:"]*`)
+
+// errorClass normalizes the error of a failed job so the same failure of
+// different files counts as one error: the job's keys and temporary files
+// are replaced with placeholders.
+func errorClass(job SyncJob, err error) string {
+	msg := err.Error()
+	for _, key := range []string{job.SourcePath, job.DestinationPath} {
+		if key != "" {
+			msg = strings.ReplaceAll(msg, key, "<key>")
+		}
+	}
+	return tempName.ReplaceAllString(msg, "<temp>")
+}
+
+// takeWaiting returns the files parked since the last call. They stay
+// counted as waiting until released.
+func (r *RunReport) takeWaiting() []SyncJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	waiting := r.waiting
+	r.waiting = nil
+	return waiting
+}
+
+// requeue parks jobs taken with takeWaiting again, still counted as
+// waiting, so the caller can tell which files were left behind.
+func (r *RunReport) requeue(jobs []SyncJob) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.waiting = append(r.waiting, jobs...)
+}
+
+// release stops counting n parked files as waiting, before they are
+// recorded again with their final outcome.
+func (r *RunReport) release(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.WaitingRestore -= n
+}
+
+// finish freezes the report and ranks the run's errors.
+func (r *RunReport) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.EndTime = time.Now()
+	duration := r.EndTime.Sub(r.StartTime)
+	r.DurationSeconds = duration.Seconds()
+	if r.DurationSeconds > 0 {
+		r.ThroughputBps = float64(r.BytesTransferred) / r.DurationSeconds
+	}
+
+	r.TopErrors = r.TopErrors[:0]
+	for class, count := range r.errors {
+		r.TopErrors = append(r.TopErrors, ErrorCount{Error: class, Count: count})
+	}
+	sort.Slice(r.TopErrors, func(i, j int) bool {
+		if r.TopErrors[i].Count != r.TopErrors[j].Count {
+			return r.TopErrors[i].Count > r.TopErrors[j].Count
+		}
+		return r.TopErrors[i].Error < r.TopErrors[j].Error
+	})
+	if len(r.TopErrors) > maxTopErrors {
+		r.TopErrors = r.TopErrors[:maxTopErrors]
+	}
+	sort.Slice(r.FailedKeys, func(i, j int) bool {
+		return r.FailedKeys[i].Path < r.FailedKeys[j].Path
+	})
+}
+
+func (r *RunReport) Duration() time.Duration {
+	return time.Duration(r.DurationSeconds * float64(time.Second))
+}
+
+func (r *RunReport) Summary() string {
+	waiting := ""
+	if r.WaitingRestore > 0 {
+		waiting = fmt.Sprintf(", %d waiting for restore", r.WaitingRestore)
+	}
+	return fmt.Sprintf("%d copied, %d skipped, %d conflicted, %d failed, %d deleted%s (%s in %s)",
+		r.Copied, r.Skipped, r.Conflicted, r.Failed, r.Deleted, waiting,
+		types.FormatBytes(r.BytesTransferred), r.Duration().Round(time.Second))
+}
+
+// WriteFiles writes the report as JSON to path and renders Markdown and HTML
+// variants next to it, replacing the extension with .md and .html.
+func (r *RunReport) WriteFiles(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create report directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	markdown, err := r.RenderMarkdown()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".md", []byte(markdown), 0644); err != nil {
+		return fmt.Errorf("failed to write markdown report: %v", err)
+	}
+
+	html, err := r.RenderHTML()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".html", []byte(html), 0644); err != nil {
+		return fmt.Errorf("failed to write HTML report: %v", err)
+	}
+
+	return nil
+}
+
+var reportFuncs = map[string]any{
+	"bytes":    types.FormatBytes,
+	"rate":     func(bps float64) string { return types.FormatBytes(int64(bps)) + "/s" },
+	"duration": func(r *RunReport) string { return r.Duration().Round(time.Millisecond).String() },
+	"time":     func(t time.Time) string { return t.Format(time.RFC3339) },
+}
+
+const markdownReport = `# Sync report {{.ID}}
+
+| | |
+|---|---|
+| Source | {{.SourceProvider}}:{{.SourcePath}} |
+| Destination | {{.DestinationProvider}}:{{.DestinationPath}} |
+| Started | {{time .StartTime}} |
+| Duration | {{duration .}} |
+| Bytes | {{bytes .BytesTransferred}} |
+| Throughput | {{rate .ThroughputBps}} |
+
+## Outcomes
+
+| Outcome | Files |
+|---|---:|
+| Copied | {{.Copied}} |
+| Skipped | {{.Skipped}} |
+| Conflicted | {{.Conflicted}} |
+| Failed | {{.Failed}} |
+| Deleted | {{.Deleted}} |
+{{if .WaitingRestore}}| Waiting for restore | {{.WaitingRestore}} |
+{{end}}| **Total listed** | **{{.TotalFiles}}** |
+{{if .TopErrors}}
+## Top errors
+
+| Count | Error |
+|---:|---|
+{{range .TopErrors}}| {{.Count}} | {{.Error}} |
+{{end}}{{end}}{{if .FailedKeys}}
+## Failed keys
+
+{{range .FailedKeys}}- ` + "`{{.Path}}`" + `{{if .Error}}: {{.Error}}{{end}}
+{{end}}{{end}}`
+
+const htmlReport = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sync report {{.ID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+.failed { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Sync report {{.ID}}</h1>
+<table>
+<tr><th>Source</th><td>{{.SourceProvider}}:{{.SourcePath}}</td></tr>
+<tr><th>Destination</th><td>{{.DestinationProvider}}:{{.DestinationPath}}</td></tr>
+<tr><th>Started</th><td>{{time .StartTime}}</td></tr>
+<tr><th>Duration</th><td>{{duration .}}</td></tr>
+<tr><th>Bytes</th><td>{{bytes .BytesTransferred}}</td></tr>
+<tr><th>Throughput</th><td>{{rate .ThroughputBps}}</td></tr>
+</table>
+<h2>Outcomes</h2>
+<table>
+<tr><th>Copied</th><td>{{.Copied}}</td></tr>
+<tr><th>Skipped</th><td>{{.Skipped}}</td></tr>
+<tr><th>Conflicted</th><td>{{.Conflicted}}</td></tr>
+<tr><th class="failed">Failed</th><td>{{.Failed}}</td></tr>
+<tr><th>Deleted</th><td>{{.Deleted}}</td></tr>
+{{if .WaitingRestore}}<tr><th>Waiting for restore</th><td>{{.WaitingRestore}}</td></tr>
+{{end}}<tr><th>Total listed</th><td>{{.TotalFiles}}</td></tr>
+</table>
+{{if .TopErrors}}<h2>Top errors</h2>
+<table>
+<tr><th>Count</th><th>Error</th></tr>
+{{range .TopErrors}}<tr><td>{{.Count}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}{{if .FailedKeys}}<h2>Failed keys</h2>
+<ul>
+{{range .FailedKeys}}<li><code>{{.Path}}</code>{{if .Error}}: {{.Error}}{{end}}</li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`
+
+var (
+	markdownTemplate = template.Must(template.New("markdown").Funcs(reportFuncs).Parse(markdownReport))
+	htmlTemplate     = htmltemplate.Must(htmltemplate.New("html").Funcs(reportFuncs).Parse(htmlReport))
+)
+
+func (r *RunReport) RenderMarkdown() (string, error) {
+	var b strings.Builder
+	if err := markdownTemplate.Execute(&b, r); err != nil {
+		return "", fmt.Errorf("failed to render markdown report: %v", err)
+	}
+	return b.String(), nil
+}
+
+func (r *RunReport) RenderHTML() (string, error) {
+	var b strings.Builder
+	if err := htmlTemplate.Execute(&b, r); err != nil {
+		return "", fmt.Errorf("failed to render HTML report: %v", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,236 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"datasyncer/types"
+)
+
+// sourceVersionKey is the metadata key a replayed copy records its source
+// version in, so the next replay resumes after it.
+const sourceVersionKey = "sourceversion"
+
+// EachKeyVersions calls fn with every key under prefix and all of its
+// versions, in the order the provider lists them.
+func EachKeyVersions(ctx context.Context, versioner types.Versioner, prefix string, fn func(key string, versions []types.FileVersion) error) error {
+	var key string
+	var group []types.FileVersion
+	err := versioner.ListVersions(ctx, prefix, func(version types.FileVersion) error {
+		if len(group) > 0 && version.Path != key {
+			if err := fn(key, group); err != nil {
+				return err
+			}
+			group = nil
+		}
+		key = version.Path
+		group = append(group, version)
+		return nil
+	})
+	if err == nil && len(group) > 0 {
+		err = fn(key, group)
+	}
+	return err
+}
+
+// VersionAt returns the version of a key that was current at time at, or
+// false when the key did not exist then.
+func VersionAt(versions []types.FileVersion, at time.Time) (types.FileVersion, bool) {
+	var found types.FileVersion
+	ok := false
+	for _, v := range versions {
+		if v.LastModified.After(at) {
+			continue
+		}
+		if !ok || v.LastModified.After(found.LastModified) {
+			found, ok = v, true
+		}
+	}
+	if !ok || found.DeleteMarker || (!found.Deleted.IsZero() && !found.Deleted.After(at)) {
+		return types.FileVersion{}, false
+	}
+	return found, true
+}
+
+// currentVersion returns the key's current version, or false when the
+// key is deleted.
+func currentVersion(versions []types.FileVersion) (types.FileVersion, bool) {
+	for _, v := range versions {
+		if v.IsLatest && !v.DeleteMarker {
+			return v, true
+		}
+	}
+	return types.FileVersion{}, false
+}
+
+// listVersionJobs queues one job per live source key that replays its
+// versions oldest first. Keys deleted at the source are not replayed.
+func listVersionJobs(ctx context.Context, source types.CloudStorage, opts types.SyncOptions, fn func(SyncJob) error) error {
+	versioner, ok := source.(types.Versioner)
+	if !ok {
+		return fmt.Errorf("%s does not keep file versions", opts.SourceProvider)
+	}
+	return EachKeyVersions(ctx, versioner, opts.SourcePath, func(key string, versions []types.FileVersion) error {
+		if !matchesFilters(relativeKey(key, opts.SourcePath), opts) {
+			return nil
+		}
+		current, ok := currentVersion(versions)
+		if !ok {
+			return nil
+		}
+
+		var history []types.FileInfo
+		for _, v := range versions {
+			if !v.DeleteMarker {
+				history = append(history, v.FileInfo)
+			}
+		}
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].LastModified.Before(history[j].LastModified)
+		})
+
+		job := newSyncJob(opts, current.FileInfo)
+		job.Versions = history
+		return fn(job)
+	})
+}
+
+// replayVersions copies the job's versions to the destination oldest
+// first, so a versioned destination ends up with the same history. It
+// resumes after the version recorded on the destination's current copy,
+// and replays everything when that version is unknown.
+func (sm *SyncManager) replayVersions(ctx context.Context, job SyncJob, source, dest types.CloudStorage) (Outcome, error) {
+	start := 0
+	if destInfo, err := dest.GetFileInfo(ctx, job.DestinationPath); err == nil {
+		if last := metadataValue(destInfo.Metadata, sourceVersionKey); last != "" {
+			for i, version := range job.Versions {
+				if version.VersionID == last {
+					start = i + 1
+				}
+			}
+		}
+	}
+	if start == len(job.Versions) {
+		return OutcomeSkipped, nil
+	}
+
+	for _, version := range job.Versions[start:] {
+		versionJob := job
+		versionJob.FileInfo = version
+		versionJob.Versions = nil
+		versionJob.RecordVersion = true
+		if err := sm.transferFile(ctx, versionJob, source, dest); err != nil {
+			return OutcomeFailed, fmt.Errorf("failed to copy version %s: %v", version.VersionID, err)
+		}
+	}
+	sm.Logger.LogInfo(fmt.Sprintf("Replayed %d versions of %s to %s", len(job.Versions)-start, job.SourcePath, job.DestinationPath))
+	return OutcomeCopied, nil
+}
+
+func metadataValue(metadata map[string]string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// RollbackChange is what rolling one key back to a point in time does:
+// copy Version over the current file, or delete the file when it did not
+// exist then.
+type RollbackChange struct {
+	Path    string
+	Version types.FileVersion
+	Delete  bool
+}
+
+// PlanRollback calls fn with the change needed to roll each key under
+// prefix back to how it was at time at. Keys that are already as they
+// were are left out.
+func PlanRollback(ctx context.Context, versioner types.Versioner, prefix string, at time.Time, fn func(RollbackChange) error) error {
+	return EachKeyVersions(ctx, versioner, prefix, func(key string, versions []types.FileVersion) error {
+		target, existed := VersionAt(versions, at)
+		current, exists := currentVersion(versions)
+		switch {
+		case !existed && exists:
+			return fn(RollbackChange{Path: key, Delete: true})
+		case existed && !(exists && current.VersionID == target.VersionID):
+			return fn(RollbackChange{Path: key, Version: target})
+		}
+		return nil
+	})
+}
+
+// Rollback applies the changes feed sends to provider with parallel
+// workers. Restored versions become the current version again, so the
+// rollback itself can be undone.
+func (sm *SyncManager) Rollback(ctx context.Context, provider types.CloudProvider, parallel int, feed func(send func(RollbackChange) error) error) (*RunReport, error) {
+	opts := types.SyncOptions{
+		SourceProvider:      provider,
+		DestinationProvider: provider,
+		Parallel:            parallel,
+	}
+	_, dst, err := sm.providers(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := newRunReport(opts)
+	err = sm.execute(ctx, opts, report, dst, dst, func(send func(SyncJob) error) error {
+		return feed(func(change RollbackChange) error {
+			return send(SyncJob{
+				SourcePath:          change.Path,
+				DestinationPath:     change.Path,
+				FileInfo:            change.Version.FileInfo,
+				SourceProvider:      provider,
+				DestinationProvider: provider,
+				Delete:              change.Delete,
+				Rollback:            !change.Delete,
+			})
+		})
+	})
+	report.finish()
+	return report, err
+}
+
+// RestoreVersion makes one earlier version of key its current version.
+func (sm *SyncManager) RestoreVersion(ctx context.Context, provider types.CloudProvider, key, versionID string) (types.FileInfo, error) {
+	storage := sm.Providers[provider]
+	if storage == nil {
+		return types.FileInfo{}, fmt.Errorf("provider not configured")
+	}
+	versioner, ok := storage.(types.Versioner)
+	if !ok {
+		return types.FileInfo{}, fmt.Errorf("%s does not keep file versions", provider)
+	}
+
+	info, err := versioner.GetVersionInfo(ctx, key, versionID)
+	if err != nil {
+		return types.FileInfo{}, err
+	}
+	job := SyncJob{
+		SourcePath:          key,
+		DestinationPath:     key,
+		FileInfo:            info,
+		SourceProvider:      provider,
+		DestinationProvider: provider,
+		Rollback:            true,
+	}
+	if _, err := sm.rollbackFile(ctx, job, storage); err != nil {
+		return types.FileInfo{}, err
+	}
+	return info, nil
+}
+
+func (sm *SyncManager) rollbackFile(ctx context.Context, job SyncJob, storage types.CloudStorage) (Outcome, error) {
+	if err := sm.transferFile(ctx, job, storage, storage); err != nil {
+		sm.logCopyFailure(job, err)
+		return OutcomeFailed, fmt.Errorf("failed to restore %s to version %s: %v", job.DestinationPath, job.FileInfo.VersionID, err)
+	}
+	sm.Logger.LogInfo(fmt.Sprintf("Restored %s to version %s", job.DestinationPath, job.FileInfo.VersionID))
+	return OutcomeCopied, nil
+}
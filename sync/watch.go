@@ -0,0 +1,256 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"datasyncer/providers"
+	"datasyncer/types"
+)
+
+const (
+	defaultDebounce       = 2 * time.Second
+	defaultMaxDelay       = 30 * time.Second
+	defaultRescanInterval = 10 * time.Minute
+	// finalFlushTimeout bounds the sync of the last batch once a watcher
+	// is stopped.
+	finalFlushTimeout = time.Minute
+)
+
+// FlushContext returns the context to sync a watcher's last batch with
+// once ctx is cancelled, so changes already received are not dropped.
+func FlushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), finalFlushTimeout)
+}
+
+// WatchOptions tune how watchers batch changes.
+type WatchOptions struct {
+	// Debounce is the quiet period after the last event before a batch is
+	// synced.
+	Debounce time.Duration
+	// MaxDelay bounds how long a change waits while events keep arriving.
+	MaxDelay time.Duration
+	// RescanInterval is how often a full sync runs to catch missed events.
+	// A negative value disables rescans.
+	RescanInterval time.Duration
+}
+
+func (o WatchOptions) withDefaults() WatchOptions {
+	if o.Debounce <= 0 {
+		o.Debounce = defaultDebounce
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = defaultMaxDelay
+	}
+	if o.RescanInterval == 0 {
+		o.RescanInterval = defaultRescanInterval
+	}
+	return o
+}
+
+// LocalWatcher keeps a destination in step with a local directory. It runs
+// a full sync, then pushes files as filesystem events report them, and
+// repeats the full sync periodically in case events were lost.
+type LocalWatcher struct {
+	Manager *SyncManager
+	Options types.SyncOptions
+	// Root is the root directory of the local source provider; an empty
+	// root means source keys are filesystem paths.
+	Root string
+	WatchOptions
+}
+
+func (w *LocalWatcher) Run(ctx context.Context) error {
+	opts := w.WatchOptions.withDefaults()
+	logger := w.Manager.Logger
+
+	dir := providers.LocalPath(w.Root, w.Options.SourcePath)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	dirs := make(map[string]bool)
+	if _, err := w.addTree(watcher, dirs, dir); err != nil {
+		return err
+	}
+
+	if err := w.rescan(ctx); err != nil {
+		return err
+	}
+	logger.LogInfo(fmt.Sprintf("Watching %s (%d directories)", dir, len(dirs)))
+
+	var rescanC <-chan time.Time
+	if opts.RescanInterval > 0 {
+		ticker := time.NewTicker(opts.RescanInterval)
+		defer ticker.Stop()
+		rescanC = ticker.C
+	}
+
+	debounce := time.NewTimer(opts.Debounce)
+	debounce.Stop()
+	pending := make(map[string]bool)
+	var firstPending time.Time
+	needRescan := false
+
+	flush := func(ctx context.Context) {
+		if needRescan {
+			needRescan = false
+			pending = make(map[string]bool)
+			if err := w.rescan(ctx); err != nil {
+				logger.LogError(fmt.Sprintf("Rescan failed: %v", err))
+			}
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
+		batch := pending
+		pending = make(map[string]bool)
+		w.syncBatch(ctx, batch)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := FlushContext(ctx)
+			flush(flushCtx)
+			cancel()
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					// Files may have been written before the new directory
+					// was watched, so queue everything already in it.
+					files, err := w.addTree(watcher, dirs, event.Name)
+					if err != nil {
+						logger.LogWarn(err.Error())
+					}
+					for _, file := range files {
+						pending[file] = true
+					}
+				}
+			}
+			if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				if dirs[event.Name] {
+					delete(dirs, event.Name)
+					// A removed directory is one event for many files;
+					// mirror them with a full sync.
+					needRescan = needRescan || w.Options.Mirror
+				}
+			}
+			pending[event.Name] = true
+
+			now := time.Now()
+			if len(pending) == 1 {
+				firstPending = now
+			}
+			if now.Sub(firstPending) >= opts.MaxDelay {
+				debounce.Stop()
+				flush(ctx)
+			} else {
+				debounce.Reset(opts.Debounce)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.LogWarn(fmt.Sprintf("File watcher error: %v", err))
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				needRescan = true
+				debounce.Reset(opts.Debounce)
+			}
+
+		case <-debounce.C:
+			flush(ctx)
+
+		case <-rescanC:
+			needRescan = true
+			flush(ctx)
+		}
+	}
+}
+
+// addTree watches dir and every directory below it, and returns the files
+// found on the way.
+func (w *LocalWatcher) addTree(watcher *fsnotify.Watcher, dirs map[string]bool, dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			if d.Type().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		}
+		if dirs[path] {
+			return nil
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %v", path, err)
+		}
+		dirs[path] = true
+		return nil
+	})
+	return files, err
+}
+
+func (w *LocalWatcher) rescan(ctx context.Context) error {
+	report, err := w.Manager.sync(ctx, w.Options, false)
+	if err != nil {
+		return err
+	}
+	w.Manager.Logger.LogInfo(fmt.Sprintf("Full sync: %s", report.Summary()))
+	return nil
+}
+
+func (w *LocalWatcher) syncBatch(ctx context.Context, batch map[string]bool) {
+	changes := make([]Change, 0, len(batch))
+	for path := range batch {
+		key, err := providers.LocalKey(w.Root, path)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		switch {
+		case err == nil && info.Mode().IsRegular():
+			changes = append(changes, Change{Path: key})
+		case os.IsNotExist(err):
+			changes = append(changes, Change{Path: key, Deleted: true})
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	report, err := w.Manager.SyncChanges(ctx, w.Options, changes)
+	if err != nil {
+		w.Manager.Logger.LogError(fmt.Sprintf("Failed to sync changes: %v", err))
+		return
+	}
+	if report.TotalFiles > 0 {
+		w.Manager.Logger.LogInfo(fmt.Sprintf("Synced %d changes: %s", report.TotalFiles, report.Summary()))
+	}
+}
@@ -0,0 +1,104 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ConfigDir returns the datasyncer configuration directory,
+// $HOME/.datasyncer.
+func ConfigDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %v", err)
+	}
+	return filepath.Join(home, ".datasyncer"), nil
+}
+
+// CredentialStore keeps named provider credential profiles in a single JSON
+// file that is only readable by the owner.
+type CredentialStore struct {
+	Path string
+}
+
+func DefaultCredentialStore() (*CredentialStore, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return &CredentialStore{Path: filepath.Join(dir, "credentials.json")}, nil
+}
+
+func (s *CredentialStore) load() (map[string]ProviderConfig, error) {
+	profiles := make(map[string]ProviderConfig)
+
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profiles, nil
+		}
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file: %v", err)
+	}
+	return profiles, nil
+}
+
+func (s *CredentialStore) Get(name string) (ProviderConfig, error) {
+	profiles, err := s.load()
+	if err != nil {
+		return ProviderConfig{}, err
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return ProviderConfig{}, fmt.Errorf("credential profile not found: %s", name)
+	}
+	return profile, nil
+}
+
+func (s *CredentialStore) Names() ([]string, error) {
+	profiles, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Save stores the profile under name, replacing any existing profile with the
+// same name. The file is written with 0600 permissions.
+func (s *CredentialStore) Save(name string, profile ProviderConfig) error {
+	profiles, err := s.load()
+	if err != nil {
+		return err
+	}
+	profiles[name] = profile
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %v", err)
+	}
+
+	tempFile := s.Path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credentials file: %v", err)
+	}
+	if err := os.Chmod(tempFile, 0600); err != nil {
+		return fmt.Errorf("failed to set credentials file permissions: %v", err)
+	}
+
+	return os.Rename(tempFile, s.Path)
+}
@@ -0,0 +1,258 @@
+package types
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TLS modes for EmailConfig.TLSMode.
+const (
+	TLSModeStartTLS = "starttls"
+	TLSModeImplicit = "tls"
+	TLSModeNone     = "none"
+)
+
+// Auth methods for EmailConfig.AuthMethod.
+const (
+	AuthPlain = "plain"
+	AuthLogin = "login"
+	AuthNone  = "none"
+)
+
+const (
+	defaultSubjectTemplate = `[datasyncer] {{.Title}}`
+	defaultBodyTemplate    = `{{.Message}}
+{{with .Report}}
+{{.RenderMarkdown}}{{end}}`
+)
+
+// Notification is the data passed to notification channels and to the email
+// subject and body templates.
+type Notification struct {
+	Title   string
+	Message string
+	// Report is the sync run report, if the notification concerns a run.
+	Report any
+	Time   time.Time
+
+	// Failed, FailedFiles and Duration describe the run for routing rules.
+	Failed      bool
+	FailedFiles int
+	Duration    time.Duration
+	// Key identifies repeats of the same notification for deduplication.
+	// Title and Message are used when it is empty.
+	Key string
+}
+
+// MailTransport delivers an already-formatted message. It exists so email
+// delivery can be exercised against a stub instead of a real SMTP server.
+type MailTransport interface {
+	Send(cfg EmailConfig, to []string, msg []byte) error
+}
+
+// SMTPTransport delivers mail over SMTP using the connection settings in
+// EmailConfig.
+type SMTPTransport struct {
+	Timeout time.Duration
+}
+
+func (t SMTPTransport) Send(cfg EmailConfig, to []string, msg []byte) error {
+	// Never fall back to plaintext for a mode that was not understood.
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(cfg.SMTPServer, strconv.Itoa(cfg.Port))
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.SMTPServer,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: timeout}
+	if cfg.tlsMode() == TLSModeImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server %s: %v", addr, err)
+	}
+
+	client, err := smtp.NewClient(conn, cfg.SMTPServer)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %v", err)
+	}
+	defer client.Close()
+
+	if cfg.tlsMode() == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+
+	if auth := cfg.auth(); auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(cfg.FromEmail); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %v", err)
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s failed: %v", rcpt, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %v", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to finish message: %v", err)
+	}
+
+	return client.Quit()
+}
+
+func (c EmailConfig) Enabled() bool {
+	return c.SMTPServer != "" && len(c.Recipients) > 0
+}
+
+// Validate reports unknown TLS modes and auth methods.
+func (c EmailConfig) Validate() error {
+	switch c.tlsMode() {
+	case TLSModeStartTLS, TLSModeImplicit, TLSModeNone:
+	default:
+		return fmt.Errorf("tls_mode must be starttls, tls or none, got %q", c.TLSMode)
+	}
+	switch strings.ToLower(c.AuthMethod) {
+	case "", AuthPlain, AuthLogin, AuthNone:
+	default:
+		return fmt.Errorf("auth_method must be plain, login or none, got %q", c.AuthMethod)
+	}
+	return nil
+}
+
+func (c EmailConfig) tlsMode() string {
+	if c.TLSMode != "" {
+		return strings.ToLower(c.TLSMode)
+	}
+	if c.Port == 465 {
+		return TLSModeImplicit
+	}
+	return TLSModeStartTLS
+}
+
+func (c EmailConfig) auth() smtp.Auth {
+	if c.Username == "" {
+		return nil
+	}
+	switch strings.ToLower(c.AuthMethod) {
+	case AuthNone:
+		return nil
+	case AuthLogin:
+		return &loginAuth{username: c.Username, password: c.Password}
+	default:
+		return smtp.PlainAuth("", c.Username, c.Password, c.SMTPServer)
+	}
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp does not provide
+// but many Microsoft-hosted servers still require.
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge: %s", fromServer)
+	}
+}
+
+// buildEmail renders the subject and body templates and returns a complete
+// RFC 5322 message.
+func buildEmail(cfg EmailConfig, n Notification) ([]byte, error) {
+	subjectTmpl := cfg.SubjectTemplate
+	if subjectTmpl == "" {
+		subjectTmpl = defaultSubjectTemplate
+	}
+	bodyTmpl := cfg.BodyTemplate
+	if bodyTmpl == "" {
+		bodyTmpl = defaultBodyTemplate
+	}
+
+	subject, err := renderTemplate("subject", subjectTmpl, n)
+	if err != nil {
+		return nil, err
+	}
+	body, err := renderTemplate("body", bodyTmpl, n)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.FromEmail)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.Recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return msg.Bytes(), nil
+}
+
+func renderTemplate(name, text string, data any) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid email %s template: %v", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render email %s: %v", name, err)
+	}
+	return b.String(), nil
+}
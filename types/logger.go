@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -32,15 +33,32 @@ func (l LogLevel) String() string {
 	}
 }
 
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToUpper(s) {
+	case "DEBUG":
+		return DEBUG, nil
+	case "", "INFO":
+		return INFO, nil
+	case "WARN", "WARNING":
+		return WARN, nil
+	case "ERROR":
+		return ERROR, nil
+	default:
+		return INFO, fmt.Errorf("unknown log level: %s", s)
+	}
+}
+
 type LogEntry struct {
-	Timestamp   time.Time `json:"timestamp"`
-	Level       string    `json:"level"`
-	Message     string    `json:"message"`
-	Operation   string    `json:"operation,omitempty"`
-	Source      string    `json:"source,omitempty"`
-	Destination string    `json:"destination,omitempty"`
-	Error       string    `json:"error,omitempty"`
-	BytesCount  int64     `json:"bytes_count,omitempty"`
+	Timestamp   time.Time     `json:"timestamp"`
+	Level       string        `json:"level"`
+	Message     string        `json:"message"`
+	Operation   string        `json:"operation,omitempty"`
+	Provider    string        `json:"provider,omitempty"`
+	Source      string        `json:"source,omitempty"`
+	Destination string        `json:"destination,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	BytesCount  int64         `json:"bytes_count,omitempty"`
+	Duration    time.Duration `json:"duration_ns,omitempty"`
 }
 
 type Logger struct {
@@ -90,6 +108,10 @@ func (l *Logger) Log(level LogLevel, entry LogEntry) {
 	l.metrics.RecordOperation(entry)
 }
 
+func (l *Logger) Metrics() *MetricsCollector {
+	return l.metrics
+}
+
 func (l *Logger) Close() error {
 	return l.file.Close()
 }
@@ -0,0 +1,73 @@
+package types
+
+import (
+	"sort"
+	"strings"
+)
+
+// NormalizeMetadata adapts user metadata keys to the rules of provider, so
+// metadata read from one provider can be written to another:
+//
+//   - keys are lowercased, since S3 and Azure compare them
+//     case-insensitively and only lowercase keys survive a round trip;
+//   - S3 keys (sent as x-amz-meta-* headers) keep letters, digits, '-', '_'
+//     and '.', and any other character becomes '-';
+//   - Azure keys must be C# identifiers, so any character other than a
+//     letter, digit or '_' becomes '_' and a leading digit gains a '_'
+//     prefix;
+//   - GCS accepts any key;
+//   - local files keep no metadata.
+//
+// Keys that normalize to "" are dropped. When keys collide, the value of
+// the key that sorts first wins.
+func NormalizeMetadata(provider CloudProvider, metadata map[string]string) map[string]string {
+	if len(metadata) == 0 || provider == LOCAL {
+		return nil
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	normalized := make(map[string]string, len(metadata))
+	for _, key := range keys {
+		name := normalizeMetadataKey(provider, key)
+		if name == "" {
+			continue
+		}
+		if _, ok := normalized[name]; !ok {
+			normalized[name] = metadata[key]
+		}
+	}
+	return normalized
+}
+
+func normalizeMetadataKey(provider CloudProvider, key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	switch provider {
+	case AWS:
+		key = strings.Map(func(r rune) rune {
+			if isLowerAlnum(r) || r == '-' || r == '_' || r == '.' {
+				return r
+			}
+			return '-'
+		}, key)
+	case AZURE:
+		key = strings.Map(func(r rune) rune {
+			if isLowerAlnum(r) || r == '_' {
+				return r
+			}
+			return '_'
+		}, key)
+		if key != "" && key[0] >= '0' && key[0] <= '9' {
+			key = "_" + key
+		}
+	}
+	return key
+}
+
+func isLowerAlnum(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')
+}
@@ -1,10 +1,41 @@
 package types
 
 import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// Upper bounds, in seconds, of the latency histogram buckets. Transfers of
+// large objects can take minutes, so the buckets reach well past the usual
+// request-latency defaults.
+var latencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900}
+
+// maxLatencySamples bounds OperationLatencies so long-running syncs do not
+// grow the collector without limit.
+const maxLatencySamples = 1000
+
+type latencyHistogram struct {
+	counts []uint64 // one per bucket, non-cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *latencyHistogram) observe(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
 type MetricsCollector struct {
 	mu sync.RWMutex
 
@@ -15,12 +46,30 @@ type MetricsCollector struct {
 	LastOperationTime  time.Time
 	OperationsByType   map[string]int64
 	ErrorsByType       map[string]int64
+	BytesByProvider    map[string]int64
+	InFlightTransfers  int64
+	QueueDepth         int64
+
+	latencies map[string]*latencyHistogram
+	jobs      map[string]*jobMetrics
+}
+
+// jobMetrics tracks scheduled job runs for the daemon.
+type jobMetrics struct {
+	running     bool
+	lastRun     time.Time
+	lastSuccess time.Time
+	lastFailed  bool
+	runs        map[string]int64 // by status: succeeded, failed, skipped
 }
 
 func NewMetricsCollector() *MetricsCollector {
 	return &MetricsCollector{
 		OperationsByType: make(map[string]int64),
 		ErrorsByType:     make(map[string]int64),
+		BytesByProvider:  make(map[string]int64),
+		latencies:        make(map[string]*latencyHistogram),
+		jobs:             make(map[string]*jobMetrics),
 	}
 }
 
@@ -35,7 +84,242 @@ func (m *MetricsCollector) RecordOperation(entry LogEntry) {
 	}
 	if entry.BytesCount > 0 {
 		m.BytesTransferred += entry.BytesCount
+		if entry.Provider != "" {
+			m.BytesByProvider[entry.Provider] += entry.BytesCount
+		}
+	}
+	if entry.Duration > 0 {
+		m.observeLatency(entry.Operation, entry.Duration)
 	}
 	m.OperationsByType[entry.Operation]++
 	m.LastOperationTime = entry.Timestamp
 }
+
+func (m *MetricsCollector) observeLatency(operation string, d time.Duration) {
+	h, ok := m.latencies[operation]
+	if !ok {
+		h = &latencyHistogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[operation] = h
+	}
+	h.observe(d.Seconds())
+
+	m.OperationLatencies = append(m.OperationLatencies, d)
+	if len(m.OperationLatencies) > maxLatencySamples {
+		m.OperationLatencies = m.OperationLatencies[len(m.OperationLatencies)-maxLatencySamples:]
+	}
+}
+
+func (m *MetricsCollector) TransferStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.InFlightTransfers++
+}
+
+func (m *MetricsCollector) TransferFinished() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.InFlightTransfers > 0 {
+		m.InFlightTransfers--
+	}
+}
+
+func (m *MetricsCollector) AddQueueDepth(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.QueueDepth += int64(delta)
+	if m.QueueDepth < 0 {
+		m.QueueDepth = 0
+	}
+}
+
+func (m *MetricsCollector) job(name string) *jobMetrics {
+	j, ok := m.jobs[name]
+	if !ok {
+		j = &jobMetrics{runs: make(map[string]int64)}
+		m.jobs[name] = j
+	}
+	return j
+}
+
+// JobStarted marks a scheduled job as running.
+func (m *MetricsCollector) JobStarted(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j := m.job(name)
+	j.running = true
+	j.lastRun = time.Now()
+}
+
+// JobFinished records the outcome of a scheduled job run.
+func (m *MetricsCollector) JobFinished(name string, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j := m.job(name)
+	j.running = false
+	j.lastFailed = failed
+	if failed {
+		j.runs["failed"]++
+	} else {
+		j.runs["succeeded"]++
+		j.lastSuccess = time.Now()
+	}
+}
+
+// JobSkipped records a scheduled run that was skipped because the previous
+// run of the same job was still going.
+func (m *MetricsCollector) JobSkipped(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.job(name).runs["skipped"]++
+}
+
+// WritePrometheus writes the current metrics in the Prometheus text
+// exposition format.
+func (m *MetricsCollector) WritePrometheus(w io.Writer) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var b strings.Builder
+
+	writeHeader(&b, "datasyncer_operations_total", "counter", "Total number of logged operations.")
+	fmt.Fprintf(&b, "datasyncer_operations_total %d\n", m.TotalOperations)
+
+	writeHeader(&b, "datasyncer_operations_failed_total", "counter", "Total number of operations that reported an error.")
+	fmt.Fprintf(&b, "datasyncer_operations_failed_total %d\n", m.FailedOperations)
+
+	writeHeader(&b, "datasyncer_operations_by_type_total", "counter", "Logged operations by operation type.")
+	for _, op := range sortedKeys(m.OperationsByType) {
+		fmt.Fprintf(&b, "datasyncer_operations_by_type_total{operation=\"%s\"} %d\n", escapeLabel(op), m.OperationsByType[op])
+	}
+
+	writeHeader(&b, "datasyncer_bytes_transferred_total", "counter", "Total bytes transferred.")
+	fmt.Fprintf(&b, "datasyncer_bytes_transferred_total %d\n", m.BytesTransferred)
+
+	writeHeader(&b, "datasyncer_provider_bytes_total", "counter", "Bytes uploaded, by destination cloud provider.")
+	for _, p := range sortedKeys(m.BytesByProvider) {
+		fmt.Fprintf(&b, "datasyncer_provider_bytes_total{provider=\"%s\"} %d\n", escapeLabel(p), m.BytesByProvider[p])
+	}
+
+	writeHeader(&b, "datasyncer_transfers_in_flight", "gauge", "Number of transfers currently in progress.")
+	fmt.Fprintf(&b, "datasyncer_transfers_in_flight %d\n", m.InFlightTransfers)
+
+	writeHeader(&b, "datasyncer_queue_depth", "gauge", "Number of files waiting to be transferred.")
+	fmt.Fprintf(&b, "datasyncer_queue_depth %d\n", m.QueueDepth)
+
+	writeHeader(&b, "datasyncer_last_operation_timestamp_seconds", "gauge", "Unix time of the last logged operation.")
+	var last float64
+	if !m.LastOperationTime.IsZero() {
+		last = float64(m.LastOperationTime.UnixNano()) / 1e9
+	}
+	fmt.Fprintf(&b, "datasyncer_last_operation_timestamp_seconds %g\n", last)
+
+	writeHeader(&b, "datasyncer_operation_duration_seconds", "histogram", "Operation latency by operation type.")
+	ops := make([]string, 0, len(m.latencies))
+	for op := range m.latencies {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		h := m.latencies[op]
+		label := escapeLabel(op)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "datasyncer_operation_duration_seconds_bucket{operation=\"%s\",le=\"%g\"} %d\n", label, bound, cumulative)
+		}
+		fmt.Fprintf(&b, "datasyncer_operation_duration_seconds_bucket{operation=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(&b, "datasyncer_operation_duration_seconds_sum{operation=\"%s\"} %g\n", label, h.sum)
+		fmt.Fprintf(&b, "datasyncer_operation_duration_seconds_count{operation=\"%s\"} %d\n", label, h.count)
+	}
+
+	if len(m.jobs) > 0 {
+		m.writeJobMetrics(&b)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (m *MetricsCollector) writeJobMetrics(b *strings.Builder) {
+	names := make([]string, 0, len(m.jobs))
+	for name := range m.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	unix := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	}
+	boolValue := func(v bool) int {
+		if v {
+			return 1
+		}
+		return 0
+	}
+
+	writeHeader(b, "datasyncer_job_runs_total", "counter", "Scheduled job runs by outcome.")
+	for _, name := range names {
+		runs := m.jobs[name].runs
+		for _, status := range sortedKeys(runs) {
+			fmt.Fprintf(b, "datasyncer_job_runs_total{job=\"%s\",status=\"%s\"} %d\n", escapeLabel(name), status, runs[status])
+		}
+	}
+
+	writeHeader(b, "datasyncer_job_running", "gauge", "Whether a scheduled job is currently running.")
+	for _, name := range names {
+		fmt.Fprintf(b, "datasyncer_job_running{job=\"%s\"} %d\n", escapeLabel(name), boolValue(m.jobs[name].running))
+	}
+
+	writeHeader(b, "datasyncer_job_last_run_failed", "gauge", "Whether the last completed run of a job failed.")
+	for _, name := range names {
+		fmt.Fprintf(b, "datasyncer_job_last_run_failed{job=\"%s\"} %d\n", escapeLabel(name), boolValue(m.jobs[name].lastFailed))
+	}
+
+	writeHeader(b, "datasyncer_job_last_run_timestamp_seconds", "gauge", "Unix time the last run of a job started.")
+	for _, name := range names {
+		fmt.Fprintf(b, "datasyncer_job_last_run_timestamp_seconds{job=\"%s\"} %g\n", escapeLabel(name), unix(m.jobs[name].lastRun))
+	}
+
+	writeHeader(b, "datasyncer_job_last_success_timestamp_seconds", "gauge", "Unix time the last successful run of a job finished.")
+	for _, name := range names {
+		fmt.Fprintf(b, "datasyncer_job_last_success_timestamp_seconds{job=\"%s\"} %g\n", escapeLabel(name), unix(m.jobs[name].lastSuccess))
+	}
+}
+
+// Handler returns an http.Handler that serves the metrics for scraping.
+func (m *MetricsCollector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := m.WritePrometheus(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,445 @@
+package types
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook payload formats.
+const (
+	WebhookGeneric = "generic"
+	WebhookSlack   = "slack"
+	WebhookTeams   = "teams"
+	WebhookDiscord = "discord"
+)
+
+// Rule conditions for NotificationRule.When.
+const (
+	NotifyAlways    = "always"
+	NotifyOnFailure = "failure"
+	NotifyOnSuccess = "success"
+)
+
+const (
+	defaultNotifyRetries = 3
+	defaultDedupWindow   = 10 * time.Minute
+	emailChannelName     = "email"
+)
+
+// NotificationChannel delivers a notification to one destination.
+type NotificationChannel interface {
+	Name() string
+	Deliver(ctx context.Context, n Notification) error
+}
+
+type WebhookConfig struct {
+	Name string `mapstructure:"name"`
+	URL  string `mapstructure:"url"`
+	// Format selects the JSON payload: generic, slack, teams or discord.
+	Format string `mapstructure:"format"`
+	// Secret, when set, signs the timestamp and body with HMAC-SHA256 in
+	// the X-Datasyncer-Signature header.
+	Secret  string            `mapstructure:"secret"`
+	Headers map[string]string `mapstructure:"headers"`
+	Timeout time.Duration     `mapstructure:"timeout"`
+}
+
+type CommandHookConfig struct {
+	Name    string        `mapstructure:"name"`
+	Command []string      `mapstructure:"command"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// NotificationRule decides which channels hear about a notification. A
+// notification goes to a rule's channels when every condition set on the
+// rule matches; an empty Channels list means all channels.
+type NotificationRule struct {
+	Channels []string `mapstructure:"channels"`
+	// When is "always", "failure" or "success".
+	When string `mapstructure:"when"`
+	// MinDuration only matches runs that took at least this long.
+	MinDuration time.Duration `mapstructure:"min_duration"`
+	// MinFailedFiles only matches runs with at least this many failures.
+	MinFailedFiles int `mapstructure:"min_failed_files"`
+}
+
+// Validate reports an unknown When condition and channel names that are
+// not in channels.
+func (r NotificationRule) Validate(channels []NotificationChannel) error {
+	switch strings.ToLower(r.When) {
+	case "", NotifyAlways, NotifyOnFailure, NotifyOnSuccess:
+	default:
+		return fmt.Errorf("when must be always, failure or success, got %q", r.When)
+	}
+	for _, name := range r.Channels {
+		found := false
+		for _, channel := range channels {
+			if channel.Name() == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown channel %q", name)
+		}
+	}
+	return nil
+}
+
+func (r NotificationRule) matches(n Notification) bool {
+	switch strings.ToLower(r.When) {
+	case NotifyOnFailure:
+		if !n.Failed {
+			return false
+		}
+	case NotifyOnSuccess:
+		if n.Failed {
+			return false
+		}
+	}
+	if r.MinDuration > 0 && n.Duration < r.MinDuration {
+		return false
+	}
+	if r.MinFailedFiles > 0 && n.FailedFiles < r.MinFailedFiles {
+		return false
+	}
+	return true
+}
+
+// Send prints the notification and delivers it to every channel selected by
+// the routing rules, retrying failed deliveries and suppressing duplicates
+// seen within the dedup window.
+func (n *Notifier) Send(notification Notification) error {
+	if notification.Time.IsZero() {
+		notification.Time = time.Now()
+	}
+
+	fmt.Printf("[NOTIFICATION] %s: %s\n", notification.Title, notification.Message)
+
+	var errs []error
+	for _, channel := range n.route(notification) {
+		key := dedupKey(channel.Name(), notification)
+		if n.isDuplicate(key, notification.Time) {
+			continue
+		}
+		if err := n.deliver(channel, notification); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", channel.Name(), err))
+			continue
+		}
+		// Only delivered notifications are remembered, so a failed one is
+		// tried again the next time it is sent.
+		n.markSent(key, notification.Time)
+	}
+	return errors.Join(errs...)
+}
+
+// Channels returns every configured notification channel. An unnamed
+// webhook is named "webhook", or "webhook-<n>" for the nth webhook when
+// there are several, and command hooks likewise, so rules and duplicate
+// suppression can tell them apart.
+func (n *Notifier) Channels() []NotificationChannel {
+	var channels []NotificationChannel
+	if n.EmailConfig.Enabled() {
+		channels = append(channels, &emailChannel{config: n.EmailConfig, transport: n.Transport})
+	}
+	for i, hook := range n.Webhooks {
+		if hook.Name == "" && len(n.Webhooks) > 1 {
+			hook.Name = fmt.Sprintf("webhook-%d", i+1)
+		}
+		channels = append(channels, &WebhookChannel{Config: hook})
+	}
+	for i, hook := range n.Commands {
+		if hook.Name == "" && len(n.Commands) > 1 {
+			hook.Name = fmt.Sprintf("command-%d", i+1)
+		}
+		channels = append(channels, &CommandChannel{Config: hook})
+	}
+	return channels
+}
+
+func (n *Notifier) route(notification Notification) []NotificationChannel {
+	channels := n.Channels()
+	if len(n.Rules) == 0 {
+		return channels
+	}
+
+	selected := make(map[string]bool)
+	for _, rule := range n.Rules {
+		if !rule.matches(notification) {
+			continue
+		}
+		if len(rule.Channels) == 0 {
+			for _, c := range channels {
+				selected[c.Name()] = true
+			}
+			continue
+		}
+		for _, name := range rule.Channels {
+			selected[name] = true
+		}
+	}
+
+	var routed []NotificationChannel
+	for _, c := range channels {
+		if selected[c.Name()] {
+			routed = append(routed, c)
+		}
+	}
+	return routed
+}
+
+func dedupKey(channel string, notification Notification) string {
+	key := notification.Key
+	if key == "" {
+		key = notification.Title + "\x00" + notification.Message
+	}
+	return channel + "\x00" + key
+}
+
+// isDuplicate reports whether key was delivered within the dedup window
+// before now, forgetting keys older than the window.
+func (n *Notifier) isDuplicate(key string, now time.Time) bool {
+	window := n.DedupWindow
+	if window == 0 {
+		window = defaultDedupWindow
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for k, at := range n.sent {
+		if now.Sub(at) > window {
+			delete(n.sent, k)
+		}
+	}
+	_, seen := n.sent[key]
+	return seen
+}
+
+func (n *Notifier) markSent(key string, at time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.sent == nil {
+		n.sent = make(map[string]time.Time)
+	}
+	n.sent[key] = at
+}
+
+func (n *Notifier) deliver(channel NotificationChannel, notification Notification) error {
+	retries := n.MaxRetries
+	if retries == 0 {
+		retries = defaultNotifyRetries
+	}
+
+	var lastErr error
+	for i := 0; i < retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Second * time.Duration(i))
+		}
+		if lastErr = channel.Deliver(context.Background(), notification); lastErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed after %d attempts: %v", retries, lastErr)
+}
+
+type emailChannel struct {
+	config    EmailConfig
+	transport MailTransport
+}
+
+func (e *emailChannel) Name() string {
+	return emailChannelName
+}
+
+func (e *emailChannel) Deliver(ctx context.Context, n Notification) error {
+	msg, err := buildEmail(e.config, n)
+	if err != nil {
+		return err
+	}
+
+	transport := e.transport
+	if transport == nil {
+		transport = SMTPTransport{}
+	}
+	if err := transport.Send(e.config, e.config.Recipients, msg); err != nil {
+		return fmt.Errorf("failed to send email notification: %v", err)
+	}
+	return nil
+}
+
+// notificationPayload is the generic JSON form of a notification, used for
+// generic webhooks and as stdin for command hooks.
+type notificationPayload struct {
+	Title           string  `json:"title"`
+	Message         string  `json:"message"`
+	Time            string  `json:"time"`
+	Failed          bool    `json:"failed"`
+	FailedFiles     int     `json:"failed_files"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Report          any     `json:"report,omitempty"`
+}
+
+func newNotificationPayload(n Notification) notificationPayload {
+	return notificationPayload{
+		Title:           n.Title,
+		Message:         n.Message,
+		Time:            n.Time.Format(time.RFC3339),
+		Failed:          n.Failed,
+		FailedFiles:     n.FailedFiles,
+		DurationSeconds: n.Duration.Seconds(),
+		Report:          n.Report,
+	}
+}
+
+type WebhookChannel struct {
+	Config WebhookConfig
+	Client *http.Client
+}
+
+func (w *WebhookChannel) Name() string {
+	if w.Config.Name != "" {
+		return w.Config.Name
+	}
+	return "webhook"
+}
+
+func (w *WebhookChannel) payload(n Notification) any {
+	switch strings.ToLower(w.Config.Format) {
+	case WebhookSlack:
+		return map[string]any{"text": fmt.Sprintf("*%s*\n%s", n.Title, n.Message)}
+	case WebhookDiscord:
+		return map[string]any{"content": fmt.Sprintf("**%s**\n%s", n.Title, n.Message)}
+	case WebhookTeams:
+		color := "2EB886"
+		if n.Failed {
+			color = "D00000"
+		}
+		return map[string]any{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    n.Title,
+			"title":      n.Title,
+			"text":       n.Message,
+			"themeColor": color,
+		}
+	default:
+		return newNotificationPayload(n)
+	}
+}
+
+func (w *WebhookChannel) Deliver(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(w.payload(n))
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %v", err)
+	}
+
+	timeout := w.Config.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "datasyncer")
+	for k, v := range w.Config.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.Config.Secret != "" {
+		timestamp := strconv.FormatInt(n.Time.Unix(), 10)
+		req.Header.Set("X-Datasyncer-Timestamp", timestamp)
+		req.Header.Set("X-Datasyncer-Signature", "sha256="+SignPayload(w.Config.Secret, timestamp, body))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// SignPayload returns the hex HMAC-SHA256 of "timestamp.body" keyed by
+// secret, as sent in the X-Datasyncer-Signature header. Receivers should
+// recompute it and compare with hmac.Equal.
+func SignPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CommandChannel runs a local command for each notification. The generic
+// JSON payload is written to its stdin and the main fields are exported as
+// DATASYNCER_* environment variables.
+type CommandChannel struct {
+	Config CommandHookConfig
+}
+
+func (c *CommandChannel) Name() string {
+	if c.Config.Name != "" {
+		return c.Config.Name
+	}
+	return "command"
+}
+
+func (c *CommandChannel) Deliver(ctx context.Context, n Notification) error {
+	if len(c.Config.Command) == 0 {
+		return fmt.Errorf("command hook has no command")
+	}
+
+	body, err := json.Marshal(newNotificationPayload(n))
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+
+	timeout := c.Config.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Config.Command[0], c.Config.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"DATASYNCER_TITLE="+n.Title,
+		"DATASYNCER_MESSAGE="+n.Message,
+		"DATASYNCER_FAILED="+strconv.FormatBool(n.Failed),
+		"DATASYNCER_FAILED_FILES="+strconv.Itoa(n.FailedFiles),
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("command hook failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Storage tiers are provider-neutral names for storage classes, from the
+// most to the least expensive to store.
+const (
+	TierHot     = "hot"
+	TierCool    = "cool"
+	TierCold    = "cold"
+	TierArchive = "archive"
+)
+
+// Tiers lists the storage tiers, hottest first.
+var Tiers = []string{TierHot, TierCool, TierCold, TierArchive}
+
+type storageClassTier struct {
+	class string
+	tier  string
+}
+
+// storageClasses are the classes each provider offers with their tiers.
+// The first class of each tier is the one a tier maps to by default.
+var storageClasses = map[CloudProvider][]storageClassTier{
+	AWS: {
+		{"STANDARD", TierHot},
+		{"INTELLIGENT_TIERING", TierHot},
+		{"REDUCED_REDUNDANCY", TierHot},
+		{"EXPRESS_ONEZONE", TierHot},
+		{"STANDARD_IA", TierCool},
+		{"ONEZONE_IA", TierCool},
+		{"GLACIER_IR", TierCold},
+		{"DEEP_ARCHIVE", TierArchive},
+		{"GLACIER", TierArchive},
+	},
+	GCP: {
+		{"STANDARD", TierHot},
+		{"MULTI_REGIONAL", TierHot},
+		{"REGIONAL", TierHot},
+		{"DURABLE_REDUCED_AVAILABILITY", TierHot},
+		{"NEARLINE", TierCool},
+		{"COLDLINE", TierCold},
+		{"ARCHIVE", TierArchive},
+	},
+	AZURE: {
+		{"Hot", TierHot},
+		{"Cool", TierCool},
+		{"Cold", TierCold},
+		{"Archive", TierArchive},
+	},
+}
+
+// StorageRule picks the storage class of the files it matches.
+type StorageRule struct {
+	// Include holds glob patterns matched against keys relative to the
+	// sync root, as for sync filters. An empty list matches every file.
+	Include []string `mapstructure:"include"`
+	// OlderThan only matches files last modified at least this long ago.
+	OlderThan time.Duration `mapstructure:"older_than"`
+	// StorageClass is a tier or a provider's class name.
+	StorageClass string `mapstructure:"storage_class"`
+}
+
+// StorageTier returns the tier named by a tier or by any provider's class,
+// or "" when the name is unknown.
+func StorageTier(name string) string {
+	for _, tier := range Tiers {
+		if strings.EqualFold(name, tier) {
+			return tier
+		}
+	}
+	for _, classes := range storageClasses {
+		for _, c := range classes {
+			if strings.EqualFold(name, c.class) {
+				return c.tier
+			}
+		}
+	}
+	return ""
+}
+
+// StorageClassFor returns the class provider should store a file in, given
+// a tier or class name. A class of provider itself is kept; a tier maps
+// through overrides (tier to class) and then provider's defaults; another
+// provider's class maps through its tier. Unknown names are passed through
+// for the provider to accept or reject, and local files have no class.
+func StorageClassFor(provider CloudProvider, overrides map[string]string, name string) string {
+	if name == "" || provider == LOCAL {
+		return ""
+	}
+	for _, c := range storageClasses[provider] {
+		if strings.EqualFold(name, c.class) {
+			return c.class
+		}
+	}
+
+	tier := StorageTier(name)
+	if tier == "" {
+		return name
+	}
+	if class := overrides[tier]; class != "" {
+		return class
+	}
+	for _, c := range storageClasses[provider] {
+		if c.tier == tier {
+			return c.class
+		}
+	}
+	return name
+}
+
+// ValidateStorageClass reports an error for a name that is neither a tier
+// nor a known class of any provider.
+func ValidateStorageClass(name string) error {
+	if StorageTier(name) == "" {
+		return fmt.Errorf("unknown storage class %q: use hot, cool, cold, archive or a provider class such as STANDARD_IA, NEARLINE or Cool", name)
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package types
 import (
 	"context"
 	"fmt"
+	"io"
+	"sync"
 	"time"
 )
 
@@ -12,26 +14,170 @@ const (
 	AWS   CloudProvider = "aws"
 	GCP   CloudProvider = "gcp"
 	AZURE CloudProvider = "azure"
+	LOCAL CloudProvider = "local"
 )
 
 type FileInfo struct {
-	Path         string
-	Size         int64
+	Path string
+	Size int64
+	// LastModified is when the provider stored the file.
 	LastModified time.Time
-	ETag         string
+	// ModTime is when the file's content was last modified at its origin,
+	// kept across copies in the mtime metadata key. It equals LastModified
+	// for files without one.
+	ModTime time.Time
+	ETag    string
+	// StorageClass is the provider's storage class or access tier, where
+	// the provider reports one.
+	StorageClass string
+	// Archived marks a file that cannot be read until it is restored, like
+	// S3 GLACIER objects and Azure Archive-tier blobs. Restoring is set
+	// while a restore is in progress.
+	Archived  bool `json:",omitempty"`
+	Restoring bool `json:",omitempty"`
+	// VersionID identifies one version of the file in a versioned bucket;
+	// it is only set by version-aware calls.
+	VersionID string `json:",omitempty"`
+
+	// Standard HTTP headers and user metadata stored with the file. S3
+	// listings do not include them; GetFileInfo always does.
+	ContentType        string            `json:",omitempty"`
+	ContentEncoding    string            `json:",omitempty"`
+	CacheControl       string            `json:",omitempty"`
+	ContentDisposition string            `json:",omitempty"`
+	Metadata           map[string]string `json:",omitempty"`
+}
+
+// HasMetadata reports whether any header or user metadata is set.
+func (f FileInfo) HasMetadata() bool {
+	return f.ContentType != "" || f.ContentEncoding != "" || f.CacheControl != "" ||
+		f.ContentDisposition != "" || len(f.Metadata) > 0
+}
+
+// DirEntry is one entry of a hierarchical listing: a file, or a common
+// prefix standing for everything below it, like a directory.
+type DirEntry struct {
+	FileInfo
+	// IsPrefix marks a common prefix; only Path is set and it ends with the
+	// delimiter.
+	IsPrefix bool
 }
 
 type CloudStorage interface {
 	Authenticate(ctx context.Context) error
 
 	ListFiles(ctx context.Context, path string) ([]FileInfo, error)
-	UploadFile(ctx context.Context, localPath, remotePath string) error
+	// ListIter calls fn for each file under path as listing pages arrive,
+	// so large listings are never held in memory. It stops at and returns
+	// the first error fn returns.
+	ListIter(ctx context.Context, path string, fn func(FileInfo) error) error
+	// ListDir lists one level below prefix: files whose key has no
+	// delimiter after the prefix, and one common prefix for each group of
+	// deeper keys.
+	ListDir(ctx context.Context, prefix, delimiter string, fn func(DirEntry) error) error
+	// UploadFile uploads localPath to remotePath, storing info's headers
+	// and user metadata with it where the provider supports them.
+	UploadFile(ctx context.Context, localPath, remotePath string, info FileInfo) error
 	DownloadFile(ctx context.Context, remotePath, localPath string) error
 	DeleteFile(ctx context.Context, path string) error
+	// OpenFile opens the file at path for reading without staging it on
+	// disk. The caller closes the reader.
+	OpenFile(ctx context.Context, path string) (io.ReadCloser, error)
 
 	GetFileInfo(ctx context.Context, path string) (FileInfo, error)
 }
 
+// Prober is implemented by providers that can cheaply check that their
+// credentials grant access, typically by listing at most one object.
+type Prober interface {
+	Probe(ctx context.Context) error
+}
+
+// FileVersion is one version of a file in a versioned bucket.
+type FileVersion struct {
+	FileInfo
+	// IsLatest marks the key's current version.
+	IsLatest bool
+	// DeleteMarker marks a deletion recorded as a version; only Path,
+	// VersionID and LastModified are set.
+	DeleteMarker bool
+	// Deleted is when the version stopped being current, where the
+	// provider reports it.
+	Deleted time.Time
+}
+
+// Versioner is implemented by providers that keep earlier versions of
+// files.
+type Versioner interface {
+	// ListVersions calls fn for every version under prefix, including
+	// delete markers. The versions of one key are passed consecutively.
+	ListVersions(ctx context.Context, prefix string, fn func(FileVersion) error) error
+	// GetVersionInfo is GetFileInfo for one version of a file.
+	GetVersionInfo(ctx context.Context, path, versionID string) (FileInfo, error)
+	// DownloadVersion is DownloadFile for one version of a file.
+	DownloadVersion(ctx context.Context, remotePath, versionID, localPath string) error
+}
+
+// Restorer is implemented by providers whose archived files must be
+// restored before they can be read.
+type Restorer interface {
+	// RestoreFile requests a readable copy of an archived file. Asking
+	// again while a restore is in progress is not an error.
+	RestoreFile(ctx context.Context, path string, opts RestoreOptions) error
+}
+
+// RestoreOptions control restore requests and how long a sync waits for
+// restored files to become readable.
+type RestoreOptions struct {
+	// Days is how long S3 keeps the restored copy. Azure rehydrates blobs
+	// to the Cool tier, where they stay.
+	Days int `mapstructure:"days"`
+	// Priority is "bulk", "standard" or "expedited". Azure has no bulk
+	// priority and rehydrates expedited requests with high priority.
+	Priority string `mapstructure:"priority"`
+	// PollInterval is how often files waiting for a restore are checked.
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	// Timeout bounds the wait; files still being restored are left for a
+	// later run. Zero waits until the sync is interrupted.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Validate reports invalid restore options.
+func (o RestoreOptions) Validate() error {
+	switch o.Priority {
+	case "", "bulk", "standard", "expedited":
+	default:
+		return fmt.Errorf("restore priority must be bulk, standard or expedited, got %q", o.Priority)
+	}
+	if o.Days < 0 || o.PollInterval < 0 || o.Timeout < 0 {
+		return fmt.Errorf("restore days, poll_interval and timeout must not be negative")
+	}
+	return nil
+}
+
+// RetentionPolicy decides which backup snapshots prune keeps. Each rule
+// keeps the newest snapshot of that many of the most recent periods that
+// have one; a snapshot is kept when any rule keeps it.
+type RetentionPolicy struct {
+	KeepLast    int `mapstructure:"keep_last"`
+	KeepDaily   int `mapstructure:"keep_daily"`
+	KeepWeekly  int `mapstructure:"keep_weekly"`
+	KeepMonthly int `mapstructure:"keep_monthly"`
+}
+
+// IsZero reports whether the policy has no rules.
+func (p RetentionPolicy) IsZero() bool {
+	return p == RetentionPolicy{}
+}
+
+// Validate reports negative counts.
+func (p RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 || p.KeepMonthly < 0 {
+		return fmt.Errorf("retention counts must not be negative")
+	}
+	return nil
+}
+
 type SyncOptions struct {
 	SourceProvider      CloudProvider
 	DestinationProvider CloudProvider
@@ -40,31 +186,89 @@ type SyncOptions struct {
 	Parallel            int
 	ConflictResolution  string
 	IncrementalSync     bool
+	ReportPath          string
+	// Include and Exclude are glob patterns applied to keys relative to
+	// SourcePath. When Include is set only matching keys are synced.
+	Include []string
+	Exclude []string
+	// Mirror deletes destination files that no longer exist at the source.
+	Mirror bool
+	// StorageClass is the tier or class files are uploaded in; empty keeps
+	// the source's tier. StorageRules take precedence for the files they
+	// match, in order.
+	StorageClass string
+	StorageRules []StorageRule
+	// Restore requests restores of archived source files and waits for
+	// them before copying; without it archived files fail.
+	Restore        bool
+	RestoreOptions RestoreOptions
+	// AllVersions replays every version of each source file to the
+	// destination, oldest first, instead of copying only the current one.
+	AllVersions bool
 }
 
 type Notifier struct {
 	EmailConfig EmailConfig
+	// Transport delivers email; SMTPTransport is used when nil.
+	Transport MailTransport
+	Webhooks  []WebhookConfig
+	Commands  []CommandHookConfig
+	// Rules route notifications to channels. Without rules every
+	// notification goes to every channel.
+	Rules []NotificationRule
+	// DedupWindow suppresses repeats of the same notification on a channel.
+	DedupWindow time.Duration
+	MaxRetries  int
+
+	mu   sync.Mutex
+	sent map[string]time.Time
 }
 
 func (n *Notifier) SendNotification(title, message string) {
-	// Implementation for sending notifications
-	// For now, just print to stdout
-	fmt.Printf("[NOTIFICATION] %s: %s\n", title, message)
+	n.Send(Notification{Title: title, Message: message})
 }
 
 type EmailConfig struct {
-	SMTPServer string
-	Port       int
-	Username   string
-	Password   string
-	FromEmail  string
+	SMTPServer string   `mapstructure:"smtp_server"`
+	Port       int      `mapstructure:"port"`
+	Username   string   `mapstructure:"username"`
+	Password   string   `mapstructure:"password"`
+	FromEmail  string   `mapstructure:"from_email"`
+	Recipients []string `mapstructure:"recipients"`
+	// TLSMode is "starttls", "tls" (implicit TLS) or "none". Defaults to
+	// implicit TLS on port 465 and STARTTLS otherwise.
+	TLSMode string `mapstructure:"tls_mode"`
+	// AuthMethod is "plain", "login" or "none". Defaults to plain when a
+	// username is set.
+	AuthMethod         string `mapstructure:"auth_method"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	SubjectTemplate    string `mapstructure:"subject_template"`
+	BodyTemplate       string `mapstructure:"body_template"`
 }
 
 type ProviderConfig struct {
-	Type          CloudProvider
-	Bucket        string
-	ProjectID     string // For GCP
-	AccountName   string // For Azure
-	AccountKey    string // For Azure
-	ContainerName string // For Azure
+	Type            CloudProvider `json:"type" mapstructure:"type"`
+	Bucket          string        `json:"bucket,omitempty" mapstructure:"bucket"`
+	ProjectID       string        `json:"project_id,omitempty" mapstructure:"project_id"`             // For GCP: optional, unused; older configs required it
+	QuotaProject    string        `json:"quota_project,omitempty" mapstructure:"quota_project"`       // For GCP: project billed for requests
+	AccountName     string        `json:"account_name,omitempty" mapstructure:"account_name"`         // For Azure
+	AccountKey      string        `json:"account_key,omitempty" mapstructure:"account_key"`           // For Azure
+	ContainerName   string        `json:"container_name,omitempty" mapstructure:"container_name"`     // For Azure
+	Profile         string        `json:"profile,omitempty" mapstructure:"profile"`                   // For AWS shared config
+	CredentialsFile string        `json:"credentials_file,omitempty" mapstructure:"credentials_file"` // AWS shared credentials or GCP service account key
+	SASToken        string        `json:"sas_token,omitempty" mapstructure:"sas_token"`               // For Azure
+	Region          string        `json:"region,omitempty" mapstructure:"region"`                     // For AWS
+	// Endpoint overrides the service URL, e.g. for MinIO, fake-gcs-server
+	// or Azurite.
+	Endpoint  string `json:"endpoint,omitempty" mapstructure:"endpoint"`
+	PathStyle bool   `json:"path_style,omitempty" mapstructure:"path_style"` // For AWS S3-compatible endpoints
+	// ManagedIdentity authenticates to Azure with the VM or workload
+	// identity instead of a key or SAS token.
+	ManagedIdentity         bool   `json:"managed_identity,omitempty" mapstructure:"managed_identity"`
+	ManagedIdentityClientID string `json:"managed_identity_client_id,omitempty" mapstructure:"managed_identity_client_id"`
+	// Path is the root directory of a local remote.
+	Path string `json:"path,omitempty" mapstructure:"path"`
+	// StorageClasses overrides the class each storage tier maps to for
+	// this provider. It comes from the config's storage_classes table.
+	StorageClasses map[string]string `json:"-" mapstructure:"-"`
 }